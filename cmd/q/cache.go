@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"q/internal/config"
+	"q/internal/respcache"
+)
+
+// Output formats of one-shot prompts.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// jsonResult is a response printed with --output json.
+type jsonResult struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	Response string `json:"response"`
+	Cached   bool   `json:"cached"` // answered from the response cache
+}
+
+func printJSON(r jsonResult) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(string(data))
+	return err
+}
+
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("output", "o", outputText, "Output format: text or json (a JSON object with the response and metadata)")
+	addCacheFlags(cmd)
+}
+
+func addCacheFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("cache", false, "Reuse cached responses to identical requests, and cache new ones")
+	cmd.Flags().Bool("no-cache", false, "Do not use the response cache, even if cache.enabled is set")
+}
+
+// withCache returns ctx carrying the response cache if --cache, or
+// cache.enabled without --no-cache, asks for it.
+func (cli *CLI) withCache(ctx context.Context, cmd *cobra.Command) (context.Context, error) {
+	on, _ := cmd.Flags().GetBool("cache")
+	off, _ := cmd.Flags().GetBool("no-cache")
+	if on && off {
+		return nil, errors.New("give --cache or --no-cache, not both")
+	}
+	if off {
+		return ctx, nil
+	}
+	cfg, err := config.GetCache()
+	if err != nil {
+		return nil, err
+	}
+	if !on && !cfg.Enabled {
+		return ctx, nil
+	}
+	c, err := respcache.Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return respcache.NewContext(ctx, c), nil
+}
+
+func (cli *CLI) cacheCmd() *cobra.Command {
+	cmd := &cobra.Command{Use: "cache", Short: "Manage the response cache"}
+
+	stats := &cobra.Command{
+		Use:          "stats",
+		Short:        "Show what the response cache holds",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(*cobra.Command, []string) error {
+			cfg, err := config.GetCache()
+			if err != nil {
+				return err
+			}
+			c, err := respcache.Open(cfg)
+			if err != nil {
+				return err
+			}
+			s, err := c.Stats()
+			if err != nil {
+				return err
+			}
+			enabled := "with --cache"
+			if cfg.Enabled {
+				enabled = "always (cache.enabled)"
+			}
+			fmt.Printf("Entries:  %d\n", s.Entries)
+			fmt.Printf("Size:     %.1f MB of %d MB\n", float64(s.Size)/(1<<20), c.MaxSize()>>20)
+			fmt.Printf("Hits:     %d\n", s.Hits)
+			if s.Entries > 0 {
+				fmt.Printf("Oldest:   %s\n", s.Oldest.Local().Format(time.DateTime))
+				fmt.Printf("Newest:   %s\n", s.Newest.Local().Format(time.DateTime))
+			}
+			fmt.Printf("TTL:      %s\n", c.TTL())
+			fmt.Printf("Used:     %s\n", enabled)
+			fmt.Printf("Location: %s\n", c.Dir())
+			return nil
+		},
+	}
+
+	clear := &cobra.Command{
+		Use:          "clear",
+		Short:        "Delete every cached response",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(*cobra.Command, []string) error {
+			cfg, err := config.GetCache()
+			if err != nil {
+				return err
+			}
+			c, err := respcache.Open(cfg)
+			if err != nil {
+				return err
+			}
+			n, err := c.Clear()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Deleted %d cached responses\n", n)
+			return nil
+		},
+	}
+
+	cmd.AddCommand(stats, clear)
+	return cmd
+}
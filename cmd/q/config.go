@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"q/internal/config"
+)
+
+const redacted = "********"
+
+// redactKeys hides every stored API key, the q serve tokens, and the
+// headers and environment of MCP servers, in cfg.
+func redactKeys(cfg config.Config) config.Config {
+	hide := func(keys map[string]string) map[string]string {
+		if len(keys) == 0 {
+			return keys
+		}
+		out := make(map[string]string, len(keys))
+		for k := range keys {
+			out[k] = redacted
+		}
+		return out
+	}
+	cfg.APIKeys = hide(cfg.APIKeys)
+	profiles := make(map[string]config.Profile, len(cfg.Profiles))
+	for name, p := range cfg.Profiles {
+		p.APIKeys = hide(p.APIKeys)
+		profiles[name] = p
+	}
+	cfg.Profiles = profiles
+	cfg.Serve.Tokens = hide(cfg.Serve.Tokens)
+	if cfg.MCPServers != nil {
+		servers := make(map[string]config.MCPServerConfig, len(cfg.MCPServers))
+		for name, s := range cfg.MCPServers {
+			s.Headers, s.Env = hide(s.Headers), hide(s.Env)
+			servers[name] = s
+		}
+		cfg.MCPServers = servers
+	}
+	return cfg
+}
+
+// printResolved writes the effective settings, one per line, with where each
+// came from.
+func (cli *CLI) printResolved() error {
+	res, err := config.Resolve()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	row := func(key, value string) {
+		fmt.Fprintf(w, "%s\t%s\t(%s)\n", key, value, res.Sources[key])
+	}
+
+	row("profile", res.Profile)
+	if res.DefaultModel != "" {
+		row("default_model", res.DefaultModel)
+	}
+	if res.System != "" {
+		row("system", strconv.Quote(res.System))
+	}
+	if t := res.Params.Temperature; t != nil {
+		row("params.temperature", strconv.FormatFloat(*t, 'g', -1, 64))
+	}
+	if p := res.Params.TopP; p != nil {
+		row("params.top_p", strconv.FormatFloat(*p, 'g', -1, 64))
+	}
+	if n := res.Params.MaxTokens; n != 0 {
+		row("params.max_tokens", strconv.Itoa(n))
+	}
+	if res.Project != nil {
+		if res.Project.Templates != "" {
+			row("templates", res.TemplateDirs[0])
+		}
+		if len(res.ContextFiles) > 0 {
+			rel := make([]string, len(res.ContextFiles))
+			for i, f := range res.ContextFiles {
+				rel[i] = f
+				if r, err := filepath.Rel(res.Project.Dir(), f); err == nil {
+					rel[i] = filepath.ToSlash(r)
+				}
+			}
+			row("context", strings.Join(rel, ", "))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(res.Aliases)) {
+		row("aliases."+name, res.Aliases[name])
+	}
+	for _, model := range slices.Sorted(maps.Keys(res.Fallbacks)) {
+		row("fallbacks."+model, strings.Join(res.Fallbacks[model], ", "))
+	}
+	for _, name := range cli.registry.Names() {
+		key, source, err := config.LookupAPIKey(name)
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "api_keys.%s\t⚠️  %s\t\n", name, firstLine(err))
+		case key != "":
+			fmt.Fprintf(w, "api_keys.%s\t%s\t(%s)\n", name, redacted, source)
+		}
+	}
+	return w.Flush()
+}
+
+func (cli *CLI) configCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect and change configuration",
+		Long: "Settings come from config.json (see q keys path) and, inside a project,\n" +
+			"from the nearest .q.json or .q.toml in the working directory or a parent.\n" +
+			"Project files may set default_model, system, params, templates and\n" +
+			"context, but never API keys.",
+	}
+
+	show := &cobra.Command{
+		Use:          "show",
+		Short:        "Print the configuration with API keys, tokens and MCP server secrets redacted",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if resolved, _ := cmd.Flags().GetBool("resolved"); resolved {
+				return cli.printResolved()
+			}
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
+			cfg.Comment = ""
+			data, err := json.MarshalIndent(redactKeys(cfg), "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		},
+	}
+	show.Flags().Bool("resolved", false, "Show the effective settings, merged with the project config, and where each came from")
+
+	get := &cobra.Command{
+		Use:          "get KEY",
+		Short:        "Print one setting",
+		Example:      "  q config get params.temperature\n  q config get profiles.work.default_model",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
+			v, ok, err := cfg.Get(args[0])
+			switch {
+			case err != nil:
+				return err
+			case !ok:
+				return fmt.Errorf("%s is not set", args[0])
+			}
+			if s, isString := v.(string); isString {
+				fmt.Println(s)
+				return nil
+			}
+			data, err := json.MarshalIndent(v, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		},
+	}
+
+	set := &cobra.Command{
+		Use:   "set KEY VALUE",
+		Short: "Change one setting",
+		Long: "Keys follow config.json, with dots between levels. Lists take comma\n" +
+			"separated values or a JSON array. Values are checked before saving.",
+		Example: `  q config set params.temperature 0.2
+  q config set profiles.work.base_urls.openai https://llm-gateway.example.com/v1
+  q config set fallbacks.openai/gpt-4o openai/gpt-4o-mini,openai/gpt-4.1-mini`,
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			key, value := args[0], args[1]
+			if key == "default_model" || strings.HasSuffix(key, ".default_model") {
+				providerName, modelName, err := cli.validateModel(value)
+				if err != nil {
+					return err
+				}
+				value = providerName + "/" + modelName
+			}
+			if err := config.Update(func(cfg *config.Config) error { return cfg.Set(key, value) }); err != nil {
+				return err
+			}
+			fmt.Printf("Set %s\n", key)
+			return nil
+		},
+	}
+
+	unset := &cobra.Command{
+		Use:          "unset KEY",
+		Short:        "Remove one setting",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			if err := config.Update(func(cfg *config.Config) error { return cfg.Unset(args[0]) }); err != nil {
+				return err
+			}
+			fmt.Printf("Unset %s\n", args[0])
+			return nil
+		},
+	}
+
+	edit := &cobra.Command{
+		Use:          "edit",
+		Short:        "Edit config.json in $EDITOR",
+		Long:         "Opens a copy of config.json and saves it back only if it is valid.",
+		SilenceUsage: true,
+		RunE: func(*cobra.Command, []string) error {
+			return editConfig()
+		},
+	}
+
+	validate := &cobra.Command{
+		Use:          "validate",
+		Short:        "Check config.json and the project config for errors",
+		SilenceUsage: true,
+		RunE: func(*cobra.Command, []string) error {
+			path, err := config.ConfigPath()
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(path); err == nil {
+				if err := config.ValidateFile(path); err != nil {
+					return err
+				}
+				fmt.Printf("%s: ok\n", path)
+			}
+			proj, err := config.LoadProject()
+			if err != nil {
+				return err
+			}
+			if proj != nil {
+				if _, err := proj.ContextFiles(); err != nil {
+					return err
+				}
+				if _, err := proj.TemplateDir(); err != nil {
+					return err
+				}
+				fmt.Printf("%s: ok\n", proj.Path)
+			}
+			return nil
+		},
+	}
+
+	path := &cobra.Command{
+		Use:          "path",
+		Short:        "Show path to config.json",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if project, _ := cmd.Flags().GetBool("project"); project {
+				wd, err := os.Getwd()
+				if err != nil {
+					return err
+				}
+				path, err := config.FindProject(wd)
+				if err != nil {
+					return err
+				}
+				if path == "" {
+					return errors.New("no .q.json or .q.toml in this directory or its parents")
+				}
+				fmt.Println(path)
+				return nil
+			}
+			path, err := config.ConfigPath()
+			if err != nil {
+				return err
+			}
+			fmt.Println(path)
+			return nil
+		},
+	}
+	path.Flags().Bool("project", false, "Show the project config file instead")
+
+	cmd.AddCommand(show, get, set, unset, edit, validate, path)
+	return cmd
+}
+
+// editConfig opens a copy of config.json in the editor and, once it parses
+// and validates, replaces the stored config with it. An invalid copy is kept
+// so the changes are not lost.
+func editConfig() error {
+	path, err := config.ConfigPath()
+	if err != nil {
+		return err
+	}
+	// Loading migrates an older file, so the copy edited is the file that
+	// saving checks against.
+	if _, err := config.LoadConfig(); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		data, err = []byte("{}\n"), nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "config.*.json") // CreateTemp uses mode 0600
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := openEditor(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	edited, err := os.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.ParseConfig(edited)
+	if err != nil {
+		return fmt.Errorf("invalid config, not saved: %w\n\nYour edits are in %s", err, tmp)
+	}
+	// Saving replaces the whole file, so refuse if anything else wrote it
+	// while the editor was open.
+	err = config.Update(func(c *config.Config) error {
+		current, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			current, err = []byte("{}\n"), nil
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current, data) {
+			return fmt.Errorf("%s changed while you were editing it, not saved\n\nYour edits are in %s", path, tmp)
+		}
+		*c = cfg
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	os.Remove(tmp)
+	fmt.Printf("Saved %s\n", path)
+	return nil
+}
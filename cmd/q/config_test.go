@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"q/internal/config"
+)
+
+func TestEditConfig_Legacy(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	path, err := config.ConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	// No schema_version: the first load migrates the file.
+	if err := os.WriteFile(path, []byte(`{"default_model": "openai/gpt-4o"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VISUAL", "sed -i s/gpt-4o/o3/")
+
+	captureOutput(t, func() { err = editConfig() })
+	if err != nil {
+		t.Fatalf("editConfig of a legacy config: %v", err)
+	}
+	if model, _ := config.GetDefaultModel(); model != "openai/o3" {
+		t.Errorf("default model %q after the edit; want openai/o3", model)
+	}
+}
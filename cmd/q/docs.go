@@ -0,0 +1,283 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"q/internal/docindex"
+	"q/internal/images"
+)
+
+// docsInstructions is the system prompt of `q ask`.
+const docsInstructions = "Answer the question using the numbered excerpts from the user's documents. " +
+	"Cite the excerpts you rely on as [1], [2] and so on. " +
+	"If they do not contain the answer, say so rather than guessing."
+
+func (cli *CLI) indexCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "index DIR",
+		Short: "Index a directory of text files for q ask --docs",
+		Long: "Cuts the text files under DIR into chunks, embeds them and stores the\n" +
+			"vectors in a local index named after the directory, or --name. Hidden\n" +
+			"files, binary files and files over 1 MB are left out. Indexing again\n" +
+			"only embeds the files that changed.",
+		Example: `  q index ~/runbooks
+  q index ./docs --name product-docs --include '*.md' --include '*.txt'`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			modelFlag, _ := cmd.Flags().GetString("model")
+			chunkSize, _ := cmd.Flags().GetInt("chunk-size")
+			include, _ := cmd.Flags().GetStringArray("include")
+			rebuild, _ := cmd.Flags().GetBool("rebuild")
+
+			root, err := filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+			if info, err := os.Stat(root); err != nil {
+				return err
+			} else if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", args[0])
+			}
+			if name == "" {
+				name = filepath.Base(root)
+			}
+
+			prev, err := docindex.Load(name)
+			switch {
+			case errors.Is(err, docindex.ErrNotFound):
+				prev = nil
+			case err != nil && !rebuild:
+				return fmt.Errorf("%w\n\nStart over: q index %s --name %s --rebuild", err, args[0], name)
+			}
+			if modelFlag == "" && prev != nil {
+				modelFlag = prev.Provider + "/" + prev.Model
+			}
+			provider, model, e, err := cli.resolveEmbedder(modelFlag)
+			if err != nil {
+				return err
+			}
+			if prev != nil && (rebuild || prev.Provider != provider || prev.Model != model ||
+				prev.ChunkSize != cmp.Or(chunkSize, docindex.DefaultChunkSize)) {
+				prev = nil
+			}
+
+			ctx := contextWithInterrupt()
+			opts := docindex.Options{ChunkSize: chunkSize, Include: include}
+			idx, stats, err := docindex.Build(ctx, root, opts, prev,
+				func(ctx context.Context, texts []string) ([][]float32, error) {
+					fmt.Fprintf(os.Stderr, "q: embedding %d chunks with %s/%s\n", len(texts), provider, model)
+					return e.Embed(ctx, model, texts)
+				})
+			if err != nil {
+				return err
+			}
+			if stats.Chunks == 0 {
+				return fmt.Errorf("no text files under %s", args[0])
+			}
+			idx.Name, idx.Provider, idx.Model, idx.UpdatedAt = name, provider, model, time.Now().UTC()
+			if err := docindex.Save(idx); err != nil {
+				return err
+			}
+			fmt.Printf("Indexed %d files (%d unchanged, %d skipped) into %d chunks as %s\n",
+				stats.Files, stats.Reused, stats.Skipped, stats.Chunks, name)
+			return nil
+		},
+	}
+	cmd.Flags().String("name", "", "Index name (default: the directory's name)")
+	cmd.Flags().StringP("model", "m", "", "Embedding provider/model or provider (default: the index's, or the default model's provider)")
+	cmd.Flags().Int("chunk-size", docindex.DefaultChunkSize, "Chunk size in bytes")
+	cmd.Flags().StringArray("include", nil, "Only index files whose name matches this pattern (repeatable)")
+	cmd.Flags().Bool("rebuild", false, "Embed every file again")
+
+	list := &cobra.Command{
+		Use:          "list",
+		Short:        "List document indexes",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(*cobra.Command, []string) error {
+			names, err := docindex.List()
+			if err != nil {
+				return err
+			}
+			if len(names) == 0 {
+				fmt.Println("No indexes. Create one: q index DIR")
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tFILES\tCHUNKS\tMODEL\tUPDATED\tROOT")
+			for _, name := range names {
+				idx, err := docindex.Load(name)
+				if err != nil {
+					fmt.Fprintf(w, "%s\t-\t-\t-\t-\t%s\n", name, firstLine(err))
+					continue
+				}
+				fmt.Fprintf(w, "%s\t%d\t%d\t%s/%s\t%s\t%s\n", name, len(idx.Files), len(idx.Chunks),
+					idx.Provider, idx.Model, idx.UpdatedAt.Local().Format(time.DateTime), idx.Root)
+			}
+			return w.Flush()
+		},
+	}
+	remove := &cobra.Command{
+		Use:          "rm NAME",
+		Short:        "Delete a document index",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			if err := docindex.Remove(args[0]); err != nil {
+				return err
+			}
+			fmt.Printf("Deleted index %s\n", args[0])
+			return nil
+		},
+	}
+	cmd.AddCommand(list, remove)
+	return cmd
+}
+
+// source is a chunk retrieved for `q ask` from the index called name.
+type source struct {
+	docindex.Hit
+	name, root string
+}
+
+// String returns the file and lines of s, for the user.
+func (s source) String() string {
+	return fmt.Sprintf("%s:%d-%d", filepath.Join(s.root, filepath.FromSlash(s.File)), s.StartLine, s.EndLine)
+}
+
+// label names s for the model without revealing where the index lives.
+func (s source) label() string {
+	return fmt.Sprintf("%s/%s:%d-%d", s.name, s.File, s.StartLine, s.EndLine)
+}
+
+// retrieve returns the k chunks of the named indexes most similar to
+// question, best first. Each index embeds the question with its own model.
+func (cli *CLI) retrieve(ctx context.Context, names []string, question string, k int) ([]source, error) {
+	var sources []source
+	for _, name := range names {
+		idx, err := docindex.Load(name)
+		if errors.Is(err, docindex.ErrNotFound) {
+			return nil, fmt.Errorf("%w\n\nCreate it: q index DIR --name %s\nSee available: q index list", err, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		_, model, e, err := cli.resolveEmbedder(idx.Provider + "/" + idx.Model)
+		if err != nil {
+			return nil, err
+		}
+		vectors, err := e.Embed(ctx, model, []string{question})
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range idx.Search(vectors[0], k) {
+			sources = append(sources, source{h, name, idx.Root})
+		}
+	}
+	slices.SortStableFunc(sources, func(a, b source) int { return cmp.Compare(b.Score, a.Score) })
+	return sources[:min(k, len(sources))], nil
+}
+
+// docsPrompt puts the retrieved excerpts, numbered for citation, before the
+// question.
+func docsPrompt(question string, sources []source) string {
+	var b strings.Builder
+	b.WriteString("Excerpts:\n\n")
+	for i, s := range sources {
+		fmt.Fprintf(&b, "[%d] %s\n%s\n\n", i+1, s.label(), strings.TrimRight(s.Text, "\n"))
+	}
+	b.WriteString("Question: ")
+	b.WriteString(question)
+	return b.String()
+}
+
+func (cli *CLI) askCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ask --docs NAME QUESTION",
+		Short: "Answer a question from indexed documents",
+		Long: "Finds the chunks of the --docs indexes closest in meaning to the question,\n" +
+			"sends them to the model with the question, and lists the files they came\n" +
+			"from. The model is asked to cite them as [1], [2] and so on. QUESTION\n" +
+			"\"-\" reads stdin.",
+		Example:      `  q ask --docs runbooks "How do I fail over the primary database?"`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := parseFlags(cmd)
+			if err != nil {
+				return err
+			}
+			names, _ := cmd.Flags().GetStringArray("docs")
+			k, _ := cmd.Flags().GetInt("top-k")
+			if len(names) == 0 {
+				return errors.New("--docs is required\n\nSee available: q index list")
+			}
+			if k < 1 {
+				return errors.New("top-k must be at least 1")
+			}
+
+			question := args[0]
+			if question == "-" {
+				if question, err = promptFromStdin(); err != nil {
+					return err
+				}
+			}
+			imgs, err := images.LoadAll(f.images)
+			if err != nil {
+				return err
+			}
+			chain, err := cli.resolveChain(cmd, f.model)
+			if err != nil {
+				return err
+			}
+
+			ctx, err := cli.withCache(contextWithInterrupt(), cmd)
+			if err != nil {
+				return err
+			}
+			sources, err := cli.retrieve(ctx, names, question, k)
+			if err != nil {
+				return err
+			}
+
+			req := userRequest("", docsPrompt(question, sources))
+			req.System = docsInstructions
+			req.Messages[0].Images = imgs
+			f.transport.set(&req)
+			if err := executePrompt(ctx, chain, req, f.raw, !f.noStream, f.output); err != nil {
+				return err
+			}
+
+			// With -r or JSON output the answer alone goes to stdout.
+			var w io.Writer = os.Stdout
+			if f.raw || f.output == outputJSON {
+				w = os.Stderr
+			}
+			fmt.Fprintln(w, "\nSources:")
+			for i, s := range sources {
+				fmt.Fprintf(w, "  [%d] %s (%.2f)\n", i+1, s, s.Score)
+			}
+			return nil
+		},
+	}
+	addCommonFlags(cmd)
+	addFallbackFlags(cmd)
+	addOutputFlags(cmd)
+	cmd.Flags().StringArray("docs", nil, "Index to search (repeatable)")
+	cmd.Flags().IntP("top-k", "k", 5, "Number of chunks to send")
+	return cmd
+}
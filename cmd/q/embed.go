@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"q/internal/config"
+	"q/internal/providers"
+)
+
+// Output formats of `q embed`.
+const (
+	formatJSONL  = "jsonl"
+	formatCSV    = "csv"
+	formatBinary = "binary"
+)
+
+var embedFormats = []string{formatJSONL, formatCSV, formatBinary}
+
+// embedInput is one text to embed. id is its record number, counted from 0,
+// or its file path.
+type embedInput struct {
+	id   string
+	text string
+	file bool
+	rec  map[string]any // the JSONL record text came from, if any
+}
+
+// resolveEmbedder picks the embedding model named by modelFlag: a
+// provider/model, a provider alone for its default embedding model, or
+// empty for the default embedding model of the default model's provider.
+func (cli *CLI) resolveEmbedder(modelFlag string) (provider, model string, e providers.Embedder, err error) {
+	provider, model, _ = strings.Cut(modelFlag, "/")
+	if provider == "" {
+		def, err := config.GetDefaultModel()
+		if err != nil {
+			return "", "", nil, err
+		}
+		if def == "" {
+			return "", "", nil, errors.New("no default model\n\nSpecify: --model provider/embedding-model")
+		}
+		provider, _, _ = strings.Cut(def, "/")
+	}
+
+	p, ok := cli.registry.Lookup(provider)
+	if !ok {
+		return "", "", nil, fmt.Errorf("unknown provider: %s\n\nSee available: q models list", provider)
+	}
+	if e, ok = p.(providers.Embedder); !ok || len(e.EmbeddingModels()) == 0 {
+		return "", "", nil, fmt.Errorf("%s does not offer embeddings", provider)
+	}
+	if model == "" {
+		model = e.EmbeddingModels()[0]
+	}
+	if err := cli.requireKey(provider); err != nil {
+		return "", "", nil, err
+	}
+	return provider, model, e, nil
+}
+
+// embedReader returns a function yielding up to n inputs at a time, from the
+// files, or from the records of stdin when there are none. JSONL records are
+// embedded by their field value. Blank records are yielded too, so output N
+// always answers input N.
+func embedReader(files []string, stdin io.Reader, delim, field string) (func(n int) ([]embedInput, error), error) {
+	if len(files) > 0 {
+		return func(n int) ([]embedInput, error) {
+			var batch []embedInput
+			for len(files) > 0 && len(batch) < n {
+				data, err := os.ReadFile(files[0])
+				if err != nil {
+					return nil, err
+				}
+				batch = append(batch, embedInput{id: files[0], text: string(data), file: true})
+				files = files[1:]
+			}
+			return batch, nil
+		}, nil
+	}
+
+	switch {
+	case delim == delimJSONL && field == "":
+		return nil, errors.New("--field is required with jsonl input")
+	case delim != delimJSONL && field != "":
+		return nil, errors.New("--field needs --delim jsonl")
+	}
+	next, err := newRecordReader(stdin, delim)
+	if err != nil {
+		return nil, err
+	}
+	i := 0
+	return func(n int) ([]embedInput, error) {
+		var batch []embedInput
+		for len(batch) < n {
+			rec, ok, err := next()
+			if err != nil || !ok {
+				return batch, err
+			}
+			in := embedInput{id: strconv.Itoa(i)}
+			if s, ok := rec.(string); ok {
+				in.text = s
+			} else {
+				obj, ok := rec.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("record %d: want a JSON object", i)
+				}
+				if in.text, ok = obj[field].(string); !ok {
+					return nil, fmt.Errorf("record %d: field %q is not a string", i, field)
+				}
+				in.rec = obj
+			}
+			batch = append(batch, in)
+			i++
+		}
+		return batch, nil
+	}, nil
+}
+
+// vectorWriter writes embeddings in one of embedFormats.
+type vectorWriter struct {
+	format string
+	w      *bufio.Writer
+	csv    *csv.Writer
+}
+
+func newVectorWriter(w io.Writer, format string) (*vectorWriter, error) {
+	if !slices.Contains(embedFormats, format) {
+		return nil, fmt.Errorf("unknown format %q\n\nUse one of: %s", format, strings.Join(embedFormats, ", "))
+	}
+	vw := &vectorWriter{format: format, w: bufio.NewWriter(w)}
+	if format == formatCSV {
+		vw.csv = csv.NewWriter(vw.w)
+	}
+	return vw, nil
+}
+
+// write writes the vector of in: a JSON object per line, a CSV row of the
+// input's id and the components, or the components as little-endian
+// float32s. A nil v, for a blank input, is written as a null embedding or a
+// row holding only the id; binary output has no way to mark it.
+func (vw *vectorWriter) write(in embedInput, v []float32) error {
+	switch vw.format {
+	case formatCSV:
+		row := make([]string, 0, len(v)+1)
+		row = append(row, in.id)
+		for _, f := range v {
+			row = append(row, strconv.FormatFloat(float64(f), 'g', -1, 32))
+		}
+		return vw.csv.Write(row)
+	case formatBinary:
+		if v == nil {
+			return fmt.Errorf("input %s is blank; binary output cannot skip a vector", in.id)
+		}
+		return binary.Write(vw.w, binary.LittleEndian, v)
+	}
+
+	var obj any
+	switch {
+	case in.rec != nil:
+		in.rec["embedding"] = v
+		obj = in.rec
+	case in.file:
+		obj = struct {
+			File      string    `json:"file"`
+			Embedding []float32 `json:"embedding"`
+		}{in.id, v}
+	default:
+		index, _ := strconv.Atoi(in.id)
+		obj = struct {
+			Index     int       `json:"index"`
+			Text      string    `json:"text"`
+			Embedding []float32 `json:"embedding"`
+		}{index, in.text, v}
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = vw.w.Write(append(b, '\n'))
+	return err
+}
+
+func (vw *vectorWriter) flush() error {
+	if vw.csv != nil {
+		vw.csv.Flush()
+		if err := vw.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return vw.w.Flush()
+}
+
+func (cli *CLI) embedCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "embed [file...]",
+		Short: "Turn text into embedding vectors",
+		Long: "Embeds each file given, or else each stdin record: every line by default,\n" +
+			"or a field of each JSONL object. Vectors are written in input order as\n" +
+			"JSONL, as CSV rows led by the record number or file, or as raw\n" +
+			"little-endian float32s with nothing between vectors. Blank inputs are\n" +
+			"not sent; they get a null embedding or an empty CSV row, keeping input\n" +
+			"and output aligned, and are an error with binary output.",
+		Example: `  q embed < sentences.txt > vectors.jsonl
+  q embed -d jsonl --field body < issues.jsonl > issues-embedded.jsonl
+  q embed --format binary -m openai/text-embedding-3-large docs/*.md > docs.f32`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			modelFlag, _ := cmd.Flags().GetString("model")
+			delim, _ := cmd.Flags().GetString("delim")
+			field, _ := cmd.Flags().GetString("field")
+			format, _ := cmd.Flags().GetString("format")
+			batchSize, _ := cmd.Flags().GetInt("batch")
+			if batchSize < 1 {
+				return errors.New("batch must be at least 1")
+			}
+
+			vw, err := newVectorWriter(os.Stdout, format)
+			if err != nil {
+				return err
+			}
+			read, err := embedReader(args, os.Stdin, delim, field)
+			if err != nil {
+				return err
+			}
+			_, model, e, err := cli.resolveEmbedder(modelFlag)
+			if err != nil {
+				return err
+			}
+
+			ctx := contextWithInterrupt()
+			return embedAll(ctx, e, model, batchSize, read, vw)
+		},
+	}
+	cmd.Flags().StringP("model", "m", "", "provider/model or provider (default: the default model's provider and its default embedding model)")
+	cmd.Flags().StringP("delim", "d", delimLine, "Record delimiter of stdin: line, nul or jsonl")
+	cmd.Flags().String("field", "", "JSONL field holding the text to embed")
+	cmd.Flags().String("format", formatJSONL, "Output format: "+strings.Join(embedFormats, ", "))
+	cmd.Flags().Int("batch", 100, "Inputs per embedding request")
+	return cmd
+}
+
+// embedAll embeds the inputs from read in batches of n, writing each batch's
+// vectors before reading the next. Blank inputs are not sent and get a nil
+// vector.
+func embedAll(ctx context.Context, e providers.Embedder, model string, n int, read func(int) ([]embedInput, error), vw *vectorWriter) error {
+	for {
+		batch, err := read(n)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		var texts []string
+		for _, in := range batch {
+			if in.text != "" {
+				texts = append(texts, in.text)
+			}
+		}
+		var vectors [][]float32
+		if len(texts) > 0 {
+			if vectors, err = e.Embed(ctx, model, texts); err != nil {
+				return err
+			}
+		}
+		for _, in := range batch {
+			var v []float32
+			if in.text != "" {
+				v, vectors = vectors[0], vectors[1:]
+			}
+			if err := vw.write(in, v); err != nil {
+				return err
+			}
+		}
+		if err := vw.flush(); err != nil {
+			return err
+		}
+	}
+}
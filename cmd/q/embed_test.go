@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"q/internal/providers"
+)
+
+// lengths embeds each text as a vector of its length, recording the texts
+// sent.
+type lengths struct {
+	providers.Embedder
+	sent []string
+}
+
+func (l *lengths) Embed(_ context.Context, _ string, inputs []string) ([][]float32, error) {
+	l.sent = append(l.sent, inputs...)
+	vectors := make([][]float32, len(inputs))
+	for i, s := range inputs {
+		vectors[i] = []float32{float32(len(s))}
+	}
+	return vectors, nil
+}
+
+func TestEmbedAll_BlankRecords(t *testing.T) {
+	tests := []struct {
+		format string
+		batch  int
+		want   string
+	}{
+		{formatJSONL, 2, `{"index":0,"text":"a","embedding":[1]}` + "\n" +
+			`{"index":1,"text":"","embedding":null}` + "\n" +
+			`{"index":2,"text":"bb","embedding":[2]}` + "\n"},
+		{formatCSV, 1, "0,1\n1\n2,2\n"},
+	}
+	for _, tc := range tests {
+		read, err := embedReader(nil, strings.NewReader("a\n\nbb\n"), delimLine, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		vw, _ := newVectorWriter(&out, tc.format)
+		e := &lengths{}
+		if err := embedAll(context.Background(), e, "m", tc.batch, read, vw); err != nil {
+			t.Fatalf("%s: %v", tc.format, err)
+		}
+		if out.String() != tc.want || !slices.Equal(e.sent, []string{"a", "bb"}) {
+			t.Errorf("%s: wrote %q after sending %q; want %q after sending only a and bb", tc.format, out.String(), e.sent, tc.want)
+		}
+	}
+
+	read, _ := embedReader(nil, strings.NewReader("a\n\nbb\n"), delimLine, "")
+	vw, _ := newVectorWriter(&bytes.Buffer{}, formatBinary)
+	err := embedAll(context.Background(), &lengths{}, "m", 2, read, vw)
+	if err == nil || !strings.Contains(err.Error(), "input 1 is blank") {
+		t.Errorf("binary output of a blank record: %v; want an error naming record 1", err)
+	}
+}
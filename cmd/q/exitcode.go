@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"q/internal/providers"
+)
+
+// Exit codes, so shell scripts can branch on the kind of failure. 2 is left
+// out: shells and most CLIs use it for usage errors.
+const (
+	exitOK              = 0
+	exitError           = 1
+	exitRateLimited     = 3
+	exitQuotaExceeded   = 4
+	exitContextLength   = 5
+	exitContentFiltered = 6
+	exitModelNotFound   = 7
+	exitServerError     = 8
+	exitTimeout         = 9
+	exitInvalidAPIKey   = 10
+	exitInterrupted     = 130
+)
+
+// exitCode maps err onto one of the exit codes above.
+func exitCode(err error) int {
+	var (
+		keyErr      *providers.InvalidAPIKeyError
+		rateErr     *providers.RateLimitedError
+		quotaErr    *providers.QuotaExceededError
+		contextErr  *providers.ContextLengthExceededError
+		filterErr   *providers.ContentFilteredError
+		notFoundErr *providers.ModelNotFoundError
+		serverErr   *providers.ServerError
+		timeoutErr  *providers.TimeoutError
+	)
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &keyErr):
+		return exitInvalidAPIKey
+	case errors.As(err, &rateErr):
+		return exitRateLimited
+	case errors.As(err, &quotaErr):
+		return exitQuotaExceeded
+	case errors.As(err, &contextErr):
+		return exitContextLength
+	case errors.As(err, &filterErr):
+		return exitContentFiltered
+	case errors.As(err, &notFoundErr):
+		return exitModelNotFound
+	case errors.As(err, &serverErr):
+		return exitServerError
+	case errors.As(err, &timeoutErr), errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	}
+	return exitError
+}
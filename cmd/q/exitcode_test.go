@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"q/internal/providers"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, exitOK},
+		{&providers.InvalidAPIKeyError{Provider: "openai"}, exitInvalidAPIKey},
+		{&providers.RateLimitedError{Provider: "openai"}, exitRateLimited},
+		{&providers.QuotaExceededError{Provider: "openai"}, exitQuotaExceeded},
+		{&providers.ContextLengthExceededError{Provider: "openai"}, exitContextLength},
+		{&providers.ContentFilteredError{Provider: "openai"}, exitContentFiltered},
+		{&providers.ModelNotFoundError{Provider: "openai", Model: "gpt-9"}, exitModelNotFound},
+		{&providers.ServerError{Provider: "openai", StatusCode: 503}, exitServerError},
+		{&providers.TimeoutError{Provider: "openai", Err: context.DeadlineExceeded}, exitTimeout},
+		{context.DeadlineExceeded, exitTimeout},
+		{context.Canceled, exitInterrupted},
+		{&providers.APIError{Provider: "openai", StatusCode: 400}, exitError},
+		{errors.New("boom"), exitError},
+	}
+	for _, tc := range tests {
+		if got := exitCode(tc.err); got != tc.want {
+			t.Errorf("exitCode(%T) = %d; want %d", tc.err, got, tc.want)
+		}
+		if tc.err == nil {
+			continue
+		}
+		wrapped := fmt.Errorf("q: running prompt: %w", tc.err)
+		if got := exitCode(wrapped); got != tc.want {
+			t.Errorf("exitCode(wrapped %T) = %d; want %d", tc.err, got, tc.want)
+		}
+	}
+}
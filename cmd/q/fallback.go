@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"q/internal/config"
+	"q/internal/httpclient"
+	"q/internal/providers"
+)
+
+// target is a resolved provider/model pair.
+type target struct {
+	provider string
+	model    string
+	p        providers.Provider
+}
+
+func (t target) String() string { return t.provider + "/" + t.model }
+
+// firstLine trims the usage hints that follow most CLI errors.
+func firstLine(err error) string {
+	line, _, _ := strings.Cut(err.Error(), "\n")
+	return line
+}
+
+func addFallbackFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("fallback", nil, "provider/model to try, in order, if the model is unavailable (overrides config)")
+	cmd.Flags().Bool("no-fallback", false, "Never fall back to another model")
+}
+
+// resolveChain resolves the requested model followed by its fallbacks, from
+// --fallback or the config's fallbacks entry for the model. Fallbacks that
+// cannot be used, e.g. for lack of an API key, are skipped with a notice.
+func (cli *CLI) resolveChain(cmd *cobra.Command, modelFlag string) ([]target, error) {
+	provider, model, p, err := cli.resolve(modelFlag)
+	if err != nil {
+		return nil, err
+	}
+	chain := []target{{provider, model, p}}
+
+	if noFallback, _ := cmd.Flags().GetBool("no-fallback"); noFallback {
+		return chain, nil
+	}
+	next, _ := cmd.Flags().GetStringSlice("fallback")
+	if len(next) == 0 {
+		if next, err = config.GetFallbacks(chain[0].String()); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range next {
+		provider, model, p, err := cli.resolve(m)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "q: skipping fallback %s: %s\n", m, firstLine(err))
+			continue
+		}
+		chain = append(chain, target{provider, model, p})
+	}
+	return chain, nil
+}
+
+// executePrompt sends req to the first target in chain. When a target fails
+// with a transient error before producing any output, the next one is tried.
+// Only the last target is retried on such errors.
+// output is outputText or outputJSON.
+func executePrompt(ctx context.Context, chain []target, req providers.Request, raw, stream bool, output string) error {
+	var err error
+	for i, t := range chain {
+		if i > 0 {
+			fmt.Fprintf(os.Stderr, "q: %s failed (%s), falling back to %s\n", chain[i-1], firstLine(err), t)
+		}
+		// Retrying with backoff can take up to a minute; a model that has
+		// another to fall back to fails over at once instead.
+		tctx := ctx
+		if i < len(chain)-1 {
+			tctx = httpclient.WithoutRetries(ctx)
+		}
+		var started bool
+		started, err = executeOnce(tctx, t, req, raw, stream, output)
+		if err == nil || started || !providers.IsTransient(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// executeOnce runs req against a single target and reports whether any output
+// was written before it returned.
+func executeOnce(ctx context.Context, t target, req providers.Request, raw, stream bool, output string) (started bool, err error) {
+	req.Model = t.model
+
+	if output == outputJSON {
+		resp, cached, err := completeCached(ctx, t.p, req)
+		if err != nil {
+			return false, err
+		}
+		return true, printJSON(jsonResult{Provider: t.provider, Model: t.model, Response: resp, Cached: cached})
+	}
+
+	if stream {
+		req.OnDelta = func(s string) {
+			if s == "" {
+				return
+			}
+			if !started && !raw {
+				writePrefix(t.provider, t.model)
+			}
+			started = true
+			fmt.Print(s)
+		}
+		if _, err := complete(ctx, t.p, req); err != nil {
+			return started, err
+		}
+		if !raw {
+			if !started {
+				writePrefix(t.provider, t.model)
+			}
+			fmt.Println()
+		}
+		return true, nil
+	}
+
+	resp, err := complete(ctx, t.p, req)
+	if err != nil {
+		return false, err
+	}
+	if raw {
+		fmt.Print(resp)
+	} else {
+		fmt.Printf("model (%s/%s): %s\n", t.provider, t.model, resp)
+	}
+	return true, nil
+}
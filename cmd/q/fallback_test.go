@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"q/internal/config"
+	"q/internal/providers"
+)
+
+// captureOutput returns what f writes to stdout and stderr.
+func captureOutput(t *testing.T, f func()) (stdout, stderr string) {
+	t.Helper()
+	read := func(file **os.File) func() string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := *file
+		*file = w
+		var buf bytes.Buffer
+		done := make(chan struct{})
+		go func() { io.Copy(&buf, r); close(done) }()
+		return func() string {
+			*file = old
+			w.Close()
+			<-done
+			return buf.String()
+		}
+	}
+	out, errs := read(&os.Stdout), read(&os.Stderr)
+	f()
+	return out(), errs()
+}
+
+// fake streams deltas and then fails with err, if set.
+type fake struct {
+	providers.Provider
+	name   string
+	deltas []string
+	err    error
+	calls  int
+}
+
+func (f *fake) Name() string              { return f.name }
+func (f *fake) SupportedModels() []string { return []string{"m"} }
+
+func (f *fake) Complete(_ context.Context, r providers.Request) (string, error) {
+	f.calls++
+	for _, d := range f.deltas {
+		if r.OnDelta != nil {
+			r.OnDelta(d)
+		}
+	}
+	if f.err != nil {
+		return "", f.err
+	}
+	return strings.Join(f.deltas, ""), nil
+}
+
+func TestResolveChain(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	for _, p := range []string{"a", "b"} {
+		if err := config.SetAPIKey(p, "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Fallbacks = map[string][]string{"a/m": {"b/m"}}
+	if err := config.SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	r := providers.NewRegistry()
+	r.Register(&fake{name: "a"}, &fake{name: "b"}, &fake{name: "c"})
+	cli := &CLI{registry: r}
+
+	tests := []struct {
+		flags  map[string]string
+		want   []string
+		notice string
+	}{
+		{nil, []string{"a/m", "b/m"}, ""},
+		{map[string]string{"no-fallback": "true"}, []string{"a/m"}, ""},
+		{map[string]string{"fallback": "a/m"}, []string{"a/m", "a/m"}, ""},
+		{map[string]string{"fallback": "nope/x,c/m,b/m"}, []string{"a/m", "b/m"},
+			"q: skipping fallback nope/x: unknown provider: nope\nq: skipping fallback c/m: no API key for c\n"},
+	}
+	for _, tc := range tests {
+		cmd := &cobra.Command{}
+		addFallbackFlags(cmd)
+		for k, v := range tc.flags {
+			cmd.Flags().Set(k, v)
+		}
+		var chain []target
+		_, stderr := captureOutput(t, func() {
+			chain, err = cli.resolveChain(cmd, "a/m")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, c := range chain {
+			got = append(got, c.String())
+		}
+		if !slices.Equal(got, tc.want) || stderr != tc.notice {
+			t.Errorf("%v: chain %v, notices %q; want %v, %q", tc.flags, got, stderr, tc.want, tc.notice)
+		}
+	}
+}
+
+func TestExecutePrompt_Fallback(t *testing.T) {
+	unavailable := &providers.APIError{Provider: "a", StatusCode: 503}
+	tests := []struct {
+		err      error
+		deltas   []string
+		stream   bool
+		fallback bool // whether the second model answers
+		wantErr  bool
+	}{
+		{nil, []string{"one"}, true, false, false},
+		{unavailable, nil, true, true, false},
+		{&providers.APIError{Provider: "a", StatusCode: 429}, nil, false, true, false},
+		{&providers.APIError{Provider: "a", StatusCode: 400}, nil, true, false, true}, // not transient
+		{unavailable, []string{"one "}, true, false, true},                            // streaming began
+		{unavailable, []string{"one "}, false, true, false},                           // nothing was printed yet
+	}
+	for _, tc := range tests {
+		first := &fake{name: "a", deltas: tc.deltas, err: tc.err}
+		second := &fake{name: "b", deltas: []string{"fine"}}
+		chain := []target{{"a", "m", first}, {"b", "m", second}}
+
+		var err error
+		stdout, stderr := captureOutput(t, func() {
+			err = executePrompt(context.Background(), chain, userRequest("", "hi"), true, tc.stream, outputText)
+		})
+		if (err != nil) != tc.wantErr || (second.calls > 0) != tc.fallback {
+			t.Errorf("%v after %q, stream %v: err %v, fallback %v; want error %v, fallback %v",
+				tc.err, tc.deltas, tc.stream, err, second.calls > 0, tc.wantErr, tc.fallback)
+			continue
+		}
+		switch {
+		case tc.fallback:
+			if stdout != "fine" || !strings.Contains(stderr, "q: a/m failed") || !strings.Contains(stderr, "falling back to b/m") {
+				t.Errorf("%v: stdout %q, stderr %q; want the fallback's answer and a notice", tc.err, stdout, stderr)
+			}
+		case tc.err != nil && tc.stream && tc.deltas != nil:
+			if stdout != "one " {
+				t.Errorf("%v after %q: stdout %q; want the deltas streamed", tc.err, tc.deltas, stdout)
+			}
+		}
+	}
+}
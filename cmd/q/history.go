@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"q/internal/config"
+	"q/internal/providers"
+)
+
+const (
+	// defaultWindow is the number of turns kept by sliding-window.
+	defaultWindow = 20
+	// keepTurns is the number of recent turns summarize leaves verbatim,
+	// counting the new message.
+	keepTurns = 2
+	// summaryPrefix starts the system note holding a summary.
+	summaryPrefix = "Summary of the earlier conversation:\n"
+
+	summaryInstructions = "Summarize the conversation below for the assistant taking part in it, " +
+		"so it can carry on without the full transcript. Keep names, facts, decisions, " +
+		"open questions and the user's preferences. Be concise; write prose, not a transcript."
+)
+
+// replyReserve is the room, in tokens, kept free in the context window for
+// the reply: max_tokens if set, otherwise a quarter of the window or the
+// model's output limit, whichever is smaller.
+func replyReserve(info providers.ModelInfo, params providers.Params) int {
+	if params.MaxTokens > 0 {
+		return params.MaxTokens
+	}
+	reserve := info.ContextWindow / 4
+	if info.MaxOutput > 0 {
+		reserve = min(reserve, info.MaxOutput)
+	}
+	return reserve
+}
+
+// historyBudget returns the number of prompt tokens req may use, leaving room
+// for the reply, and a function counting the prompt tokens of messages sent
+// with req's effective system prompt. The limit is 0 if the model's context
+// window is unknown.
+func historyBudget(p providers.Provider, req providers.Request) (int, func([]providers.Message) int, error) {
+	res, err := resolvedConfig()
+	if err != nil {
+		return 0, nil, err
+	}
+	info := modelInfo(res, p, req.Model)
+	system, err := res.SystemPrompt(req.System)
+	if err != nil {
+		return 0, nil, err
+	}
+	count := func(msgs []providers.Message) int {
+		n, _ := requestTokens(info, providers.Request{System: system, Messages: msgs})
+		return n
+	}
+	if info.ContextWindow == 0 {
+		return 0, count, nil
+	}
+	return info.ContextWindow - replyReserve(info, req.Params.WithDefaults(res.Params)), count, nil
+}
+
+// turnStart returns the index where the turn before end begins: a turn is a
+// user message and the replies to it.
+func turnStart(msgs []providers.Message, end int) int {
+	i := end - 1
+	for i > 0 && msgs[i].Role != "user" {
+		i--
+	}
+	return i
+}
+
+// fitHistory drops the oldest turns of req's messages until the request fits
+// the model's context window with room for a reply. The last message is
+// always kept. It returns the trimmed request and the number of messages
+// dropped.
+func fitHistory(p providers.Provider, req providers.Request) (providers.Request, int, error) {
+	limit, count, err := historyBudget(p, req)
+	if err != nil || limit == 0 {
+		return req, 0, err
+	}
+	dropped := 0
+	for len(req.Messages) > 1 && count(req.Messages) > limit {
+		n := 1
+		for n < len(req.Messages)-1 && req.Messages[n].Role != "user" {
+			n++
+		}
+		req.Messages = req.Messages[n:]
+		dropped += n
+	}
+	return req, dropped, nil
+}
+
+// lastTurns returns the last n turns of msgs and the number of messages
+// left out. A leading summary note is kept.
+func lastTurns(msgs []providers.Message, n int) ([]providers.Message, int) {
+	var note []providers.Message
+	if len(msgs) > 0 && isSummary(msgs[0]) {
+		note, msgs = []providers.Message{msgs[0]}, msgs[1:]
+	}
+	start := len(msgs)
+	for range n {
+		if start == 0 {
+			break
+		}
+		start = turnStart(msgs, start)
+	}
+	return append(note, msgs[start:]...), start
+}
+
+func isSummary(m providers.Message) bool {
+	return m.Role == "system" && strings.HasPrefix(m.Content, summaryPrefix)
+}
+
+// historyPolicy is how q chat keeps a conversation within the model's
+// context window.
+type historyPolicy struct {
+	strategy  string
+	window    int     // turns, for sliding-window
+	threshold int     // prompt tokens, for summarize; 0 for the default
+	summarize *target // model writing summaries
+}
+
+// historyPolicy reads the policy from chat's flags, falling back to the
+// config.
+func (cli *CLI) historyPolicy(cmd *cobra.Command, chatModel target) (historyPolicy, error) {
+	cc, err := config.GetChat()
+	if err != nil {
+		return historyPolicy{}, err
+	}
+	h := historyPolicy{strategy: cc.History, window: cc.Window, threshold: cc.SummarizeAt}
+	if s, _ := cmd.Flags().GetString("history"); s != "" {
+		h.strategy = s
+	}
+	if n, _ := cmd.Flags().GetInt("window"); n != 0 {
+		h.window = n
+	}
+
+	switch h.strategy {
+	case "":
+		h.strategy = config.HistoryTruncate
+	case config.HistoryTruncate:
+	case config.HistorySliding:
+		if h.window < 0 {
+			return historyPolicy{}, errors.New("--window must be positive")
+		}
+		if h.window == 0 {
+			h.window = defaultWindow
+		}
+	case config.HistorySummarize:
+		h.summarize = &chatModel
+		if cc.SummaryModel != "" {
+			provider, model, p, err := cli.resolve(cc.SummaryModel)
+			if err != nil {
+				return historyPolicy{}, fmt.Errorf("chat.summary_model: %w", err)
+			}
+			h.summarize = &target{provider, model, p}
+		}
+	default:
+		return historyPolicy{}, fmt.Errorf("unknown history strategy %q\n\nUse one of: %s", h.strategy, strings.Join(config.HistoryStrategies, ", "))
+	}
+	return h, nil
+}
+
+// apply shortens req's messages according to the policy, then drops the
+// oldest turns if they still do not fit. Notices go to stderr.
+func (h historyPolicy) apply(ctx context.Context, p providers.Provider, req providers.Request) (providers.Request, error) {
+	switch h.strategy {
+	case config.HistorySliding:
+		req.Messages, _ = lastTurns(req.Messages, h.window)
+	case config.HistorySummarize:
+		limit, count, err := historyBudget(p, req)
+		if err != nil {
+			return req, err
+		}
+		threshold := h.threshold
+		if threshold == 0 {
+			threshold = limit * 3 / 4
+		}
+		if threshold > 0 && count(req.Messages) > threshold {
+			msgs, err := h.condense(ctx, req.Messages)
+			if err != nil {
+				return req, fmt.Errorf("summarizing the conversation: %w", err)
+			}
+			if len(msgs) < len(req.Messages) {
+				fmt.Fprintf(os.Stderr, "q: summarized %d earlier messages with %s\n", len(req.Messages)-len(msgs)+1, h.summarize)
+			}
+			req.Messages = msgs
+		}
+	}
+
+	req, dropped, err := fitHistory(p, req)
+	if dropped > 0 {
+		fmt.Fprintf(os.Stderr, "q: dropped the %d oldest messages to fit the context window\n", dropped)
+	}
+	return req, err
+}
+
+// condense replaces all but the last keepTurns turns of msgs, and any
+// earlier summary, with a new summary note.
+func (h historyPolicy) condense(ctx context.Context, msgs []providers.Message) ([]providers.Message, error) {
+	recent, _ := lastTurns(msgs, keepTurns)
+	if len(recent) > 0 && isSummary(recent[0]) {
+		recent = recent[1:]
+	}
+	old := msgs[:len(msgs)-len(recent)]
+	if len(old) == 0 || len(old) == 1 && isSummary(old[0]) {
+		return msgs, nil
+	}
+
+	var transcript strings.Builder
+	for _, m := range old {
+		if isSummary(m) {
+			fmt.Fprintf(&transcript, "[earlier summary]\n%s\n\n", strings.TrimPrefix(m.Content, summaryPrefix))
+			continue
+		}
+		fmt.Fprintf(&transcript, "[%s]\n%s\n\n", m.Role, m.Content)
+	}
+	req := providers.Request{
+		Model:    h.summarize.model,
+		System:   summaryInstructions,
+		Messages: []providers.Message{{Role: "user", Content: transcript.String()}},
+	}
+	var summary string
+	var err error
+	if c, ok := h.summarize.p.(providers.Completer); ok {
+		summary, err = c.Complete(ctx, req)
+	} else {
+		summary, err = h.summarize.p.Prompt(ctx, req.Model, summaryInstructions+"\n\n"+transcript.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	note := providers.Message{Role: "system", Content: summaryPrefix + strings.TrimSpace(summary)}
+	return append([]providers.Message{note}, recent...), nil
+}
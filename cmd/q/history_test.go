@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"q/internal/config"
+	"q/internal/providers"
+)
+
+// echo answers with the last message it is sent, so a summary it writes is
+// the transcript it was asked to summarize.
+type echo struct{ providers.Provider }
+
+func (echo) Name() string { return "test" }
+
+func (echo) Complete(_ context.Context, r providers.Request) (string, error) {
+	return r.Messages[len(r.Messages)-1].Content, nil
+}
+
+// conversation builds messages from "role:content" specs. An assistant
+// message "a:call" asks for a tool call; "t:..." is its result.
+func conversation(specs ...string) []providers.Message {
+	var msgs []providers.Message
+	for _, s := range specs {
+		role, content, _ := strings.Cut(s, ":")
+		m := providers.Message{Content: content}
+		switch role {
+		case "u":
+			m.Role = "user"
+		case "a":
+			m.Role = "assistant"
+			if content == "call" {
+				m = providers.Message{Role: "assistant", ToolCalls: []providers.ToolCall{{ID: "c1", Name: "f"}}}
+			}
+		case "t":
+			m.Role, m.ToolCallID = "tool", "c1"
+		case "s":
+			m.Role, m.Content = "system", summaryPrefix+content
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// contents lists the content of msgs, with "call" for tool calls and
+// "summary" for a summary note.
+func contents(msgs []providers.Message) []string {
+	var out []string
+	for _, m := range msgs {
+		switch {
+		case len(m.ToolCalls) > 0:
+			out = append(out, "call")
+		case isSummary(m):
+			out = append(out, "summary")
+		default:
+			out = append(out, m.Content)
+		}
+	}
+	return out
+}
+
+// withModel makes model, a provider/model, a model with info, in place of
+// the config.
+func withModel(t *testing.T, model string, info providers.ModelInfo) {
+	t.Helper()
+	old := resolvedConfig
+	t.Cleanup(func() { resolvedConfig = old })
+	resolvedConfig = func() (config.Resolved, error) {
+		return config.Resolved{Models: map[string]providers.ModelInfo{model: info}}, nil
+	}
+}
+
+func TestLastTurns(t *testing.T) {
+	msgs := conversation("u:u1", "a:a0", "a:a1", "u:u2", "a:a2", "u:u3")
+	tests := []struct {
+		msgs []providers.Message
+		n    int
+		want []string
+		left int
+	}{
+		{msgs, 1, []string{"u3"}, 5},
+		{msgs, 2, []string{"u2", "a2", "u3"}, 3},
+		{msgs, 3, []string{"u1", "a0", "a1", "u2", "a2", "u3"}, 0},
+		{msgs, 10, []string{"u1", "a0", "a1", "u2", "a2", "u3"}, 0},
+		{append(conversation("s:earlier"), msgs...), 2, []string{"summary", "u2", "a2", "u3"}, 3},
+		{nil, 2, nil, 0},
+	}
+	for _, tc := range tests {
+		got, left := lastTurns(tc.msgs, tc.n)
+		if !slices.Equal(contents(got), tc.want) || left != tc.left {
+			t.Errorf("lastTurns(%v, %d) = %v, %d; want %v, %d", contents(tc.msgs), tc.n, contents(got), left, tc.want, tc.left)
+		}
+	}
+}
+
+func TestFitHistory(t *testing.T) {
+	// Each message is 5 estimated tokens, 35 in all.
+	msgs := conversation("u:uuu1", "a:aaa0", "a:aab0", "a:aaa1", "u:uuu2", "a:aaa2", "u:uuu3")
+	tests := []struct {
+		window  int // the context window; 1 token is kept for the reply
+		want    []string
+		dropped int
+	}{
+		{0, []string{"uuu1", "aaa0", "aab0", "aaa1", "uuu2", "aaa2", "uuu3"}, 0}, // unknown window
+		{36, []string{"uuu1", "aaa0", "aab0", "aaa1", "uuu2", "aaa2", "uuu3"}, 0},
+		{35, []string{"uuu2", "aaa2", "uuu3"}, 4}, // the first turn goes whole
+		{16, []string{"uuu2", "aaa2", "uuu3"}, 4},
+		{15, []string{"uuu3"}, 6},
+		{2, []string{"uuu3"}, 6}, // the new message is always kept
+	}
+	for _, tc := range tests {
+		withModel(t, "test/m", providers.ModelInfo{ContextWindow: tc.window, MaxOutput: 1})
+		req, dropped, err := fitHistory(echo{}, providers.Request{Model: "m", Messages: msgs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(contents(req.Messages), tc.want) || dropped != tc.dropped {
+			t.Errorf("window %d: fitHistory = %v, %d dropped; want %v, %d", tc.window, contents(req.Messages), dropped, tc.want, tc.dropped)
+		}
+	}
+}
+
+func TestApply_SlidingWindow(t *testing.T) {
+	withModel(t, "test/m", providers.ModelInfo{})
+	h := historyPolicy{strategy: config.HistorySliding, window: 2}
+	req := providers.Request{Model: "m", Messages: conversation("u:u1", "a:a1", "u:u2", "a:a2", "a:a2b", "u:u3")}
+	got, err := h.apply(context.Background(), echo{}, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"u2", "a2", "a2b", "u3"}; !slices.Equal(contents(got.Messages), want) {
+		t.Errorf("apply = %v; want %v", contents(got.Messages), want)
+	}
+}
+
+func TestApply_Summarize(t *testing.T) {
+	withModel(t, "test/m", providers.ModelInfo{})
+	p := echo{}
+	h := historyPolicy{strategy: config.HistorySummarize, threshold: 20, summarize: &target{"test", "m", p}}
+	ctx := context.Background()
+
+	short := providers.Request{Model: "m", Messages: conversation("u:u1", "a:a1", "u:u2")}
+	if got, err := h.apply(ctx, p, short); err != nil || !slices.Equal(contents(got.Messages), []string{"u1", "a1", "u2"}) {
+		t.Errorf("apply under the threshold = %v, %v; want it unchanged", contents(got.Messages), err)
+	}
+
+	long := providers.Request{Model: "m", Messages: conversation("u:u1", "a:a0", "a:a1", "u:u2", "a:a2", "u:u3")}
+	got, err := h.apply(ctx, p, long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"summary", "u2", "a2", "u3"}; !slices.Equal(contents(got.Messages), want) {
+		t.Fatalf("apply = %v; want %v", contents(got.Messages), want)
+	}
+	if note := got.Messages[0].Content; !strings.Contains(note, "[user]\nu1") || !strings.Contains(note, "[assistant]\na1") {
+		t.Errorf("summary note = %q; want the older turn summarized", note)
+	}
+
+	// A later summary folds in the earlier one, and stays first.
+	again := providers.Request{Model: "m", Messages: append(got.Messages, conversation("a:a3", "u:u4")...)}
+	got, err = h.apply(ctx, p, again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"summary", "u3", "a3", "u4"}; !slices.Equal(contents(got.Messages), want) {
+		t.Fatalf("second apply = %v; want %v", contents(got.Messages), want)
+	}
+	if note := got.Messages[0].Content; !strings.Contains(note, "[earlier summary]") || !strings.Contains(note, "[user]\nu2") {
+		t.Errorf("second summary note = %q; want the earlier summary and u2", note)
+	}
+}
+
+func TestFitHistory_ToolTurn(t *testing.T) {
+	// A tool call and its result go with their turn, never on their own.
+	msgs := conversation("u:uuu1", "a:call", "t:rrr1", "a:aaa1", "u:uuu2")
+	withModel(t, "test/m", providers.ModelInfo{ContextWindow: 20, MaxOutput: 1})
+	req, dropped, err := fitHistory(echo{}, providers.Request{Model: "m", Messages: msgs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"uuu2"}; !slices.Equal(contents(req.Messages), want) || dropped != 4 {
+		t.Errorf("fitHistory = %v, %d dropped; want %v, 4", contents(req.Messages), dropped, want)
+	}
+}
@@ -9,14 +9,21 @@ import (
 	"os/signal"
 	"slices"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/chzyer/readline"
 	"github.com/spf13/cobra"
 
 	"q/internal/config"
+	"q/internal/httpclient"
+	"q/internal/images"
+	"q/internal/mcp"
 	"q/internal/providers"
+	"q/internal/providers/mock"
 	"q/internal/providers/openai"
+	"q/internal/respcache"
+	"q/internal/secrets"
 )
 
 var (
@@ -25,11 +32,27 @@ var (
 	date    = "unknown"
 )
 
-type CLI struct{ registry *providers.Registry }
+type CLI struct {
+	registry *providers.Registry
+
+	// apiKey is the global --api-key flag. It applies to the provider of the
+	// first model resolved, i.e. the one requested, not to fallbacks.
+	apiKey        string
+	apiKeyApplied bool
+
+	profile string // global --profile flag
+
+	warned map[string]bool // unknown models already warned about
+}
 
 func NewCLI() *CLI {
 	r := providers.NewRegistry()
-	r.Register(openai.NewProvider())
+	r.Register(openai.NewProvider(), mock.NewProvider())
+	mcp.ClientVersion = version
+	config.PassphrasePrompt = func() (string, error) {
+		b, err := readline.Password("Passphrase for encrypted API keys: ")
+		return string(b), err
+	}
 	return &CLI{registry: r}
 }
 
@@ -67,6 +90,26 @@ type flags struct {
 	model    string
 	noStream bool
 	raw      bool
+	images   []string
+	output   string // outputText or outputJSON; "" where there is no --output
+	transport
+}
+
+// transport holds the flags choosing how a request is sent.
+type transport struct {
+	api        string
+	background bool
+	reasoning  bool
+}
+
+// set applies t to req. Reasoning summaries are printed to stderr so they
+// stay out of piped output.
+func (t transport) set(req *providers.Request) {
+	req.API = t.api
+	req.Background = t.background
+	if t.reasoning {
+		req.OnReasoning = func(s string) { fmt.Fprint(os.Stderr, s) }
+	}
 }
 
 func parseFlags(cmd *cobra.Command) (flags, error) {
@@ -85,13 +128,73 @@ func parseFlags(cmd *cobra.Command) (flags, error) {
 	if err != nil {
 		return flags{}, err
 	}
-	return flags{model, noStream, raw}, nil
+	images, err := cmd.Flags().GetStringArray("image")
+	if err != nil {
+		return flags{}, err
+	}
+	api, err := getStr("api")
+	if err != nil {
+		return flags{}, err
+	}
+	background, err := getBool("background")
+	if err != nil {
+		return flags{}, err
+	}
+	reasoning, err := getBool("reasoning")
+	if err != nil {
+		return flags{}, err
+	}
+	var output string
+	if cmd.Flags().Lookup("output") != nil {
+		if output, err = getStr("output"); err != nil {
+			return flags{}, err
+		}
+		if output != outputText && output != outputJSON {
+			return flags{}, fmt.Errorf("unknown output format %q\n\nUse one of: %s, %s", output, outputText, outputJSON)
+		}
+	}
+	return flags{model, noStream, raw, images, output, transport{api, background, reasoning}}, nil
 }
 
 func addCommonFlags(cmd *cobra.Command) {
 	cmd.Flags().StringP("model", "m", "", "provider/model")
 	cmd.Flags().Bool("no-stream", false, "Disable streaming output")
 	cmd.Flags().BoolP("raw", "r", false, "Return raw model output")
+	cmd.Flags().StringArray("image", nil, "Image file or URL to send with the prompt (repeatable; vision models only)")
+	cmd.Flags().String("api", "", "API to send requests through: chat or responses (OpenAI only; default: the model's)")
+	cmd.Flags().Bool("background", false, "Run the request as a background job and wait for it (OpenAI Responses API)")
+	cmd.Flags().Bool("reasoning", false, "Print summaries of the model's reasoning to stderr, where offered")
+}
+
+// expandModel turns name into a provider/model. It accepts, in order, an
+// alias from the config, a provider/model, or a bare model name offered by
+// exactly one provider.
+func (cli *CLI) expandModel(name string) (string, error) {
+	res, err := resolvedConfig()
+	if err != nil {
+		return "", err
+	}
+	if target, ok := res.Aliases[name]; ok {
+		return target, nil
+	}
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+
+	var matches []string
+	for _, providerName := range cli.registry.Names() {
+		p, _ := cli.registry.Lookup(providerName)
+		if slices.Contains(cli.knownModels(p), name) {
+			matches = append(matches, providerName+"/"+name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("unknown model or alias '%s'\n\nUse: provider/model (e.g., openai/gpt-4o)\nSee available: q models list", name)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("ambiguous model '%s': %s\n\nUse the full provider/model form", name, strings.Join(matches, ", "))
 }
 
 func (cli *CLI) resolve(modelFlag string) (provider, model string, p providers.Provider, err error) {
@@ -105,6 +208,9 @@ func (cli *CLI) resolve(modelFlag string) (provider, model string, p providers.P
 			return
 		}
 	}
+	if model, err = cli.expandModel(model); err != nil {
+		return
+	}
 
 	parts := strings.SplitN(model, "/", 2)
 	if len(parts) != 2 {
@@ -118,48 +224,157 @@ func (cli *CLI) resolve(modelFlag string) (provider, model string, p providers.P
 		err = fmt.Errorf("unknown provider: %s\n\nSee available: q models list", provider)
 		return
 	}
-	if !slices.Contains(p.SupportedModels(), model) {
-		err = fmt.Errorf("unsupported model '%s' for %s\n\nSee available: q models list", model, provider)
-		return
+	if err = cli.requireKey(provider); err == nil {
+		cli.checkModel(p, model)
 	}
+	return
+}
 
-	key, keyErr := config.GetAPIKey(provider)
+// requireKey applies --api-key to provider and checks that it has a key.
+func (cli *CLI) requireKey(provider string) error {
+	if cli.apiKey != "" && !cli.apiKeyApplied {
+		config.OverrideAPIKey(provider, cli.apiKey)
+		cli.apiKeyApplied = true
+	}
+	if p, ok := cli.registry.Lookup(provider); ok && !providers.NeedsKey(p) {
+		return nil
+	}
+
+	key, err := config.GetAPIKey(provider)
 	switch {
-	case keyErr != nil:
-		err = fmt.Errorf("failed to read API key for %s: %w", provider, keyErr)
+	case err != nil:
+		return fmt.Errorf("failed to read API key for %s: %w", provider, err)
+	case key == "" && httpclient.Replaying():
+		// Recorded responses need no key, and cassettes hold none.
+		config.OverrideAPIKey(provider, httpclient.Redacted)
 	case key == "":
-		err = fmt.Errorf("no API key for %s\n\nSet key: q keys set --provider %s --key KEY", provider, provider)
+		return fmt.Errorf("no API key for %s\n\nSet key: q keys set --provider %s --key KEY", provider, provider)
+	}
+	return nil
+}
+
+// userRequest builds a single-turn request for prompt.
+func userRequest(model, prompt string) providers.Request {
+	return providers.Request{
+		Model:    model,
+		Messages: []providers.Message{{Role: "user", Content: prompt}},
 	}
-	return
 }
 
-func executePrompt(ctx context.Context, p providers.Provider, provider, model, prompt string, raw, stream bool) error {
-	if stream {
-		if !raw {
-			writePrefix(provider, model)
+// resolvedConfig is the effective user and project config, loaded once.
+var resolvedConfig = sync.OnceValues(config.Resolve)
+
+// complete sends req to p. Providers implementing providers.Completer get the
+// full request; others only support a single bare prompt.
+//
+// The configured parameters fill in any req leaves unset, and the project's
+// system prompt and context files are added. Requests the model is known not
+// to support, or not to have room for, are rejected before they are sent.
+// With a response cache in ctx, identical requests are answered from it.
+func complete(ctx context.Context, p providers.Provider, req providers.Request) (string, error) {
+	resp, _, err := completeCached(ctx, p, req)
+	return resp, err
+}
+
+// completeCached is complete, also reporting whether the response came from
+// the response cache carried by ctx, if any.
+func completeCached(ctx context.Context, p providers.Provider, req providers.Request) (resp string, cached bool, err error) {
+	if req, err = prepare(p, req); err != nil {
+		return "", false, err
+	}
+
+	cache := respcache.FromContext(ctx)
+	var key string
+	if cache != nil {
+		// A gateway set as the base URL may answer differently.
+		endpoint, err := modelCacheKey(p.Name())
+		if err != nil {
+			return "", false, err
 		}
-		if _, err := p.Stream(ctx, model, prompt); err != nil {
-			return err
+		key = respcache.Key(endpoint, req)
+		if e, ok := cache.Get(key); ok {
+			if req.OnDelta != nil {
+				req.OnDelta(e.Response)
+			}
+			return e.Response, true, nil
 		}
-		if !raw {
-			fmt.Println()
+	}
+
+	resp, err = providers.Complete(ctx, p, req)
+	if cache != nil && err == nil {
+		if err := cache.Put(key, p.Name(), req.Model, resp); err != nil {
+			fmt.Fprintf(os.Stderr, "q: not cached: %v\n", err)
 		}
-		return nil
 	}
+	return resp, false, err
+}
 
-	resp, err := p.Prompt(ctx, model, prompt)
+// prepare fills in the configured parameters, system prompt and API that req
+// leaves unset, and checks it against what the model is known to support.
+func prepare(p providers.Provider, req providers.Request) (providers.Request, error) {
+	res, err := resolvedConfig()
 	if err != nil {
-		return err
+		return req, err
 	}
-	if raw {
-		fmt.Print(resp)
-	} else {
-		fmt.Printf("model (%s/%s): %s\n", provider, model, resp)
+	info := modelInfo(res, p, req.Model)
+	defaults := res.Params
+	if info.Sampling != nil && !*info.Sampling {
+		// Configured sampling applies to the models that take it; only a
+		// template or request asking for it is an error.
+		defaults.Temperature, defaults.TopP = nil, nil
 	}
-	return nil
+	req.Params = req.Params.WithDefaults(defaults)
+	if req.System, err = res.SystemPrompt(req.System); err != nil {
+		return req, err
+	}
+	if req.API == "" {
+		req.API = info.API
+	}
+	n, _ := requestTokens(info, req)
+	return req, info.Check(p.Name(), req, n)
 }
 
-func chatLoop(ctx context.Context, p providers.Provider, provider, model string, raw, stream bool) error {
+// completeTools is complete for requests offering tools, returning the
+// assistant's message with any tool calls it asks for. It needs a
+// providers.ToolCompleter and does not use the response cache.
+func completeTools(ctx context.Context, p providers.Provider, req providers.Request) (providers.Message, error) {
+	tc, ok := p.(providers.ToolCompleter)
+	if !ok {
+		return providers.Message{}, fmt.Errorf("%s does not support tools", p.Name())
+	}
+	req, err := prepare(p, req)
+	if err != nil {
+		return providers.Message{}, err
+	}
+	return tc.CompleteTools(ctx, req)
+}
+
+// chatLoop runs an interactive conversation. With a providers.Completer the
+// history is kept here and shortened by h when it outgrows the model's
+// context window; other providers keep their own. imgs are sent with the
+// first message, and every request goes through t. The tools of tb's MCP
+// servers, if any, are offered to the model.
+func chatLoop(ctx context.Context, p providers.Provider, provider, model string, raw, stream bool, h historyPolicy, imgs []providers.Image, t transport, tb *toolbox) error {
+	_, managed := p.(providers.Completer)
+	if _, ok := p.(providers.ToolCompleter); tb != nil && len(tb.tools) > 0 && !ok {
+		fmt.Fprintf(os.Stderr, "q: %s does not support tools; MCP tools are not offered\n", provider)
+		tb.tools = nil
+	}
+	switch {
+	case !managed && tb != nil:
+		return fmt.Errorf("%s keeps its own chat history; MCP servers are not supported (use --no-mcp)", provider)
+	case !managed && t != (transport{}):
+		return fmt.Errorf("%s does not support --api, --background or --reasoning in chat", provider)
+	case !managed && h.strategy != config.HistoryTruncate:
+		return fmt.Errorf("%s keeps its own chat history; --history is not supported", provider)
+	case !managed && len(imgs) > 0:
+		return fmt.Errorf("%s does not support images in chat", provider)
+	}
+	var (
+		history []providers.Message
+		attach  string // resources for the next message
+	)
+
 	// Configure readline
 	prompt := "you: "
 	if raw {
@@ -213,22 +428,71 @@ func chatLoop(ctx context.Context, p providers.Provider, provider, model string,
 		if text == "" {
 			continue
 		}
+		if tb != nil && strings.HasPrefix(text, "/") {
+			handled, prompt, err := tb.command(ctx, text, &attach)
+			switch {
+			case err != nil:
+				fmt.Fprintf(os.Stderr, "q: %v\n", err)
+				continue
+			case handled && prompt == nil:
+				continue
+			case handled:
+				// Send the prompt's last message as if typed.
+				history = append(history, prompt[:len(prompt)-1]...)
+				text = prompt[len(prompt)-1].Content
+			}
+		}
 
-		if !raw {
-			writePrefix(provider, model)
+		if !managed {
+			if !raw {
+				writePrefix(provider, model)
+			}
+			if stream {
+				if _, err = p.ChatStream(ctx, model, text); err != nil {
+					return err
+				}
+			} else {
+				resp, err := p.ChatPrompt(ctx, model, text)
+				if err != nil {
+					return err
+				}
+				fmt.Print(resp)
+			}
+			fmt.Println()
+			continue
 		}
 
-		if stream {
-			if _, err = p.ChatStream(ctx, model, text); err != nil {
-				return err
+		req := providers.Request{Model: model, Messages: append(history, providers.Message{Role: "user", Content: attach + text, Images: imgs})}
+		imgs, attach = nil, ""
+		if req, err = h.apply(ctx, p, req); err != nil {
+			return err
+		}
+		t.set(&req)
+		prefix := func() {
+			if !raw {
+				writePrefix(provider, model)
 			}
-		} else {
-			resp, err := p.ChatPrompt(ctx, model, text)
-			if err != nil {
+		}
+		if tb != nil && len(tb.tools) > 0 {
+			if req, err = tb.converse(ctx, p, req, rl, stream, prefix); err != nil {
 				return err
 			}
+			history = req.Messages
+			fmt.Println()
+			continue
+		}
+		prefix()
+		if stream {
+			req.OnDelta = func(s string) { fmt.Print(s) }
+		}
+		resp, err := complete(ctx, p, req)
+		if err != nil {
+			return err
+		}
+		if !stream {
 			fmt.Print(resp)
 		}
+		history = append(req.Messages, providers.Message{Role: "assistant", Content: resp})
 		fmt.Println()
 	}
 }
@@ -257,16 +521,47 @@ func (cli *CLI) rootCmd() *cobra.Command {
 				}
 			}
 
-			provider, model, p, err := cli.resolve(f.model)
+			imgs, err := images.LoadAll(f.images)
 			if err != nil {
 				return err
 			}
 
-			ctx := contextWithInterrupt()
-			return executePrompt(ctx, p, provider, model, prompt, f.raw, !f.noStream)
+			chain, err := cli.resolveChain(cmd, f.model)
+			if err != nil {
+				return err
+			}
+
+			req := userRequest("", prompt)
+			req.Messages[0].Images = imgs
+			f.transport.set(&req)
+			ctx, err := cli.withCache(contextWithInterrupt(), cmd)
+			if err != nil {
+				return err
+			}
+			return executePrompt(ctx, chain, req, f.raw, !f.noStream, f.output)
 		},
 	}
 	addCommonFlags(cmd)
+	addFallbackFlags(cmd)
+	addOutputFlags(cmd)
+	cmd.PersistentFlags().StringVar(&cli.apiKey, "api-key", "",
+		"API key for the requested provider (overrides environment and config)")
+	cmd.PersistentFlags().StringVar(&cli.profile, "profile", "",
+		"config profile to use (default: $"+config.ProfileEnv+" or the one set with q profile use)")
+	cmd.PersistentPreRunE = func(*cobra.Command, []string) error {
+		if cli.profile == "" {
+			return nil
+		}
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			return err
+		}
+		if _, ok := cfg.Profile(cli.profile); !ok {
+			return fmt.Errorf("unknown profile: %s\n\nSee available: q profile list", cli.profile)
+		}
+		config.UseProfile(cli.profile)
+		return nil
+	}
 	return cmd
 }
 
@@ -281,34 +576,43 @@ func (cli *CLI) chatCmd() *cobra.Command {
 				return err
 			}
 
+			imgs, err := images.LoadAll(f.images)
+			if err != nil {
+				return err
+			}
+
 			provider, model, p, err := cli.resolve(f.model)
 			if err != nil {
 				return err
 			}
+			h, err := cli.historyPolicy(cmd, target{provider, model, p})
+			if err != nil {
+				return err
+			}
 
 			ctx := contextWithInterrupt()
-			return chatLoop(ctx, p, provider, model, f.raw, !f.noStream)
-		},
-	}
-	addCommonFlags(cmd)
-	return cmd
-}
-
-func (cli *CLI) modelsCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:          "models",
-		Short:        "List available provider/model combinations",
-		SilenceUsage: true,
-		RunE: func(*cobra.Command, []string) error {
-			for _, providerName := range cli.registry.Names() {
-				provider, _ := cli.registry.Lookup(providerName)
-				for _, model := range provider.SupportedModels() {
-					fmt.Printf("%s/%s\n", providerName, model)
+			var tb *toolbox
+			if noMCP, _ := cmd.Flags().GetBool("no-mcp"); !noMCP {
+				only, _ := cmd.Flags().GetStringSlice("mcp")
+				if tb, err = connectMCP(ctx, only); err != nil {
+					return err
 				}
 			}
-			return nil
+			if tb != nil {
+				defer tb.close()
+				tb.summary()
+			}
+			return chatLoop(ctx, p, provider, model, f.raw, !f.noStream, h, imgs, f.transport, tb)
 		},
 	}
+	addCommonFlags(cmd)
+	cmd.Flags().String("history", "", "How to keep long conversations within the context window: "+
+		strings.Join(config.HistoryStrategies, ", ")+" (default: chat.history or "+config.HistoryTruncate+")")
+	cmd.Flags().Int("window", 0, "Turns kept by the sliding-window strategy (default: chat.window or 20)")
+	cmd.Flags().StringSlice("mcp", nil, "MCP server to connect to, even if disabled; repeatable (default: all enabled servers)")
+	cmd.Flags().Bool("no-mcp", false, "Do not connect to MCP servers")
+	cmd.MarkFlagsMutuallyExclusive("mcp", "no-mcp")
+	return cmd
 }
 
 func (cli *CLI) keysCmd() *cobra.Command {
@@ -319,16 +623,21 @@ func (cli *CLI) keysCmd() *cobra.Command {
 		Short:        "List which providers have keys set",
 		SilenceUsage: true,
 		RunE: func(*cobra.Command, []string) error {
-			cfg, err := config.LoadConfig()
-			if err != nil {
-				return err
-			}
 			for _, providerName := range cli.registry.Names() {
-				status := "❌"
-				if cfg.APIKeys[providerName] != "" {
-					status = "✅"
+				if p, _ := cli.registry.Lookup(providerName); !providers.NeedsKey(p) {
+					fmt.Printf("%s: ✅ (no key needed)\n", providerName)
+					continue
+				}
+				key, source, err := config.LookupAPIKey(providerName)
+				if err != nil {
+					fmt.Printf("%s: ⚠️  %s\n", providerName, firstLine(err))
+					continue
 				}
-				fmt.Printf("%s: %s\n", providerName, status)
+				if key == "" {
+					fmt.Printf("%s: ❌\n", providerName)
+					continue
+				}
+				fmt.Printf("%s: ✅ (%s)\n", providerName, source)
 			}
 			return nil
 		},
@@ -378,10 +687,69 @@ func (cli *CLI) keysCmd() *cobra.Command {
 		},
 	}
 
-	cmd.AddCommand(list, set, path)
+	migrate := &cobra.Command{
+		Use:   "migrate",
+		Short: "Move API keys to another secret backend",
+		Long: "Moves every stored key to another backend and makes it the active one.\n\n" +
+			"Backends:\n" +
+			"  plaintext  config.json, protected by file permissions (default)\n" +
+			"  encrypted  AES-256-GCM file unlocked by a passphrase ($" + config.PassphraseEnv + " or a prompt)\n" +
+			"  command    an external secret manager; {provider} is replaced with the provider name",
+		Example: `  q keys migrate --to encrypted
+  q keys migrate --to command --get-command "pass show q/{provider} 2>/dev/null || exit 44" \
+    --set-command "pass insert -m q/{provider}"`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			var sc config.SecretsConfig
+			sc.Backend, _ = cmd.Flags().GetString("to")
+			sc.File, _ = cmd.Flags().GetString("file")
+			sc.GetCommand, _ = cmd.Flags().GetString("get-command")
+			sc.SetCommand, _ = cmd.Flags().GetString("set-command")
+			sc.DeleteCommand, _ = cmd.Flags().GetString("delete-command")
+			if sc.Backend == "" {
+				_ = cmd.Help()
+				return errors.New("backend required")
+			}
+
+			moved, err := config.MigrateKeys(cli.registry.Names(), sc)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Moved %d key(s) to the %s backend\n", len(moved), sc.Backend)
+			return nil
+		},
+	}
+	migrate.Flags().String("to", "", "target backend: "+strings.Join(secrets.Names, ", "))
+	migrate.Flags().String("file", "", "encrypted key file (default: keys.enc next to config.json)")
+	migrate.Flags().String("get-command", "", "command printing the key for {provider}, or exiting 44 without one")
+	migrate.Flags().String("set-command", "", "command reading the key for {provider} from stdin")
+	migrate.Flags().String("delete-command", "", "command deleting the key for {provider}")
+
+	cmd.AddCommand(list, set, path, migrate)
 	return cmd
 }
 
+// validateModel checks that model, in any form accepted by expandModel, names
+// a registered provider, without requiring an API key. Models missing from
+// the known lists only draw a warning.
+func (cli *CLI) validateModel(model string) (providerName, modelName string, err error) {
+	if model, err = cli.expandModel(model); err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(model, "/", 2)
+	if len(parts) != 2 {
+		return "", "", errors.New("invalid model format\n\nUse: provider/model (e.g., openai/gpt-4o)")
+	}
+
+	providerName, modelName = parts[0], parts[1]
+	provider, ok := cli.registry.Lookup(providerName)
+	if !ok {
+		return "", "", fmt.Errorf("unknown provider: %s\n\nSee available: q models list", providerName)
+	}
+	cli.checkModel(provider, modelName)
+	return providerName, modelName, nil
+}
+
 func (cli *CLI) defaultCmd() *cobra.Command {
 	cmd := &cobra.Command{Use: "default", Short: "Manage default model"}
 
@@ -413,20 +781,11 @@ func (cli *CLI) defaultCmd() *cobra.Command {
 				return errors.New("model must be provided with --model flag")
 			}
 
-			parts := strings.SplitN(model, "/", 2)
-			if len(parts) != 2 {
-				return errors.New("invalid model format\n\nUse: provider/model (e.g., openai/gpt-4o)")
-			}
-
-			providerName, modelName := parts[0], parts[1]
-			provider, ok := cli.registry.Lookup(providerName)
-			switch {
-			case !ok:
-				return fmt.Errorf("unknown provider: %s\n\nSee available: q models list", providerName)
-			case !slices.Contains(provider.SupportedModels(), modelName):
-				return fmt.Errorf("unsupported model '%s' for %s\n\nSee available: q models list", modelName, providerName)
+			providerName, modelName, err := cli.validateModel(model)
+			if err != nil {
+				return err
 			}
-
+			model = providerName + "/" + modelName
 			if err := config.SetDefaultModel(model); err != nil {
 				return err
 			}
@@ -456,9 +815,21 @@ func (cli *CLI) root() *cobra.Command {
 	r := cli.rootCmd()
 	r.AddCommand(
 		cli.chatCmd(),
+		cli.mapCmd(),
+		cli.runCmd(),
+		templatesCmd(),
 		cli.modelsCmd(),
+		cli.tokensCmd(),
+		cli.cacheCmd(),
+		cli.embedCmd(),
+		cli.indexCmd(),
+		cli.askCmd(),
+		cli.serveCmd(),
+		mcpCmd(),
 		cli.keysCmd(),
 		cli.defaultCmd(),
+		cli.profileCmd(),
+		cli.configCmd(),
 		versionCmd(),
 	)
 	return r
@@ -467,7 +838,5 @@ func (cli *CLI) root() *cobra.Command {
 func run() error { return NewCLI().root().Execute() }
 
 func main() {
-	if err := run(); err != nil {
-		os.Exit(1)
-	}
+	os.Exit(exitCode(run()))
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"q/internal/config"
+	"q/internal/providers"
+)
+
+func TestPrepare_Sampling(t *testing.T) {
+	temp, topP := 0.2, 0.9
+	off, on := false, true
+	old := resolvedConfig
+	t.Cleanup(func() { resolvedConfig = old })
+	resolvedConfig = func() (config.Resolved, error) {
+		return config.Resolved{
+			Params: providers.Params{Temperature: &temp, TopP: &topP, MaxTokens: 100},
+			Models: map[string]providers.ModelInfo{
+				"a/reasoning": {Sampling: &off},
+				"a/chat":      {Sampling: &on},
+			},
+		}, nil
+	}
+	p := &fake{name: "a"}
+
+	req, err := prepare(p, providers.Request{Model: "reasoning"})
+	if err != nil {
+		t.Fatalf("configured sampling for a reasoning model: %v; want it dropped", err)
+	}
+	if req.Params.Temperature != nil || req.Params.TopP != nil || req.Params.MaxTokens != 100 {
+		t.Errorf("params = %+v; want only max_tokens inherited", req.Params)
+	}
+
+	req, err = prepare(p, providers.Request{Model: "chat"})
+	if err != nil || req.Params.Temperature == nil || *req.Params.Temperature != temp || req.Params.TopP == nil {
+		t.Errorf("chat model: params %+v, %v; want the configured sampling", req.Params, err)
+	}
+
+	explicit := 1.0
+	if _, err := prepare(p, providers.Request{Model: "reasoning", Params: providers.Params{Temperature: &explicit}}); err == nil {
+		t.Error("a template's temperature for a reasoning model was accepted")
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+// Record delimiters understood by `q map`.
+const (
+	delimLine  = "line"
+	delimNUL   = "nul"
+	delimJSONL = "jsonl"
+)
+
+// recordReader yields stdin records one at a time. The returned bool is false
+// once the input is exhausted. Empty line and NUL delimited records are
+// yielded too, so output N always answers input N.
+type recordReader func() (any, bool, error)
+
+func newRecordReader(r io.Reader, delim string) (recordReader, error) {
+	switch delim {
+	case delimLine, delimNUL:
+		sep := byte('\n')
+		if delim == delimNUL {
+			sep = 0
+		}
+		br := bufio.NewReader(r)
+		return func() (any, bool, error) {
+			s, err := br.ReadString(sep)
+			switch {
+			case err == io.EOF && s == "":
+				return nil, false, nil
+			case err != nil && err != io.EOF:
+				return nil, false, err
+			}
+			s = strings.TrimSuffix(s, string(sep))
+			if delim == delimLine {
+				s = strings.TrimSuffix(s, "\r")
+			}
+			return s, true, nil
+		}, nil
+	case delimJSONL:
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		return func() (any, bool, error) {
+			var v any
+			switch err := dec.Decode(&v); {
+			case err == io.EOF:
+				return nil, false, nil
+			case err != nil:
+				return nil, false, fmt.Errorf("invalid JSONL record: %w", err)
+			}
+			return v, true, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown delimiter %q\n\nUse one of: %s, %s, %s", delim, delimLine, delimNUL, delimJSONL)
+}
+
+// parseRecordTemplate parses a prompt template applied to each record. The
+// record itself is the template's dot; JSONL records expose their fields.
+func parseRecordTemplate(text string) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+	return template.New("map").Funcs(funcs).Option("missingkey=error").Parse(text)
+}
+
+// oneLine keeps a multi-line output on a single line for line-delimited
+// output: trailing newlines are dropped and the others written as \n.
+func oneLine(s string) string {
+	s = strings.TrimRight(s, "\r\n")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+type mapResult struct {
+	out string
+	err error
+}
+
+// mapRecords runs fn over every record with at most n calls in flight and
+// hands the results to emit in input order. The first failure cancels the
+// remaining work.
+func mapRecords(
+	ctx context.Context,
+	n int,
+	next recordReader,
+	fn func(context.Context, any) (string, error),
+	emit func(string) error,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan chan mapResult, n)
+	sem := make(chan struct{}, n)
+
+	go func() {
+		defer close(queue)
+		for {
+			rec, ok, err := next()
+			if !ok || err != nil {
+				if err != nil {
+					res := make(chan mapResult, 1)
+					res <- mapResult{err: err}
+					select {
+					case queue <- res:
+					case <-ctx.Done():
+					}
+				}
+				return
+			}
+
+			res := make(chan mapResult, 1)
+			select {
+			case queue <- res:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				defer func() { <-sem }()
+				out, err := fn(ctx, rec)
+				res <- mapResult{out, err}
+			}()
+		}
+	}()
+
+	for res := range queue {
+		var r mapResult
+		select {
+		case r = <-res:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if r.err != nil {
+			return r.err
+		}
+		if err := emit(r.out); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func (cli *CLI) mapCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "map TEMPLATE",
+		Short: "Apply a prompt template to each stdin record",
+		Long: "Reads stdin as a sequence of records and sends one prompt per record.\n" +
+			"The template is a Go text/template whose dot is the record: {{.}} for\n" +
+			"line and NUL delimited input, {{.field}} for JSONL objects.\n" +
+			"Outputs are written in input order, one per record. Blank records are\n" +
+			"not sent; they get a blank output, keeping input and output aligned.\n" +
+			"Unless records are NUL delimited, newlines within an output are written\n" +
+			"as \\n so that each output stays on one line.",
+		Example:      `  cat words.txt | q map "Translate to French: {{.}}"`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			modelFlag, _ := cmd.Flags().GetString("model")
+			delim, _ := cmd.Flags().GetString("delim")
+			concurrency, _ := cmd.Flags().GetInt("concurrency")
+			if concurrency < 1 {
+				return errors.New("concurrency must be at least 1")
+			}
+
+			tmpl, err := parseRecordTemplate(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid template: %w", err)
+			}
+			next, err := newRecordReader(os.Stdin, delim)
+			if err != nil {
+				return err
+			}
+
+			_, model, p, err := cli.resolve(modelFlag)
+			if err != nil {
+				return err
+			}
+
+			sep := "\n"
+			if delim == delimNUL {
+				sep = "\x00"
+			}
+			out := bufio.NewWriter(os.Stdout)
+			defer out.Flush()
+
+			ctx, err := cli.withCache(contextWithInterrupt(), cmd)
+			if err != nil {
+				return err
+			}
+			return mapRecords(ctx, concurrency, next,
+				func(ctx context.Context, rec any) (string, error) {
+					if rec == "" {
+						return "", nil
+					}
+					var prompt strings.Builder
+					if err := tmpl.Execute(&prompt, rec); err != nil {
+						return "", fmt.Errorf("template: %w", err)
+					}
+					return complete(ctx, p, userRequest(model, prompt.String()))
+				},
+				func(s string) error {
+					if sep == "\n" {
+						s = oneLine(s)
+					}
+					if _, err := out.WriteString(s + sep); err != nil {
+						return err
+					}
+					return out.Flush()
+				},
+			)
+		},
+	}
+	cmd.Flags().StringP("model", "m", "", "provider/model")
+	cmd.Flags().StringP("delim", "d", delimLine, "Record delimiter: line, nul or jsonl")
+	cmd.Flags().IntP("concurrency", "j", 4, "Number of prompts in flight")
+	addCacheFlags(cmd)
+	return cmd
+}
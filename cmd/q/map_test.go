@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// readAll returns every record of input.
+func readAll(t *testing.T, input, delim string) []any {
+	t.Helper()
+	next, err := newRecordReader(strings.NewReader(input), delim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recs []any
+	for {
+		rec, ok, err := next()
+		if err != nil {
+			t.Fatalf("%s %q: %v", delim, input, err)
+		}
+		if !ok {
+			return recs
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func TestRecordReader(t *testing.T) {
+	tests := []struct {
+		delim, input string
+		want         []any
+	}{
+		{delimLine, "a\nb\n", []any{"a", "b"}},
+		{delimLine, "a\r\nb", []any{"a", "b"}},
+		{delimLine, "a\n\nb\n\n", []any{"a", "", "b", ""}},
+		{delimLine, "", nil},
+		{delimNUL, "a b\x00c\nd\x00", []any{"a b", "c\nd"}},
+		{delimNUL, "a\x00\x00b", []any{"a", "", "b"}},
+		{delimJSONL, `{"n":1}` + "\n\n" + `{"n":2,"s":"x"}`, []any{
+			map[string]any{"n": json.Number("1")},
+			map[string]any{"n": json.Number("2"), "s": "x"},
+		}},
+	}
+	for _, tc := range tests {
+		if got := readAll(t, tc.input, tc.delim); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s %q = %#v; want %#v", tc.delim, tc.input, got, tc.want)
+		}
+	}
+
+	next, _ := newRecordReader(strings.NewReader("{\"n\":1}\nnot json\n"), delimJSONL)
+	next()
+	if _, _, err := next(); err == nil {
+		t.Error("invalid JSONL record: no error")
+	}
+	if _, err := newRecordReader(strings.NewReader(""), "tab"); err == nil {
+		t.Error("unknown delimiter: no error")
+	}
+}
+
+func TestOneLine(t *testing.T) {
+	tests := map[string]string{
+		"bonjour":             "bonjour",
+		"bonjour\n":           "bonjour",
+		"line one\nline two":  `line one\nline two`,
+		"a\r\nb\r\n\n":        `a\nb`,
+		"":                    "",
+		"trailing space \n\n": "trailing space ",
+	}
+	for in, want := range tests {
+		if got := oneLine(in); got != want {
+			t.Errorf("oneLine(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func TestMapRecords_Order(t *testing.T) {
+	input := "1\n2\n\n4\n5\n6\n\n8\n"
+	next, _ := newRecordReader(strings.NewReader(input), delimLine)
+	var inFlight, most atomic.Int32
+	var out []string
+	err := mapRecords(context.Background(), 3, next,
+		func(_ context.Context, rec any) (string, error) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+			}
+			s := rec.(string)
+			if s == "" {
+				return "", nil
+			}
+			// Early records finish last.
+			time.Sleep(time.Duration(10-int(s[0]-'0')) * time.Millisecond)
+			return "out " + s, nil
+		},
+		func(s string) error {
+			out = append(out, s)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"out 1", "out 2", "", "out 4", "out 5", "out 6", "", "out 8"}
+	if !slices.Equal(out, want) {
+		t.Errorf("outputs = %q; want %q, aligned with the input lines", out, want)
+	}
+	if m := most.Load(); m < 2 || m > 3 {
+		t.Errorf("%d calls in flight at most; want 2 or 3", m)
+	}
+}
+
+func TestMapRecords_FirstErrorStops(t *testing.T) {
+	next, _ := newRecordReader(strings.NewReader("a\nb\nc\nd\n"), delimLine)
+	fail := errors.New("model error")
+	var out []string
+	err := mapRecords(context.Background(), 2, next,
+		func(ctx context.Context, rec any) (string, error) {
+			if rec == "b" {
+				return "", fail
+			}
+			return fmt.Sprint(rec), nil
+		},
+		func(s string) error {
+			out = append(out, s)
+			return nil
+		})
+	if !errors.Is(err, fail) || !slices.Equal(out, []string{"a"}) {
+		t.Errorf("mapRecords = %v after %q; want the error after only a", err, out)
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/spf13/cobra"
+
+	"q/internal/config"
+	"q/internal/mcp"
+	"q/internal/providers"
+)
+
+const (
+	// mcpConnectTimeout bounds starting and greeting one MCP server.
+	mcpConnectTimeout = 30 * time.Second
+	// maxToolRounds is how many times in a row the model may ask for tool
+	// calls before the turn ends.
+	maxToolRounds = 10
+	// maxToolName is the longest function name the model accepts.
+	maxToolName = 64
+)
+
+var reToolNameChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// toolRoute is the server and name of a tool offered to the model.
+type toolRoute struct {
+	client *mcp.Client
+	name   string
+}
+
+// toolbox is the MCP servers a chat is connected to, and the tools they
+// offer the model.
+type toolbox struct {
+	clients []*mcp.Client
+	tools   []providers.Tool
+	routes  map[string]toolRoute // by the name given to the model
+	allowed map[string]bool      // tools called without asking
+}
+
+// connectMCP connects to the declared MCP servers that are not disabled, or
+// to those named in only. Servers that cannot be reached are reported and
+// left out. It returns nil if no server is connected.
+func connectMCP(ctx context.Context, only []string) (*toolbox, error) {
+	servers, err := config.GetMCPServers()
+	if err != nil {
+		return nil, err
+	}
+	names := only
+	for _, name := range only {
+		if _, ok := servers[name]; !ok {
+			return nil, fmt.Errorf("unknown MCP server %q\n\nDeclare it: q config set mcp_servers.%s.command COMMAND", name, name)
+		}
+	}
+	if len(only) == 0 {
+		for _, name := range slices.Sorted(maps.Keys(servers)) {
+			if !servers[name].Disabled {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	clients := make([]*mcp.Client, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, mcpConnectTimeout)
+			defer cancel()
+			c, err := mcp.Connect(ctx, name, servers[name])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "q: %v\n", err)
+				return
+			}
+			clients[i] = c
+		}()
+	}
+	wg.Wait()
+
+	tb := &toolbox{routes: map[string]toolRoute{}, allowed: map[string]bool{}}
+	for _, c := range clients {
+		if c == nil {
+			continue
+		}
+		tb.clients = append(tb.clients, c)
+		tools, err := c.Tools(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "q: mcp server %s: %v\n", c.Name, err)
+		}
+		for _, t := range tools {
+			name := toolName(c.Name, t.Name)
+			if _, dup := tb.routes[name]; dup {
+				fmt.Fprintf(os.Stderr, "q: mcp server %s: tool %s left out; its name clashes with another\n", c.Name, t.Name)
+				continue
+			}
+			params := t.InputSchema
+			if len(params) == 0 {
+				params = json.RawMessage(`{"type":"object","properties":{}}`)
+			}
+			tb.tools = append(tb.tools, providers.Tool{Name: name, Description: t.Description, Parameters: params})
+			tb.routes[name] = toolRoute{c, t.Name}
+			if slices.Contains(servers[c.Name].AutoApprove, t.Name) {
+				tb.allowed[name] = true
+			}
+		}
+	}
+	if len(tb.clients) == 0 {
+		return nil, nil
+	}
+	return tb, nil
+}
+
+// toolName is the name a server's tool is offered to the model under:
+// server__tool, within the characters and length function names allow.
+func toolName(server, tool string) string {
+	name := reToolNameChars.ReplaceAllString(server+"__"+tool, "_")
+	if len(name) > maxToolName {
+		name = name[:maxToolName]
+	}
+	return name
+}
+
+func (tb *toolbox) close() {
+	for _, c := range tb.clients {
+		c.Close()
+	}
+}
+
+// summary reports what each connected server offers.
+func (tb *toolbox) summary() {
+	ctx := context.Background()
+	for _, c := range tb.clients {
+		tools, _ := c.Tools(ctx)
+		resources, _ := c.Resources(ctx)
+		prompts, _ := c.Prompts(ctx)
+		fmt.Fprintf(os.Stderr, "MCP server %s: %s, %s, %s\n", c.Name,
+			plural(len(tools), "tool"), plural(len(resources), "resource"), plural(len(prompts), "prompt"))
+	}
+	if len(tb.clients) > 0 {
+		fmt.Fprintln(os.Stderr, "Type /mcp to list them, /resource SERVER URI to attach a resource, /prompt SERVER NAME [key=value ...] to use a prompt")
+	}
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func (tb *toolbox) client(name string) (*mcp.Client, error) {
+	for _, c := range tb.clients {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("no MCP server %q connected; see /mcp", name)
+}
+
+// list prints each server with its tools, resources and prompts.
+func (tb *toolbox) list(ctx context.Context) {
+	for i, c := range tb.clients {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s (%s %s)\n", c.Name, c.Server.Name, c.Server.Version)
+		tools, err := c.Tools(ctx)
+		printSection("Tools", err, len(tools), func(i int) (string, string) { return tools[i].Name, tools[i].Description })
+		resources, err := c.Resources(ctx)
+		printSection("Resources", err, len(resources), func(i int) (string, string) {
+			r := resources[i]
+			return r.URI, strings.TrimSpace(cmp.Or(r.Title, r.Name) + " " + r.Description)
+		})
+		prompts, err := c.Prompts(ctx)
+		printSection("Prompts", err, len(prompts), func(i int) (string, string) {
+			p := prompts[i]
+			var args []string
+			for _, a := range p.Arguments {
+				if a.Required {
+					args = append(args, a.Name+"=…")
+				} else {
+					args = append(args, "["+a.Name+"=…]")
+				}
+			}
+			return strings.TrimSpace(p.Name + " " + strings.Join(args, " ")), p.Description
+		})
+	}
+}
+
+func printSection(title string, err error, n int, item func(int) (name, desc string)) {
+	switch {
+	case err != nil:
+		fmt.Printf("  %s: ⚠️  %s\n", title, firstLine(err))
+		return
+	case n == 0:
+		return
+	}
+	fmt.Printf("  %s:\n", title)
+	for i := range n {
+		name, desc := item(i)
+		if desc, _, _ = strings.Cut(desc, "\n"); desc != "" {
+			fmt.Printf("    %s — %s\n", name, desc)
+		} else {
+			fmt.Printf("    %s\n", name)
+		}
+	}
+}
+
+// readResource returns the resource at uri as text to send with the next
+// message.
+func (tb *toolbox) readResource(ctx context.Context, server, uri string) (string, error) {
+	c, err := tb.client(server)
+	if err != nil {
+		return "", err
+	}
+	contents, err := c.ReadResource(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, r := range contents {
+		fmt.Fprintf(&b, "<resource uri=%q>\n%s\n</resource>\n\n", r.URI, r.String())
+	}
+	return b.String(), nil
+}
+
+// prompt returns the messages of a server's prompt, filled in with
+// key=value args.
+func (tb *toolbox) prompt(ctx context.Context, server, name string, args []string) ([]providers.Message, error) {
+	c, err := tb.client(server)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, a := range args {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok {
+			return nil, fmt.Errorf("prompt argument %q: want key=value", a)
+		}
+		values[k] = v
+	}
+	msgs, err := c.GetPrompt(ctx, name, values)
+	if err != nil {
+		return nil, err
+	}
+	var out []providers.Message
+	for _, m := range msgs {
+		out = append(out, providers.Message{Role: m.Role, Content: m.Content.String()})
+	}
+	if len(out) == 0 || out[len(out)-1].Role != "user" {
+		return nil, fmt.Errorf("prompt %s does not end with a user message", name)
+	}
+	return out, nil
+}
+
+// call runs a tool call the model asked for, once the user allows it, and
+// returns the result for the model.
+func (tb *toolbox) call(ctx context.Context, rl *readline.Instance, call providers.ToolCall) string {
+	route, ok := tb.routes[call.Name]
+	if !ok {
+		return "Error: no tool named " + call.Name
+	}
+	label := route.client.Name + "." + route.name
+	fmt.Fprintf(os.Stderr, "→ %s %s\n", label, call.Arguments)
+	if !tb.allowed[call.Name] {
+		ok, always, err := approve(rl, label)
+		switch {
+		case err != nil:
+			return "Error: " + err.Error()
+		case !ok:
+			return "The user declined this tool call."
+		case always:
+			tb.allowed[call.Name] = true
+		}
+	}
+	res, err := route.client.CallTool(ctx, route.name, json.RawMessage(call.Arguments))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "  ⚠️  %s\n", firstLine(err))
+		return "Error: " + err.Error()
+	}
+	text := res.Text()
+	if res.IsError {
+		line, _, _ := strings.Cut(text, "\n")
+		fmt.Fprintf(os.Stderr, "  ⚠️  %s\n", line)
+		return "Error: " + text
+	}
+	return text
+}
+
+// approve asks the user whether a tool may be called: once, always for the
+// rest of the chat, or not.
+func approve(rl *readline.Instance, label string) (ok, always bool, err error) {
+	prompt := rl.Config.Prompt
+	defer rl.SetPrompt(prompt)
+	rl.SetPrompt(fmt.Sprintf("Allow %s? [y]es, [n]o, [a]lways: ", label))
+	for {
+		answer, err := rl.Readline()
+		if err != nil {
+			return false, false, errors.New("cancelled by the user")
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true, false, nil
+		case "a", "always":
+			return true, true, nil
+		case "n", "no", "":
+			return false, false, nil
+		}
+	}
+}
+
+// converse completes req, calling the tools the model asks for until it
+// answers, and returns req with the assistant and tool messages appended.
+// Replies are printed as they arrive, after prefix; a reply that only asks
+// for tool calls prints nothing.
+func (tb *toolbox) converse(ctx context.Context, p providers.Provider, req providers.Request, rl *readline.Instance, stream bool, prefix func()) (providers.Request, error) {
+	req.Tools = tb.tools
+	printed := false
+	say := func(s string) {
+		if !printed {
+			prefix()
+			printed = true
+		}
+		fmt.Print(s)
+	}
+	if stream {
+		req.OnDelta = say
+	}
+	for round := 1; ; round++ {
+		m, err := completeTools(ctx, p, req)
+		if err != nil {
+			return req, err
+		}
+		if !stream && m.Content != "" {
+			say(m.Content)
+		}
+		req.Messages = append(req.Messages, m)
+		if len(m.ToolCalls) == 0 {
+			if !printed {
+				prefix()
+			}
+			return req, nil
+		}
+		if printed {
+			fmt.Println()
+			printed = false
+		}
+		for _, call := range m.ToolCalls {
+			req.Messages = append(req.Messages, providers.Message{Role: "tool", ToolCallID: call.ID, Content: tb.call(ctx, rl, call)})
+		}
+		if round == maxToolRounds {
+			fmt.Fprintf(os.Stderr, "q: stopped after %d rounds of tool calls; reply to let the model continue\n", maxToolRounds)
+			return req, nil
+		}
+	}
+}
+
+// command runs a chat command. It returns false for text that is not one.
+// A resource read is added to *attach, for the next message; a prompt's
+// messages are returned, to be sent as the next turn.
+func (tb *toolbox) command(ctx context.Context, text string, attach *string) (handled bool, prompt []providers.Message, err error) {
+	fields := strings.Fields(text)
+	switch fields[0] {
+	case "/mcp":
+		tb.list(ctx)
+		return true, nil, nil
+	case "/resource":
+		if len(fields) != 3 {
+			return true, nil, errors.New("usage: /resource SERVER URI")
+		}
+		s, err := tb.readResource(ctx, fields[1], fields[2])
+		if err != nil {
+			return true, nil, err
+		}
+		*attach += s
+		fmt.Fprintf(os.Stderr, "Attached %s to your next message\n", fields[2])
+		return true, nil, nil
+	case "/prompt":
+		if len(fields) < 3 {
+			return true, nil, errors.New("usage: /prompt SERVER NAME [key=value ...]")
+		}
+		msgs, err := tb.prompt(ctx, fields[1], fields[2], fields[3:])
+		return true, msgs, err
+	}
+	return false, nil, nil
+}
+
+func mcpCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "mcp",
+		Short: "Inspect the MCP servers q chat uses",
+		Long: `Model Context Protocol servers give q chat tools, resources and prompts.
+Declare them in the config, started as a command or reached at a URL:
+
+  q config set mcp_servers.db.command db-mcp-server
+  q config set mcp_servers.db.args "--read-only"
+  q config set mcp_servers.tracker.url https://tracker.example.com/mcp
+  q config set mcp_servers.tracker.headers.Authorization "Bearer TOKEN"`,
+	}
+	list := &cobra.Command{
+		Use:          "list [server...]",
+		Short:        "Connect to MCP servers and list their tools, resources and prompts",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := contextWithInterrupt()
+			tb, err := connectMCP(ctx, args)
+			if err != nil {
+				return err
+			}
+			if tb == nil {
+				if servers, _ := config.GetMCPServers(); len(servers) > 0 {
+					return errors.New("no MCP servers connected")
+				}
+				return errors.New("no MCP servers declared\n\nDeclare one: q config set mcp_servers.NAME.command COMMAND")
+			}
+			defer tb.close()
+			tb.list(ctx)
+			return nil
+		},
+	}
+	cmd.AddCommand(list)
+	return cmd
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"q/internal/config"
+	"q/internal/providers"
+	"q/internal/providers/mock"
+)
+
+// healthy is the mock provider answering despite $Q_MOCK_ERROR, standing in
+// for a fallback on another provider.
+type healthy struct {
+	providers.Provider
+	calls *int
+}
+
+func (h healthy) Complete(_ context.Context, r providers.Request) (string, error) {
+	*h.calls++
+	if r.OnDelta != nil {
+		r.OnDelta("fine")
+	}
+	return "fine", nil
+}
+
+func TestResolveChain_Mock(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("Q_OPENAI_API_KEY", "")
+	err := config.Update(func(c *config.Config) error {
+		c.Fallbacks = map[string][]string{"mock/echo": {"mock/fixed"}}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fallback string
+		want     []string
+		notice   string
+	}{
+		{"", []string{"mock/echo", "mock/fixed"}, ""},
+		{"nope/x,openai/gpt-4o,mock/script", []string{"mock/echo", "mock/script"},
+			"q: skipping fallback nope/x: unknown provider: nope\nq: skipping fallback openai/gpt-4o: no API key for openai\n"},
+	}
+	for _, tc := range tests {
+		cmd := &cobra.Command{}
+		addFallbackFlags(cmd)
+		if tc.fallback != "" {
+			cmd.Flags().Set("fallback", tc.fallback)
+		}
+		var chain []target
+		_, stderr := captureOutput(t, func() {
+			chain, err = NewCLI().resolveChain(cmd, "mock/echo")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, c := range chain {
+			got = append(got, c.String())
+		}
+		if !slices.Equal(got, tc.want) || stderr != tc.notice {
+			t.Errorf("--fallback %q: chain %v, notices %q; want %v, %q", tc.fallback, got, stderr, tc.want, tc.notice)
+		}
+	}
+}
+
+func TestExecutePrompt_MockErrors(t *testing.T) {
+	withModel(t, "mock/"+mock.Echo, providers.ModelInfo{})
+	req := userRequest(mock.Echo, "one two three")
+	p := mock.NewProvider()
+
+	tests := []struct {
+		err, after string
+		stream     bool
+		fallback   bool // whether healthy answers
+		wantErr    bool
+	}{
+		{"", "", true, false, false},
+		{"503", "", true, true, false},
+		{"429", "", false, true, false},
+		{"timeout", "", true, true, false},
+		{"401", "", true, false, true}, // not transient
+		{"context_length", "", false, false, true},
+		{"503", "2", true, false, true},  // streaming began
+		{"503", "2", false, true, false}, // nothing was printed yet
+	}
+	for _, tc := range tests {
+		t.Setenv(mock.ErrorEnv, tc.err)
+		t.Setenv(mock.ErrorAfterEnv, tc.after)
+		var calls int
+		chain := []target{{"mock", mock.Echo, p}, {"other", "model", healthy{p, &calls}}}
+
+		var err error
+		stdout, stderr := captureOutput(t, func() {
+			err = executePrompt(context.Background(), chain, req, true, tc.stream, outputText)
+		})
+		if (err != nil) != tc.wantErr || (calls > 0) != tc.fallback {
+			t.Errorf("%s after %q, stream %v: err %v, fallback %v; want error %v, fallback %v",
+				tc.err, tc.after, tc.stream, err, calls > 0, tc.wantErr, tc.fallback)
+			continue
+		}
+		switch {
+		case tc.fallback:
+			if stdout != "fine" || !strings.Contains(stderr, "q: mock/echo failed") || !strings.Contains(stderr, "falling back to other/model") {
+				t.Errorf("%s: stdout %q, stderr %q; want the fallback's answer and a notice", tc.err, stdout, stderr)
+			}
+		case tc.after != "":
+			if stdout != "one two " {
+				t.Errorf("%s after %s words: stdout %q; want the words streamed", tc.err, tc.after, stdout)
+			}
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"q/internal/config"
+	"q/internal/modelcache"
+	"q/internal/providers"
+)
+
+// modelCacheKey returns the cache key for a provider under the active
+// profile's base URL.
+func modelCacheKey(providerName string) (string, error) {
+	base, err := config.GetBaseURL(providerName)
+	return modelcache.Key(providerName, base), err
+}
+
+// knownModels returns a provider's built-in models followed by any others in
+// the cached model list. It never touches the network.
+func (cli *CLI) knownModels(p providers.Provider) []string {
+	models := slices.Clone(p.SupportedModels())
+	cache, err := modelcache.Open()
+	if err != nil {
+		return models
+	}
+	key, err := modelCacheKey(p.Name())
+	if err != nil {
+		return models
+	}
+	if e, ok := cache.Get(key); ok {
+		for _, m := range e.Models {
+			if !slices.Contains(models, m) {
+				models = append(models, m)
+			}
+		}
+	}
+	return models
+}
+
+// refreshModels fetches a provider's model list into the cache when the
+// provider can list models and the cached list is missing or stale, or
+// always if force is set. It returns the number of models fetched, or -1 if
+// nothing was fetched.
+func (cli *CLI) refreshModels(ctx context.Context, p providers.Provider, force bool) (int, error) {
+	lister, ok := p.(providers.ModelLister)
+	if !ok {
+		return -1, nil
+	}
+	cache, err := modelcache.Open()
+	if err != nil {
+		return -1, err
+	}
+	key, err := modelCacheKey(p.Name())
+	if err != nil {
+		return -1, err
+	}
+	if e, ok := cache.Get(key); ok && !force && cache.Fresh(e) {
+		return -1, nil
+	}
+	models, err := lister.ListModels(ctx)
+	if err != nil {
+		return -1, err
+	}
+	return len(models), cache.Put(key, models)
+}
+
+// checkModel warns, once per model, when model is not among the provider's
+// known models. It only consults the cached list, which q models keeps up to
+// date, so prompts never wait on the network. Unknown models are still sent:
+// the provider has the final say.
+func (cli *CLI) checkModel(p providers.Provider, model string) {
+	if slices.Contains(cli.knownModels(p), model) {
+		return
+	}
+	id := p.Name() + "/" + model
+	if cli.warned[id] {
+		return
+	}
+	if cli.warned == nil {
+		cli.warned = make(map[string]bool)
+	}
+	cli.warned[id] = true
+	fmt.Fprintf(os.Stderr, "q: warning: %s is not a known model; trying it anyway (update the list with: q models refresh)\n", id)
+}
+
+// aliasesByTarget inverts the configured aliases: provider/model to the
+// sorted aliases naming it.
+func aliasesByTarget(aliases map[string]string) map[string][]string {
+	out := make(map[string][]string)
+	for _, name := range slices.Sorted(maps.Keys(aliases)) {
+		out[aliases[name]] = append(out[aliases[name]], name)
+	}
+	return out
+}
+
+// modelInfo returns what is known about a model: the provider's catalog
+// entry with the config's overrides applied.
+func modelInfo(res config.Resolved, p providers.Provider, model string) providers.ModelInfo {
+	var info providers.ModelInfo
+	if c, ok := p.(providers.Cataloger); ok {
+		info, _ = c.ModelInfo(model)
+	}
+	return info.Merge(res.Models[p.Name()+"/"+model])
+}
+
+// modelEntry is one line of q models list.
+type modelEntry struct {
+	ID      string   `json:"id"`
+	Aliases []string `json:"aliases,omitempty"`
+	providers.ModelInfo
+}
+
+// listModels prints every known model, refreshing stale lists from providers
+// that have a key. format is "", "long" or "json".
+func (cli *CLI) listModels(ctx context.Context, format string) error {
+	res, err := resolvedConfig()
+	if err != nil {
+		return err
+	}
+	byTarget := aliasesByTarget(res.Aliases)
+	var entries []modelEntry
+	for _, providerName := range cli.registry.Names() {
+		provider, _ := cli.registry.Lookup(providerName)
+		if key, _ := config.GetAPIKey(providerName); key != "" {
+			if _, err := cli.refreshModels(ctx, provider, false); err != nil {
+				fmt.Fprintf(os.Stderr, "q: could not update %s models: %s\n", providerName, firstLine(err))
+			}
+		}
+		for _, model := range cli.knownModels(provider) {
+			id := providerName + "/" + model
+			entries = append(entries, modelEntry{id, byTarget[id], modelInfo(res, provider, model)})
+		}
+	}
+
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case "long":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "MODEL\tCONTEXT\tOUTPUT\tINPUT\tTOOLS\tJSON\t$/1M IN\t$/1M OUT\tALIASES")
+		for _, e := range entries {
+			in, out := "-", "-"
+			if p := e.Pricing; p != nil {
+				in, out = strconv.FormatFloat(p.Input, 'f', 2, 64), strconv.FormatFloat(p.Output, 'f', 2, 64)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.ID,
+				tokenCount(e.ContextWindow), tokenCount(e.MaxOutput), orDash(strings.Join(e.Input, ",")),
+				yesNo(e.Tools), yesNo(e.JSONMode), in, out, strings.Join(e.Aliases, ", "))
+		}
+		return w.Flush()
+	default:
+		for _, e := range entries {
+			if len(e.Aliases) > 0 {
+				fmt.Printf("%s (%s)\n", e.ID, strings.Join(e.Aliases, ", "))
+			} else {
+				fmt.Println(e.ID)
+			}
+		}
+	}
+	return nil
+}
+
+func tokenCount(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.Itoa(n)
+}
+
+func yesNo(b *bool) string {
+	switch {
+	case b == nil:
+		return "-"
+	case *b:
+		return "yes"
+	}
+	return "no"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// listFormat reads the --long and --json flags of q models list.
+func listFormat(cmd *cobra.Command) (string, error) {
+	long, _ := cmd.Flags().GetBool("long")
+	asJSON, _ := cmd.Flags().GetBool("json")
+	switch {
+	case long && asJSON:
+		return "", errors.New("--long and --json cannot be used together")
+	case asJSON:
+		return "json", nil
+	case long:
+		return "long", nil
+	}
+	return "", nil
+}
+
+func addListFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolP("long", "l", false, "Show context window, output limit, inputs, capabilities and prices")
+	cmd.Flags().Bool("json", false, "Print the catalog as JSON")
+}
+
+func (cli *CLI) modelsCmd() *cobra.Command {
+	runList := func(cmd *cobra.Command, _ []string) error {
+		format, err := listFormat(cmd)
+		if err != nil {
+			return err
+		}
+		return cli.listModels(contextWithInterrupt(), format)
+	}
+	cmd := &cobra.Command{
+		Use:          "models",
+		Short:        "List available provider/model combinations",
+		SilenceUsage: true,
+		RunE:         runList,
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List available models with their aliases",
+		Long: "Lists every provider/model: the built-in list plus models fetched from\n" +
+			"the provider's API, cached for a day. Aliases from the config are shown\n" +
+			"in parentheses; define them with: q config set aliases.NAME provider/model\n\n" +
+			"Limits and prices come from the built-in catalog; correct or extend it\n" +
+			"with: q config set models.PROVIDER/MODEL.FIELD VALUE",
+		SilenceUsage: true,
+		RunE:         runList,
+	}
+
+	refresh := &cobra.Command{
+		Use:          "refresh",
+		Short:        "Fetch the current model lists from provider APIs",
+		SilenceUsage: true,
+		RunE: func(*cobra.Command, []string) error {
+			ctx := contextWithInterrupt()
+			var failed []string
+			for _, providerName := range cli.registry.Names() {
+				p, _ := cli.registry.Lookup(providerName)
+				n, err := cli.refreshModels(ctx, p, true)
+				switch {
+				case err != nil:
+					fmt.Printf("%s: ⚠️  %s\n", providerName, firstLine(err))
+					failed = append(failed, providerName)
+				case n < 0:
+					fmt.Printf("%s: built-in list only\n", providerName)
+				default:
+					fmt.Printf("%s: %d models\n", providerName, n)
+				}
+			}
+			if len(failed) > 0 {
+				return fmt.Errorf("could not refresh models for %s", strings.Join(failed, ", "))
+			}
+			return nil
+		},
+	}
+
+	addListFlags(cmd)
+	addListFlags(list)
+	cmd.AddCommand(list, refresh)
+	return cmd
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"q/internal/config"
+	"q/internal/providers"
+)
+
+// lister is a provider with a fixed set of built-in models. Listing models
+// from its API fails the test: resolving names must not touch the network.
+type lister struct {
+	providers.Provider
+	t      *testing.T
+	name   string
+	models []string
+}
+
+func (l *lister) Name() string              { return l.name }
+func (l *lister) SupportedModels() []string { return l.models }
+
+func (l *lister) ListModels(context.Context) ([]string, error) {
+	l.t.Errorf("%s: ListModels called", l.name)
+	return nil, nil
+}
+
+// sharedModels returns a CLI with providers a and b, which both offer
+// "shared", and the configured aliases.
+func sharedModels(t *testing.T, aliases map[string]string) *CLI {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	old := resolvedConfig
+	t.Cleanup(func() { resolvedConfig = old })
+	resolvedConfig = func() (config.Resolved, error) {
+		return config.Resolved{Aliases: aliases}, nil
+	}
+	r := providers.NewRegistry()
+	r.Register(
+		&lister{t: t, name: "a", models: []string{"solo", "shared"}},
+		&lister{t: t, name: "b", models: []string{"shared", "other"}},
+	)
+	return &CLI{registry: r}
+}
+
+func TestExpandModel(t *testing.T) {
+	cli := sharedModels(t, map[string]string{"fast": "b/other", "shared": "a/shared"})
+	tests := []struct {
+		name, want, err string
+	}{
+		{"fast", "b/other", ""},
+		{"shared", "a/shared", ""}, // an alias wins over bare names
+		{"solo", "a/solo", ""},
+		{"other", "b/other", ""},
+		{"b/shared", "b/shared", ""},
+		{"nope", "", "unknown model or alias 'nope'"},
+	}
+	for _, tc := range tests {
+		got, err := cli.expandModel(tc.name)
+		if got != tc.want || (err == nil) != (tc.err == "") || (err != nil && !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("expandModel(%q) = %q, %v; want %q, %q", tc.name, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestValidateModel_Ambiguous(t *testing.T) {
+	cli := sharedModels(t, nil)
+	_, _, err := cli.validateModel("shared")
+	if err == nil || !strings.Contains(err.Error(), "ambiguous model 'shared': a/shared, b/shared") {
+		t.Errorf("validateModel(shared) error = %v; want both matches listed", err)
+	}
+	provider, model, err := cli.validateModel("solo")
+	if provider != "a" || model != "solo" || err != nil {
+		t.Errorf("validateModel(solo) = %s, %s, %v; want a, solo", provider, model, err)
+	}
+}
+
+func TestAliasesByTarget(t *testing.T) {
+	got := aliasesByTarget(map[string]string{"quick": "b/other", "fast": "b/other", "big": "a/solo"})
+	want := map[string][]string{"b/other": {"fast", "quick"}, "a/solo": {"big"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("aliasesByTarget = %v; want %v", got, want)
+	}
+}
+
+func TestCheckModel_CachedOnly(t *testing.T) {
+	cli := sharedModels(t, nil)
+	// A stale list, which a network refresh would replace.
+	dir := filepath.Join(os.Getenv("XDG_CACHE_HOME"), "q")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	seed := `{"a": {"fetched_at": "2020-01-01T00:00:00Z", "models": ["listed"]}}`
+	if err := os.WriteFile(filepath.Join(dir, "models.json"), []byte(seed), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := cli.registry.Lookup("a")
+
+	if got := cli.knownModels(p); !slices.Equal(got, []string{"solo", "shared", "listed"}) {
+		t.Errorf("knownModels = %v; want the built-in models, then the cached ones", got)
+	}
+	_, stderr := captureOutput(t, func() {
+		cli.checkModel(p, "listed")
+		cli.checkModel(p, "solo")
+		for range 3 {
+			cli.checkModel(p, "unlisted")
+		}
+	})
+	if n := strings.Count(stderr, "warning"); n != 1 || !strings.Contains(stderr, "a/unlisted is not a known model") {
+		t.Errorf("stderr = %q; want one warning, for a/unlisted", stderr)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"q/internal/config"
+)
+
+func (cli *CLI) profileCmd() *cobra.Command {
+	cmd := &cobra.Command{Use: "profile", Short: "Manage config profiles"}
+
+	list := &cobra.Command{
+		Use:          "list",
+		Short:        "List profiles, marking the active one",
+		SilenceUsage: true,
+		RunE: func(*cobra.Command, []string) error {
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
+			current := cfg.CurrentProfile()
+			for _, name := range cfg.ProfileNames() {
+				marker := " "
+				if name == current {
+					marker = "*"
+				}
+				p, _ := cfg.Profile(name)
+				if p.DefaultModel != "" {
+					fmt.Printf("%s %s (%s)\n", marker, name, p.DefaultModel)
+				} else {
+					fmt.Printf("%s %s\n", marker, name)
+				}
+			}
+			return nil
+		},
+	}
+
+	use := &cobra.Command{
+		Use:          "use NAME",
+		Short:        "Make a profile the active one",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			if err := config.SelectProfile(args[0]); err != nil {
+				return err
+			}
+			fmt.Printf("Using profile %s\n", args[0])
+			return nil
+		},
+	}
+
+	create := &cobra.Command{
+		Use:          "create NAME",
+		Short:        "Create an empty profile",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			model, _ := cmd.Flags().GetString("model")
+			if model != "" {
+				providerName, modelName, err := cli.validateModel(model)
+				if err != nil {
+					return err
+				}
+				model = providerName + "/" + modelName
+			}
+			if err := config.CreateProfile(args[0], config.Profile{DefaultModel: model}); err != nil {
+				return err
+			}
+			fmt.Printf("Created profile %s\n\nSet its key: q --profile %[1]s keys set --provider PROVIDER --key KEY\n", args[0])
+			return nil
+		},
+	}
+	create.Flags().StringP("model", "m", "", "default provider/model for the profile")
+
+	del := &cobra.Command{
+		Use:          "delete NAME",
+		Short:        "Delete a profile and its keys",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			if err := config.DeleteProfile(args[0], cli.registry.Names()); err != nil {
+				return err
+			}
+			fmt.Printf("Deleted profile %s\n", args[0])
+			return nil
+		},
+	}
+
+	cmd.AddCommand(list, use, create, del)
+	return cmd
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"q/internal/config"
+	"q/internal/providers"
+	"q/internal/respcache"
+	"q/internal/server"
+)
+
+// defaultServeAddr is where q serve listens without --addr or serve.addr.
+const defaultServeAddr = "localhost:8080"
+
+// isLoopback reports whether addr only accepts connections from this
+// machine.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (cli *CLI) serveCmd() *cobra.Command {
+	var addr string
+	var project bool
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the configured providers as a local OpenAI-compatible API",
+		Long: `Serve /v1/chat/completions and /v1/models over HTTP, so tools built for
+OpenAI can use every provider q knows with the keys q manages. Requests name
+their model as provider/model, or an alias.
+
+Clients authenticate with the bearer tokens in serve.tokens, keyed by client
+name:
+
+  q config set serve.tokens.editor "$(openssl rand -hex 24)"
+
+Without tokens, q serve only listens on the loopback interface, and only
+answers JSON requests addressed to localhost, so web pages cannot use it.
+
+The project config (.q.json or .q.toml) of the directory q serve starts in is
+ignored, since its clients are not working in that project. --project applies
+it to every request.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if !project {
+				resolvedConfig = sync.OnceValues(config.ResolveGlobal)
+			}
+			cfg, err := config.GetServe()
+			if err != nil {
+				return err
+			}
+			if !cmd.Flags().Changed("addr") && cfg.Addr != "" {
+				addr = cfg.Addr
+			}
+			if len(cfg.Tokens) == 0 && !isLoopback(addr) {
+				return fmt.Errorf("refusing to serve %s without tokens: anyone who can reach it could use your API keys\n\nAdd one: q config set serve.tokens.NAME TOKEN\nOr listen locally: q serve --addr %s", addr, defaultServeAddr)
+			}
+
+			cached, err := cli.withCache(context.Background(), cmd)
+			if err != nil {
+				return err
+			}
+			cache := respcache.FromContext(cached)
+
+			s := server.New(cli.registry)
+			s.Tokens = cfg.Tokens
+			s.Log = os.Stderr
+			s.Models = cli.knownModels
+			// Requests are answered as q answers prompts: with the configured
+			// parameters and system prompt, the model's limits, the response
+			// cache, and the oldest turns dropped to fit the context window.
+			s.Complete = func(ctx context.Context, p providers.Provider, req providers.Request) (string, error) {
+				req, dropped, err := fitHistory(p, req)
+				if err != nil {
+					return "", err
+				}
+				if dropped > 0 {
+					fmt.Fprintf(os.Stderr, "q: dropped the %d oldest messages to fit the context window of %s/%s\n", dropped, p.Name(), req.Model)
+				}
+				if cache != nil {
+					ctx = respcache.NewContext(ctx, cache)
+				}
+				return complete(ctx, p, req)
+			}
+			var mu sync.Mutex // requireKey is not safe for concurrent use
+			s.Route = func(model string) (string, string, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				full, err := cli.expandModel(model)
+				if err != nil {
+					return "", "", err
+				}
+				provider, name, ok := strings.Cut(full, "/")
+				if !ok {
+					return "", "", errors.New("invalid model format: use provider/model (e.g., openai/gpt-4o)")
+				}
+				if _, ok := cli.registry.Lookup(provider); ok {
+					if err := cli.requireKey(provider); err != nil {
+						return "", "", err
+					}
+				}
+				return provider, name, nil
+			}
+
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				return err
+			}
+			srv := &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+			ctx := contextWithInterrupt()
+			go func() {
+				<-ctx.Done()
+				shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				srv.Shutdown(shutdown)
+			}()
+
+			auth := "no tokens"
+			if n := len(cfg.Tokens); n > 0 {
+				auth = fmt.Sprintf("%d client token(s)", n)
+			}
+			fmt.Fprintf(os.Stderr, "Serving on http://%s/v1 (%s)\n", ln.Addr(), auth)
+			if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", defaultServeAddr, "Address to listen on, e.g. :8080 (overrides serve.addr)")
+	cmd.Flags().BoolVar(&project, "project", false, "Apply the working directory's project config to every request")
+	addCacheFlags(cmd)
+	return cmd
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"q/internal/templates"
+)
+
+// parseVars turns --var key=value pairs into template variables. A value of
+// @path binds the contents of a file and @- binds stdin.
+func parseVars(pairs []string, stdin io.Reader) (map[string]string, error) {
+	vars := make(map[string]string, len(pairs))
+	stdinUsed := false
+	for _, pair := range pairs {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --var %q\n\nUse: --var key=value, key=@file or key=@-", pair)
+		}
+		if src, isRef := strings.CutPrefix(val, "@"); isRef {
+			var b []byte
+			var err error
+			if src == "-" {
+				if stdinUsed {
+					return nil, errors.New("stdin can only be bound to one variable")
+				}
+				stdinUsed = true
+				b, err = io.ReadAll(stdin)
+			} else {
+				b, err = os.ReadFile(src)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("--var %s: %w", key, err)
+			}
+			val = string(b)
+		}
+		vars[key] = val
+	}
+	return vars, nil
+}
+
+// openEditor opens path in $VISUAL or $EDITOR and waits for it to exit.
+func openEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	args := strings.Fields(editor)
+	c := exec.Command(args[0], append(args[1:], path)...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return c.Run()
+}
+
+func (cli *CLI) runCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "run TEMPLATE",
+		Short:        "Run a stored prompt template",
+		Example:      `  git diff | q run review --var diff=@- --var lang=Go`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := parseFlags(cmd)
+			if err != nil {
+				return err
+			}
+			pairs, err := cmd.Flags().GetStringArray("var")
+			if err != nil {
+				return err
+			}
+
+			t, err := templates.Load(args[0])
+			if err != nil {
+				return err
+			}
+			vars, err := parseVars(pairs, os.Stdin)
+			if err != nil {
+				return err
+			}
+			prompt, err := t.Render(vars)
+			if err != nil {
+				return err
+			}
+
+			modelFlag := f.model
+			if modelFlag == "" {
+				modelFlag = t.Model
+			}
+			chain, err := cli.resolveChain(cmd, modelFlag)
+			if err != nil {
+				return err
+			}
+
+			req := userRequest("", prompt)
+			req.System = t.System
+			req.Params = t.Params
+			f.transport.set(&req)
+
+			ctx, err := cli.withCache(contextWithInterrupt(), cmd)
+			if err != nil {
+				return err
+			}
+			return executePrompt(ctx, chain, req, f.raw, !f.noStream, f.output)
+		},
+	}
+	addCommonFlags(cmd)
+	addFallbackFlags(cmd)
+	addOutputFlags(cmd)
+	cmd.Flags().StringArray("var", nil, "Template variable as key=value, key=@file or key=@- (repeatable)")
+	return cmd
+}
+
+func templatesCmd() *cobra.Command {
+	cmd := &cobra.Command{Use: "templates", Short: "Manage prompt templates"}
+
+	list := &cobra.Command{
+		Use:          "list",
+		Short:        "List stored templates",
+		SilenceUsage: true,
+		RunE: func(*cobra.Command, []string) error {
+			ts, err := templates.List()
+			if err != nil {
+				return err
+			}
+			for _, t := range ts {
+				if t.Description != "" {
+					fmt.Printf("%s: %s\n", t.Name, t.Description)
+				} else {
+					fmt.Println(t.Name)
+				}
+			}
+			return nil
+		},
+	}
+
+	show := &cobra.Command{
+		Use:          "show NAME",
+		Short:        "Print a template",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			path, err := templates.Locate(args[0])
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			fmt.Print(string(data))
+			return nil
+		},
+	}
+
+	edit := &cobra.Command{
+		Use:          "edit NAME",
+		Short:        "Open a template in $EDITOR",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			path, err := templates.Locate(args[0])
+			if err != nil {
+				return err
+			}
+			if err := openEditor(path); err != nil {
+				return err
+			}
+			_, err = templates.Load(args[0])
+			return err
+		},
+	}
+
+	newCmd := &cobra.Command{
+		Use:          "new NAME",
+		Short:        "Create a template and open it in $EDITOR",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			noEdit, _ := cmd.Flags().GetBool("no-edit")
+			path, err := templates.Create(args[0], templates.Skeleton)
+			if err != nil {
+				return err
+			}
+			if !noEdit {
+				if err := openEditor(path); err != nil {
+					return err
+				}
+			}
+			fmt.Printf("Saved template %s: %s\n", args[0], path)
+			return nil
+		},
+	}
+	newCmd.Flags().Bool("no-edit", false, "Write the skeleton without opening an editor")
+
+	cmd.AddCommand(list, show, edit, newCmd)
+	return cmd
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"q/internal/config"
+	"q/internal/providers"
+	"q/internal/tokenizer"
+)
+
+// Chat framing, in tokens, of the OpenAI-style encodings: each message is
+// wrapped in start and end markers with its role, and the reply is primed
+// with one more header.
+const (
+	tokensPerMessage = 3
+	tokensPerReply   = 3
+)
+
+// requestTokens returns the number of prompt tokens in req, counted with the
+// model's tokenizer, and whether the count is exact. Without the model's
+// encoding or an installed vocabulary it falls back to
+// providers.EstimateTokens; it never downloads one.
+func requestTokens(info providers.ModelInfo, req providers.Request) (int, bool) {
+	if info.Encoding == "" {
+		return providers.EstimateTokens(req), false
+	}
+	enc, err := tokenizer.Get(info.Encoding)
+	if err != nil {
+		return providers.EstimateTokens(req), false
+	}
+	n := tokensPerReply
+	if req.System != "" {
+		n += tokensPerMessage + enc.Count(req.System)
+	}
+	for _, m := range req.Messages {
+		n += tokensPerMessage + enc.Count(m.Content) + len(m.Images)*providers.ImageTokens
+		for _, c := range m.ToolCalls {
+			n += enc.Count(c.Name) + enc.Count(c.Arguments)
+		}
+	}
+	for _, t := range req.Tools {
+		n += enc.Count(t.Name) + enc.Count(t.Description) + enc.Count(string(t.Parameters))
+	}
+	return n, true
+}
+
+func (cli *CLI) tokensCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tokens [text]",
+		Short: "Count the tokens in text or a file",
+		Long: "Counts tokens with the tokenizer of the model given with -m, or of the\n" +
+			"default model. Text \"-\" reads stdin.\n\n" +
+			"A missing vocabulary is downloaded into the cache first. --fetch downloads\n" +
+			"all of them, so that prompts are checked with exact counts.",
+		Example: `  q tokens "How many tokens is this?"
+  q tokens -f README.md -m openai/gpt-3.5-turbo
+  git diff | q tokens -
+  q tokens --fetch`,
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, _ := cmd.Flags().GetString("file")
+			encoding, _ := cmd.Flags().GetString("encoding")
+			model, _ := cmd.Flags().GetString("model")
+			fetch, _ := cmd.Flags().GetBool("fetch")
+
+			var text string
+			switch {
+			case fetch && file == "" && len(args) == 0:
+				for _, name := range slices.Sorted(maps.Keys(tokenizer.Checksums)) {
+					if err := tokenizer.Fetch(name); err != nil {
+						return err
+					}
+				}
+				return nil
+			case file != "" && len(args) > 0:
+				return errors.New("give text or --file, not both")
+			case file != "":
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return err
+				}
+				text = string(data)
+			case len(args) == 0:
+				_ = cmd.Help()
+				return errors.New("text or --file required")
+			case args[0] == "-":
+				data, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return err
+				}
+				text = string(data)
+			default:
+				text = args[0]
+			}
+
+			if encoding == "" {
+				var err error
+				if encoding, err = cli.modelEncoding(model); err != nil {
+					return err
+				}
+			}
+			enc, err := tokenizer.Get(encoding)
+			if errors.Is(err, tokenizer.ErrNoVocab) {
+				fmt.Fprintf(os.Stderr, "q: downloading the %s vocabulary\n", encoding)
+				if ferr := tokenizer.Fetch(encoding); ferr != nil {
+					fmt.Fprintf(os.Stderr, "q: %s; estimating\n", firstLine(ferr))
+					fmt.Println(providers.EstimateText(text))
+					return nil
+				}
+				enc, err = tokenizer.Get(encoding)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Println(enc.Count(text))
+			return nil
+		},
+	}
+	cmd.Flags().StringP("file", "f", "", "Count the tokens in a file")
+	cmd.Flags().StringP("model", "m", "", "provider/model whose tokenizer to use (default: the default model)")
+	cmd.Flags().StringP("encoding", "e", "", "Tokenizer to use: "+tokenizer.CL100K+" or "+tokenizer.O200K)
+	cmd.Flags().Bool("fetch", false, "Download the tokenizer vocabularies")
+	return cmd
+}
+
+// modelEncoding returns the tokenizer of model, or of the default model if
+// model is empty, falling back to o200k_base for models without one.
+func (cli *CLI) modelEncoding(model string) (string, error) {
+	if model == "" {
+		var err error
+		if model, err = config.GetDefaultModel(); err != nil || model == "" {
+			return tokenizer.O200K, err
+		}
+	}
+	providerName, modelName, err := cli.validateModel(model)
+	if err != nil {
+		return "", err
+	}
+	res, err := resolvedConfig()
+	if err != nil {
+		return "", err
+	}
+	p, _ := cli.registry.Lookup(providerName)
+	if info := modelInfo(res, p, modelName); info.Encoding != "" {
+		return info.Encoding, nil
+	}
+	return tokenizer.O200K, nil
+}
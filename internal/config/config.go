@@ -1,17 +1,133 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"q/internal/fsutil"
+	"q/internal/providers"
 )
 
 // Config is the unified configuration payload stored at $XDG_CONFIG_HOME/q/config.json.
 // It contains the default model and API keys for all providers.
+//
+// The top-level DefaultModel, APIKeys, BaseURLs and Params form the implicit
+// "default" profile; other profiles live in Profiles.
 type Config struct {
-	Comment      string            `json:"// Note,omitempty"`
-	DefaultModel string            `json:"default_model"`
-	APIKeys      map[string]string `json:"api_keys"`
+	Comment       string            `json:"// Note,omitempty"`
+	SchemaVersion int               `json:"schema_version"` // see migrations
+	DefaultModel  string            `json:"default_model"`
+	APIKeys       map[string]string `json:"api_keys"`
+	BaseURLs      map[string]string `json:"base_urls,omitempty"`
+	Params        providers.Params  `json:"params,omitzero"`
+
+	// ActiveProfile is the profile selected with `q profile use`.
+	ActiveProfile string             `json:"active_profile,omitempty"`
+	Profiles      map[string]Profile `json:"profiles,omitempty"`
+
+	// Fallbacks maps a provider/model to the models tried, in order, when it
+	// is rate-limited or unavailable.
+	Fallbacks map[string][]string `json:"fallbacks,omitempty"`
+
+	// Aliases maps short names, usable wherever a model is expected, to a
+	// provider/model, e.g. "fast": "openai/gpt-4.1-nano".
+	Aliases map[string]string `json:"aliases,omitempty"`
+
+	// Models overrides or extends the built-in model catalog, keyed by
+	// provider/model. Only the fields set replace the built-in ones.
+	Models map[string]providers.ModelInfo `json:"models,omitempty"`
+
+	Chat ChatConfig `json:"chat,omitzero"`
+
+	// Cache keeps responses on disk so identical requests are answered
+	// without calling the API.
+	Cache CacheConfig `json:"cache,omitzero"`
+
+	// Serve configures q serve, the local OpenAI-compatible server.
+	Serve ServeConfig `json:"serve,omitzero"`
+
+	// MCPServers maps names to the Model Context Protocol servers q chat
+	// connects to for tools, resources and prompts.
+	MCPServers map[string]MCPServerConfig `json:"mcp_servers,omitempty"`
+
+	// Secrets selects where API keys are stored. APIKeys is only used by the
+	// plaintext backend.
+	Secrets SecretsConfig `json:"secrets,omitzero"`
+}
+
+// History strategies for q chat.
+const (
+	HistoryTruncate  = "truncate-oldest" // drop the oldest turns once the window is full (default)
+	HistorySliding   = "sliding-window"  // send only the last Window turns
+	HistorySummarize = "summarize"       // condense older turns into a summary
+)
+
+// HistoryStrategies lists the valid ChatConfig.History values.
+var HistoryStrategies = []string{HistoryTruncate, HistorySliding, HistorySummarize}
+
+// ChatConfig controls how q chat keeps a conversation within the model's
+// context window.
+type ChatConfig struct {
+	History string `json:"history,omitempty"` // one of HistoryStrategies
+	Window  int    `json:"window,omitempty"`  // turns kept by sliding-window
+
+	// SummaryModel writes summaries, usually a cheaper model than the one
+	// chatted with (default: the chat model).
+	SummaryModel string `json:"summary_model,omitempty"`
+	// SummarizeAt is the prompt size, in tokens, above which older turns
+	// are summarized (default: three quarters of the room in the window).
+	SummarizeAt int `json:"summarize_at,omitempty"`
+}
+
+// CacheConfig controls the response cache.
+type CacheConfig struct {
+	Enabled bool   `json:"enabled,omitempty"`     // cache without --cache
+	TTL     string `json:"ttl,omitempty"`         // how long a response is reused, e.g. "24h" (default: a week)
+	MaxSize int    `json:"max_size_mb,omitempty"` // megabytes kept before the least recently used are evicted (default: 100)
+}
+
+// ServeConfig configures q serve.
+type ServeConfig struct {
+	Addr string `json:"addr,omitempty"` // listen address (default: localhost:8080)
+
+	// Tokens maps client names, used in the request log, to the bearer
+	// tokens they authenticate with.
+	Tokens map[string]string `json:"tokens,omitempty"`
+}
+
+// MCPServerConfig declares an MCP server, started as a command speaking over
+// stdin and stdout, or reached at a streamable HTTP URL.
+type MCPServerConfig struct {
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"` // added to q's environment
+
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"` // e.g. Authorization
+
+	// AutoApprove lists tools called without asking first.
+	AutoApprove []string `json:"auto_approve,omitempty"`
+	Disabled    bool     `json:"disabled,omitempty"`
+}
+
+// SecretsConfig selects and configures the API key backend.
+type SecretsConfig struct {
+	Backend string `json:"backend,omitempty"` // plaintext (default), encrypted or command
+
+	// File is the encrypted backend's key file, by default keys.enc next to
+	// config.json.
+	File string `json:"file,omitempty"`
+
+	// Shell commands used by the command backend; see secrets.CommandConfig.
+	GetCommand    string `json:"get_command,omitempty"`
+	SetCommand    string `json:"set_command,omitempty"`
+	DeleteCommand string `json:"delete_command,omitempty"`
 }
 
 const configFileName = "config.json"
@@ -37,19 +153,45 @@ func configPath() (string, error) {
 	return filepath.Join(dir, configFileName), nil
 }
 
-// LoadConfig loads the configuration, returning defaults if missing.
+// loaded caches the last config parsed, keyed by the file's contents, so
+// repeated lookups in one process only re-read the file.
+var loaded struct {
+	sync.Mutex
+	data []byte
+	cfg  Config
+}
+
+// LoadConfig loads the configuration, returning defaults if missing. Files
+// written by older versions of q are upgraded first; see migrations.
 func LoadConfig() (Config, error) {
 	path, err := configPath()
 	if err != nil {
 		return Config{}, err
 	}
+	return loadConfig(path, false)
+}
+
+// loadConfig reads path, migrating it if needed. locked reports whether the
+// caller already holds the config lock.
+func loadConfig(path string, locked bool) (Config, error) {
 	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return Config{APIKeys: make(map[string]string)}, nil
-		}
+	if err != nil && !os.IsNotExist(err) {
 		return Config{}, err
 	}
+	if needsMigration(filepath.Dir(path), data) {
+		if data, err = migrate(path, locked); err != nil {
+			return Config{}, err
+		}
+	}
+	if data == nil {
+		return Config{SchemaVersion: SchemaVersion, APIKeys: make(map[string]string)}, nil
+	}
+
+	loaded.Lock()
+	defer loaded.Unlock()
+	if loaded.data != nil && bytes.Equal(data, loaded.data) {
+		return loaded.cfg.clone(), nil
+	}
 	var cfg Config
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return Config{}, err
@@ -57,68 +199,350 @@ func LoadConfig() (Config, error) {
 	if cfg.APIKeys == nil {
 		cfg.APIKeys = make(map[string]string)
 	}
+	loaded.data, loaded.cfg = data, cfg.clone()
 	return cfg, nil
 }
 
-// SaveConfig persists the configuration to disk.
+// migrate upgrades the config file at path, taking the config lock unless
+// the caller holds it, and returns its new contents.
+func migrate(path string, locked bool) ([]byte, error) {
+	if !locked {
+		unlock, err := fsutil.Lock(path)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	// Another process may have migrated the file while we waited.
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if needsMigration(filepath.Dir(path), data) {
+		if err := migrateFile(path, data); err != nil {
+			return nil, err
+		}
+	}
+	data, err = os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// SaveConfig persists the configuration to disk. Prefer Update, which also
+// guards the read: SaveConfig alone can overwrite a concurrent change.
 func SaveConfig(cfg Config) error {
 	path, err := configPath()
 	if err != nil {
 		return err
 	}
+	unlock, err := fsutil.Lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return saveConfig(path, cfg)
+}
+
+// Update applies fn to the stored configuration and saves the result, holding
+// the config lock throughout so concurrent q processes cannot lose each
+// other's changes. Nothing is written if fn fails.
+func Update(fn func(*Config) error) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	unlock, err := fsutil.Lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cfg, err := loadConfig(path, true)
+	if err != nil {
+		return err
+	}
+	if err := fn(&cfg); err != nil {
+		return err
+	}
+	return saveConfig(path, cfg)
+}
+
+func saveConfig(path string, cfg Config) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
 		return err
 	}
 
 	// Add warning comment about API credentials
 	cfg.Comment = "This file stores secret API credentials. Do not share!"
+	cfg.SchemaVersion = SchemaVersion
 
 	data, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0o600)
+	return fsutil.WriteFileAtomic(path, data, 0o600)
 }
 
-// GetAPIKey returns the API key for a provider, or empty if not set.
-func GetAPIKey(provider string) (string, error) {
+// clone returns a copy of c that shares no maps, slices or pointers with it,
+// so callers may change what LoadConfig returns.
+func (c Config) clone() Config {
+	c.APIKeys = maps.Clone(c.APIKeys)
+	c.BaseURLs = maps.Clone(c.BaseURLs)
+	c.Params = cloneParams(c.Params)
+	c.Aliases = maps.Clone(c.Aliases)
+	if c.Profiles != nil {
+		profiles := make(map[string]Profile, len(c.Profiles))
+		for name, p := range c.Profiles {
+			p.APIKeys = maps.Clone(p.APIKeys)
+			p.BaseURLs = maps.Clone(p.BaseURLs)
+			p.Params = cloneParams(p.Params)
+			p.Fallbacks = cloneFallbacks(p.Fallbacks)
+			profiles[name] = p
+		}
+		c.Profiles = profiles
+	}
+	c.Fallbacks = cloneFallbacks(c.Fallbacks)
+	if c.Models != nil {
+		models := make(map[string]providers.ModelInfo, len(c.Models))
+		for k, m := range c.Models {
+			m.Input = slices.Clone(m.Input)
+			m.Output = slices.Clone(m.Output)
+			m.Tools = clonePtr(m.Tools)
+			m.JSONMode = clonePtr(m.JSONMode)
+			m.Sampling = clonePtr(m.Sampling)
+			m.Pricing = clonePtr(m.Pricing)
+			models[k] = m
+		}
+		c.Models = models
+	}
+	c.Serve.Tokens = maps.Clone(c.Serve.Tokens)
+	if c.MCPServers != nil {
+		servers := make(map[string]MCPServerConfig, len(c.MCPServers))
+		for name, s := range c.MCPServers {
+			s.Args = slices.Clone(s.Args)
+			s.Env = maps.Clone(s.Env)
+			s.Headers = maps.Clone(s.Headers)
+			s.AutoApprove = slices.Clone(s.AutoApprove)
+			servers[name] = s
+		}
+		c.MCPServers = servers
+	}
+	return c
+}
+
+func cloneFallbacks(f map[string][]string) map[string][]string {
+	if f == nil {
+		return nil
+	}
+	out := make(map[string][]string, len(f))
+	for k, v := range f {
+		out[k] = slices.Clone(v)
+	}
+	return out
+}
+
+func cloneParams(p providers.Params) providers.Params {
+	p.Temperature = clonePtr(p.Temperature)
+	p.TopP = clonePtr(p.TopP)
+	return p
+}
+
+// clonePtr returns a pointer to a copy of *p, or nil.
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// SourceFlag names the --api-key flag as the source of a key. Keys from the
+// environment are reported as "$" followed by the variable name, and stored
+// keys by their secret backend's name.
+const SourceFlag = "--api-key"
+
+// DefaultModelEnv overrides the stored default model when set.
+const DefaultModelEnv = "Q_DEFAULT_MODEL"
+
+var (
+	overridesMu sync.RWMutex
+	overrides   = make(map[string]string)
+)
+
+// OverrideAPIKey makes key take precedence over every other source for
+// provider, for the rest of the process. It backs the global --api-key flag.
+func OverrideAPIKey(provider, key string) {
+	overridesMu.Lock()
+	defer overridesMu.Unlock()
+	overrides[provider] = key
+}
+
+// APIKeyEnvVars returns the environment variables consulted for a provider's
+// key, highest precedence first: Q_<PROVIDER>_API_KEY, then the vendor's own
+// <PROVIDER>_API_KEY (e.g. OPENAI_API_KEY).
+func APIKeyEnvVars(provider string) []string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z':
+			return r - 'a' + 'A'
+		case 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return r
+		}
+		return '_'
+	}, provider)
+	return []string{"Q_" + name + "_API_KEY", name + "_API_KEY"}
+}
+
+// LookupAPIKey returns the API key for a provider and where it came from.
+// Precedence, highest first:
+//
+//  1. the --api-key flag (see OverrideAPIKey)
+//  2. Q_<PROVIDER>_API_KEY
+//  3. <PROVIDER>_API_KEY
+//  4. the configured secret backend
+//
+// Empty environment variables are ignored. The key is empty if none is set.
+func LookupAPIKey(provider string) (key, source string, err error) {
+	overridesMu.RLock()
+	key = overrides[provider]
+	overridesMu.RUnlock()
+	if key != "" {
+		return key, SourceFlag, nil
+	}
+	for _, env := range APIKeyEnvVars(provider) {
+		if key = os.Getenv(env); key != "" {
+			return key, "$" + env, nil
+		}
+	}
 	cfg, err := LoadConfig()
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+	profile, _, err := cfg.activeProfile()
+	if err != nil {
+		return "", "", err
+	}
+	b, err := SecretBackend(cfg)
+	if err != nil {
+		return "", "", err
 	}
-	return cfg.APIKeys[provider], nil
+	if key, err = b.Get(secretName(profile, provider)); err != nil || key == "" {
+		return "", "", err
+	}
+	return key, b.Name(), nil
 }
 
-// SetAPIKey sets and persists an API key for a provider.
+// GetAPIKey returns the API key for a provider, or empty if not set.
+// See LookupAPIKey for the precedence order.
+func GetAPIKey(provider string) (string, error) {
+	key, _, err := LookupAPIKey(provider)
+	return key, err
+}
+
+// SetAPIKey stores an API key for a provider, in the active profile, in the
+// configured secret backend.
 func SetAPIKey(provider, key string) error {
 	cfg, err := LoadConfig()
 	if err != nil {
 		return err
 	}
-	cfg.APIKeys[provider] = key
-	return SaveConfig(cfg)
+	profile, _, err := cfg.activeProfile()
+	if err != nil {
+		return err
+	}
+	b, err := SecretBackend(cfg)
+	if err != nil {
+		return err
+	}
+	return b.Set(secretName(profile, provider), key)
 }
 
-// GetDefaultModel returns the stored default model identifier.
+// GetDefaultModel returns the default model identifier. Q_DEFAULT_MODEL takes
+// precedence over the project config, which takes precedence over the
+// stored value.
 func GetDefaultModel() (string, error) {
-	cfg, err := LoadConfig()
-	if err != nil {
-		return "", err
-	}
-	return cfg.DefaultModel, nil
+	r, err := Resolve()
+	return r.DefaultModel, err
 }
 
-// SetDefaultModel sets and persists the default model identifier.
+// SetDefaultModel sets and persists the default model of the active profile.
 func SetDefaultModel(model string) error {
+	return Update(func(cfg *Config) error {
+		name, prof, err := cfg.activeProfile()
+		if err != nil {
+			return err
+		}
+		prof.DefaultModel = model
+		cfg.SetProfile(name, prof)
+		return nil
+	})
+}
+
+// GetFallbacks returns the fallback chain for a provider/model: the project
+// config's if it has one, otherwise the active profile's.
+func GetFallbacks(model string) ([]string, error) {
+	r, err := Resolve()
+	return r.Fallbacks[model], err
+}
+
+// GetChat returns the chat settings.
+func GetChat() (ChatConfig, error) {
 	cfg, err := LoadConfig()
-	if err != nil {
-		return err
-	}
-	cfg.DefaultModel = model
-	return SaveConfig(cfg)
+	return cfg.Chat, err
+}
+
+// GetCache returns the response cache settings.
+func GetCache() (CacheConfig, error) {
+	cfg, err := LoadConfig()
+	return cfg.Cache, err
+}
+
+// GetServe returns the q serve settings.
+func GetServe() (ServeConfig, error) {
+	cfg, err := LoadConfig()
+	return cfg.Serve, err
+}
+
+// GetMCPServers returns the declared MCP servers.
+func GetMCPServers() (map[string]MCPServerConfig, error) {
+	cfg, err := LoadConfig()
+	return cfg.MCPServers, err
 }
 
 // ConfigPath returns the full filesystem path to the config file (config.json).
 func ConfigPath() (string, error) {
 	return configPath()
 }
+
+// Dir returns the directory holding config.json and other per-user state.
+func Dir() (string, error) {
+	return configDir()
+}
+
+// DataDir returns the directory for data q builds and keeps, such as document
+// indexes: $XDG_DATA_HOME/q or ~/.local/share/q.
+func DataDir() (string, error) {
+	if x := os.Getenv("XDG_DATA_HOME"); x != "" {
+		return filepath.Join(x, "q"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "q"), nil
+}
+
+// CacheDir returns the directory for data q can rebuild, such as model lists:
+// $XDG_CACHE_HOME/q or the platform's user cache directory.
+func CacheDir() (string, error) {
+	if x := os.Getenv("XDG_CACHE_HOME"); x != "" {
+		return filepath.Join(x, "q"), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "q"), nil
+}
@@ -1,10 +1,14 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"q/internal/secrets"
 )
 
 func TestLoadConfig_NotExist(t *testing.T) {
@@ -43,6 +47,52 @@ func TestSaveAndLoadConfig(t *testing.T) {
 	}
 }
 
+func TestLoadConfig_ReturnsCopy(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", tmp)
+	data := `{
+  "api_keys": {"openai": "k"},
+  "params": {"temperature": 0.5},
+  "profiles": {"work": {"base_urls": {"openai": "http://x"}, "params": {"top_p": 0.9}}},
+  "fallbacks": {"a/b": ["c/d"]},
+  "models": {"a/b": {"input": ["text"], "tools": true, "pricing": {"input": 1, "output": 2}}},
+  "serve": {"tokens": {"ci": "t"}},
+  "mcp_servers": {"fs": {"command": "fs", "args": ["-r"], "env": {"A": "1"}, "headers": {"H": "v"}, "auto_approve": ["read"]}}
+}`
+	os.MkdirAll(filepath.Join(tmp, "q"), 0o700)
+	if err := os.WriteFile(filepath.Join(tmp, "q", configFileName), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(cfg)
+	cfg.APIKeys["openai"] = "changed"
+	*cfg.Params.Temperature = 1
+	cfg.Profiles["work"].BaseURLs["openai"] = "changed"
+	*cfg.Profiles["work"].Params.TopP = 0
+	cfg.Fallbacks["a/b"][0] = "changed"
+	m := cfg.Models["a/b"]
+	m.Input[0] = "changed"
+	*m.Tools = false
+	m.Pricing.Input = 100
+	cfg.Serve.Tokens["ci"] = "changed"
+	s := cfg.MCPServers["fs"]
+	s.Args[0] = "changed"
+	s.Env["A"] = "changed"
+	s.Headers["H"] = "changed"
+	s.AutoApprove[0] = "changed"
+
+	got, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := json.Marshal(got); !bytes.Equal(b, want) {
+		t.Errorf("LoadConfig after changing an earlier result = %s; want %s", b, want)
+	}
+}
+
 func TestConfigPath_Fallback(t *testing.T) {
 	// Unset XDG_CONFIG_HOME to use UserConfigDir fallback
 	os.Unsetenv("XDG_CONFIG_HOME")
@@ -128,3 +178,95 @@ func TestConfigPath(t *testing.T) {
 		t.Errorf("expected ConfigPath %q, got %q", want, path)
 	}
 }
+
+func TestLookupAPIKey_Precedence(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	t.Setenv("Q_ACME_API_KEY", "")
+	t.Setenv("ACME_API_KEY", "")
+
+	if key, source, err := LookupAPIKey("acme"); err != nil || key != "" || source != "" {
+		t.Fatalf("LookupAPIKey with nothing set = %q, %q, %v; want empty", key, source, err)
+	}
+
+	steps := []struct {
+		set         func()
+		key, source string
+	}{
+		{func() { SetAPIKey("acme", "from-file") }, "from-file", secrets.Plaintext},
+		{func() { t.Setenv("ACME_API_KEY", "from-vendor-env") }, "from-vendor-env", "$ACME_API_KEY"},
+		{func() { t.Setenv("Q_ACME_API_KEY", "from-q-env") }, "from-q-env", "$Q_ACME_API_KEY"},
+		{func() { OverrideAPIKey("acme", "from-flag") }, "from-flag", SourceFlag},
+	}
+	t.Cleanup(func() { OverrideAPIKey("acme", "") })
+	for _, step := range steps {
+		step.set()
+		key, source, err := LookupAPIKey("acme")
+		if err != nil {
+			t.Fatalf("LookupAPIKey: %v", err)
+		}
+		if key != step.key || source != step.source {
+			t.Errorf("LookupAPIKey = %q, %q; want %q, %q", key, source, step.key, step.source)
+		}
+	}
+}
+
+func TestAPIKeyEnvVars(t *testing.T) {
+	got := APIKeyEnvVars("open-ai")
+	want := []string{"Q_OPEN_AI_API_KEY", "OPEN_AI_API_KEY"}
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("APIKeyEnvVars = %v; want %v", got, want)
+	}
+}
+
+func TestGetDefaultModel_Env(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	if err := SetDefaultModel("a/stored"); err != nil {
+		t.Fatalf("SetDefaultModel: %v", err)
+	}
+	t.Setenv(DefaultModelEnv, "a/env")
+	if got, err := GetDefaultModel(); err != nil || got != "a/env" {
+		t.Errorf("GetDefaultModel = %q, %v; want %q", got, err, "a/env")
+	}
+}
+
+func TestMigrateKeys_ToEncrypted(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	t.Setenv(PassphraseEnv, "hunter2")
+	t.Setenv("Q_OPENAI_API_KEY", "")
+	t.Setenv("OPENAI_API_KEY", "")
+
+	if err := SetAPIKey("openai", "sk-plain"); err != nil {
+		t.Fatalf("SetAPIKey: %v", err)
+	}
+	moved, err := MigrateKeys([]string{"openai"}, SecretsConfig{Backend: secrets.Encrypted})
+	if err != nil {
+		t.Fatalf("MigrateKeys: %v", err)
+	}
+	if len(moved) != 1 {
+		t.Fatalf("moved = %v; want [openai]", moved)
+	}
+
+	path, _ := ConfigPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if strings.Contains(string(data), "sk-plain") {
+		t.Errorf("config.json still holds the plaintext key: %s", data)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "q", "keys.enc")); err != nil {
+		t.Errorf("encrypted key file missing: %v", err)
+	}
+
+	key, source, err := LookupAPIKey("openai")
+	if err != nil || key != "sk-plain" || source != secrets.Encrypted {
+		t.Errorf("LookupAPIKey = %q, %q, %v; want sk-plain from encrypted", key, source, err)
+	}
+
+	if _, err := MigrateKeys([]string{"openai"}, SecretsConfig{Backend: secrets.Encrypted}); err == nil {
+		t.Error("expected error migrating to the active backend")
+	}
+}
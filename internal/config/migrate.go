@@ -0,0 +1,245 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"q/internal/fsutil"
+)
+
+// A migration upgrades a config.json, decoded as generic JSON, from the
+// previous schema version to version. Migrations run in order on load and the
+// upgraded file is written back after a backup of the original.
+type migration struct {
+	version int
+	name    string
+	apply   func(m *migrationRun) error
+}
+
+// migrationRun is the state shared by the steps of one upgrade.
+type migrationRun struct {
+	dir    string         // config directory
+	config map[string]any // config.json being upgraded
+
+	// retire lists files folded into config.json; they are renamed to
+	// NAME.bak once the upgraded config is saved.
+	retire []string
+}
+
+// migrations is the registry of schema upgrades, oldest first. Append new
+// steps; never edit or reorder released ones.
+var migrations = []migration{
+	{1, "move the legacy flat key map into api_keys", migrateFlatKeys},
+	{2, "merge the legacy default.json into default_model", migrateDefaultJSON},
+	{3, "move profile/provider entries of api_keys into their profiles", migrateProfileKeys},
+}
+
+// SchemaVersion is the config.json schema written by this build.
+var SchemaVersion = migrations[len(migrations)-1].version
+
+const legacyDefaultFile = "default.json"
+
+// migrateFlatKeys handles the original layout, in which config.json held
+// nothing but {"provider": "key"}. Anything else, such as a misspelt setting,
+// is left for validation to report.
+func migrateFlatKeys(m *migrationRun) error {
+	if len(m.config) == 0 {
+		return nil
+	}
+	t := reflect.TypeFor[Config]()
+	for k, v := range m.config {
+		if _, known := fieldByJSONName(t, k); known || !isProviderName(k) {
+			return nil
+		}
+		if s, ok := v.(string); !ok || s == "" || strings.ContainsAny(s, "/ \t\n") {
+			return nil
+		}
+	}
+	m.config = map[string]any{"api_keys": m.config}
+	return nil
+}
+
+func isProviderName(s string) bool {
+	return s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyz0123456789-") == ""
+}
+
+// migrateDefaultJSON folds default.json, which held the default model in the
+// original layout, into config.json. A default already in config.json wins.
+func migrateDefaultJSON(m *migrationRun) error {
+	path := filepath.Join(m.dir, legacyDefaultFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var model string
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	switch v := v.(type) {
+	case string:
+		model = v
+	case map[string]any:
+		for _, k := range []string{"default_model", "model", "default"} {
+			if s, ok := v[k].(string); ok {
+				model = s
+				break
+			}
+		}
+	}
+	if current, _ := m.config["default_model"].(string); current == "" && model != "" {
+		m.config["default_model"] = model
+	}
+	m.retire = append(m.retire, path)
+	return nil
+}
+
+// migrateProfileKeys moves entries of the flat api_keys map named
+// profile/provider, the secret names the other backends use, into the
+// profile they belong to: "work/openai" becomes profiles.work.api_keys.openai.
+// A key the profile already holds wins.
+func migrateProfileKeys(m *migrationRun) error {
+	keys, _ := m.config["api_keys"].(map[string]any)
+	for name, key := range keys {
+		profile, provider, ok := strings.Cut(name, "/")
+		if !ok {
+			continue
+		}
+		if profile == DefaultProfile {
+			if _, exists := keys[provider]; !exists {
+				keys[provider] = key
+			}
+			delete(keys, name)
+			continue
+		}
+		profiles, _ := m.config["profiles"].(map[string]any)
+		if profiles == nil {
+			profiles = make(map[string]any)
+			m.config["profiles"] = profiles
+		}
+		p, _ := profiles[profile].(map[string]any)
+		if p == nil {
+			p = make(map[string]any)
+			profiles[profile] = p
+		}
+		pkeys, _ := p["api_keys"].(map[string]any)
+		if pkeys == nil {
+			pkeys = make(map[string]any)
+			p["api_keys"] = pkeys
+		}
+		if _, exists := pkeys[provider]; !exists {
+			pkeys[provider] = key
+		}
+		delete(keys, name)
+	}
+	return nil
+}
+
+// schemaVersion reads schema_version from a decoded config. Files written
+// before versioning have none and count as version 0.
+func schemaVersion(m map[string]any) (int, error) {
+	switch v := m["schema_version"].(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		if v != float64(int(v)) || v < 0 {
+			return 0, fmt.Errorf("invalid schema_version %v", v)
+		}
+		return int(v), nil
+	}
+	return 0, fmt.Errorf("invalid schema_version %v", m["schema_version"])
+}
+
+// upgrade runs the migrations newer than the config's version. It reports
+// whether any ran.
+func upgrade(run *migrationRun) (bool, error) {
+	from, err := schemaVersion(run.config)
+	if err != nil {
+		return false, err
+	}
+	if from > SchemaVersion {
+		return false, fmt.Errorf("config.json uses schema version %d, newer than this q supports (%d)\n\nUpgrade q to use it", from, SchemaVersion)
+	}
+	if from == SchemaVersion {
+		return false, nil
+	}
+	for _, mig := range migrations {
+		if mig.version <= from {
+			continue
+		}
+		if err := mig.apply(run); err != nil {
+			return false, fmt.Errorf("migrating config to schema %d (%s): %w", mig.version, mig.name, err)
+		}
+	}
+	run.config["schema_version"] = SchemaVersion
+	return true, nil
+}
+
+// needsMigration reports whether data, the contents of config.json or nil if
+// it does not exist, must be upgraded before use.
+func needsMigration(dir string, data []byte) bool {
+	if data == nil {
+		_, err := os.Stat(filepath.Join(dir, legacyDefaultFile))
+		return err == nil
+	}
+	var probe struct {
+		SchemaVersion *int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return false // reported by the caller's own decode
+	}
+	return probe.SchemaVersion == nil || *probe.SchemaVersion != SchemaVersion
+}
+
+// migrateFile upgrades config.json at path in place. The caller must hold the
+// config lock. The original is kept as config.json.vN.bak. A file that still
+// holds unknown keys after the upgrade is left untouched, since saving it
+// would drop them.
+func migrateFile(path string, data []byte) error {
+	run := &migrationRun{dir: filepath.Dir(path), config: make(map[string]any)}
+	if data != nil {
+		if err := json.Unmarshal(data, &run.config); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	from, err := schemaVersion(run.config)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	changed, err := upgrade(run)
+	if err != nil || !changed {
+		return err
+	}
+
+	upgraded, err := json.Marshal(run.config)
+	if err != nil {
+		return err
+	}
+	cfg, err := decodeStrict(upgraded)
+	if err != nil {
+		return fmt.Errorf("%s: cannot migrate to schema %d: %w\n\nFix or remove the setting, then run q again", path, SchemaVersion, err)
+	}
+	if data != nil {
+		backup := fmt.Sprintf("%s.v%d.bak", path, from)
+		if err := fsutil.WriteFileAtomic(backup, data, 0o600); err != nil {
+			return fmt.Errorf("backing up config before migration: %w", err)
+		}
+	}
+	if err := saveConfig(path, cfg); err != nil {
+		return err
+	}
+	for _, f := range run.retire {
+		if err := os.Rename(f, f+".bak"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newRun(t *testing.T, src string) *migrationRun {
+	t.Helper()
+	run := &migrationRun{dir: t.TempDir(), config: make(map[string]any)}
+	if err := json.Unmarshal([]byte(src), &run.config); err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
+
+func TestMigrateFlatKeys(t *testing.T) {
+	run := newRun(t, `{"openai": "sk-1", "anthropic": "sk-2"}`)
+	if err := migrateFlatKeys(run); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := run.config["api_keys"].(map[string]any)
+	if len(run.config) != 1 || keys["openai"] != "sk-1" || keys["anthropic"] != "sk-2" {
+		t.Errorf("config = %v; want keys under api_keys", run.config)
+	}
+
+	// Current layouts and misspelt settings are not key maps.
+	for _, src := range []string{
+		`{}`,
+		`{"default_model": "openai/gpt-4o", "api_keys": {"openai": "sk-1"}}`,
+		`{"defualt_model": "openai/gpt-4o"}`,
+		`{"openai": "sk-1", "theme": {"dark": true}}`,
+	} {
+		run := newRun(t, src)
+		before, _ := json.Marshal(run.config)
+		if err := migrateFlatKeys(run); err != nil {
+			t.Fatal(err)
+		}
+		if after, _ := json.Marshal(run.config); string(after) != string(before) {
+			t.Errorf("migrateFlatKeys(%s) = %s; want unchanged", src, after)
+		}
+	}
+}
+
+func TestMigrateDefaultJSON(t *testing.T) {
+	tests := []struct {
+		name, config, legacy, want string
+	}{
+		{"bare string", `{}`, `"openai/gpt-4o"`, "openai/gpt-4o"},
+		{"object", `{}`, `{"default_model": "openai/o3"}`, "openai/o3"},
+		{"model key", `{}`, `{"model": "openai/o3"}`, "openai/o3"},
+		{"config wins", `{"default_model": "openai/gpt-4.1"}`, `"openai/gpt-4o"`, "openai/gpt-4.1"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			run := newRun(t, tc.config)
+			legacy := filepath.Join(run.dir, legacyDefaultFile)
+			writeFile(t, legacy, tc.legacy)
+			if err := migrateDefaultJSON(run); err != nil {
+				t.Fatal(err)
+			}
+			if got := run.config["default_model"]; got != tc.want {
+				t.Errorf("default_model = %v; want %s", got, tc.want)
+			}
+			if len(run.retire) != 1 || run.retire[0] != legacy {
+				t.Errorf("retire = %v; want [%s]", run.retire, legacy)
+			}
+		})
+	}
+
+	run := newRun(t, `{}`)
+	if err := migrateDefaultJSON(run); err != nil || len(run.config) != 0 || run.retire != nil {
+		t.Errorf("without default.json: err=%v config=%v retire=%v; want no-op", err, run.config, run.retire)
+	}
+}
+
+func TestLoadConfig_MigratesLegacyLayout(t *testing.T) {
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	dir := filepath.Join(xdg, "q")
+	legacyConfig := `{"openai": "sk-legacy"}`
+	writeFile(t, filepath.Join(dir, configFileName), legacyConfig)
+	writeFile(t, filepath.Join(dir, legacyDefaultFile), `"openai/gpt-4o"`)
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if cfg.APIKeys["openai"] != "sk-legacy" || cfg.DefaultModel != "openai/gpt-4o" {
+		t.Errorf("cfg = %+v; want legacy key and default model", cfg)
+	}
+	if cfg.SchemaVersion != SchemaVersion {
+		t.Errorf("SchemaVersion = %d; want %d", cfg.SchemaVersion, SchemaVersion)
+	}
+
+	backup, err := os.ReadFile(filepath.Join(dir, configFileName+".v0.bak"))
+	if err != nil || string(backup) != legacyConfig {
+		t.Errorf("backup = %q, %v; want the original file", backup, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, legacyDefaultFile)); !os.IsNotExist(err) {
+		t.Errorf("default.json still present: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, legacyDefaultFile+".bak")); err != nil {
+		t.Errorf("default.json.bak missing: %v", err)
+	}
+
+	// Loading again finds the current schema and writes nothing.
+	path := filepath.Join(dir, configFileName)
+	before, _ := os.Stat(path)
+	if _, err := LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.Stat(path); !after.ModTime().Equal(before.ModTime()) {
+		t.Error("config rewritten on second load")
+	}
+}
+
+func TestLoadConfig_OnlyLegacyDefault(t *testing.T) {
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	writeFile(t, filepath.Join(xdg, "q", legacyDefaultFile), `{"default_model": "openai/o3"}`)
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if cfg.DefaultModel != "openai/o3" {
+		t.Errorf("DefaultModel = %q; want openai/o3", cfg.DefaultModel)
+	}
+}
+
+func TestLoadConfig_StampsUnversioned(t *testing.T) {
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	path := filepath.Join(xdg, "q", configFileName)
+	writeFile(t, path, `{"default_model": "openai/gpt-4o", "api_keys": {"openai": "sk"}}`)
+
+	if _, err := LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `"schema_version": 3`) {
+		t.Errorf("config.json = %s; want schema_version stamped", data)
+	}
+	if _, err := os.Stat(path + ".v0.bak"); err != nil {
+		t.Errorf("backup missing: %v", err)
+	}
+}
+
+func TestLoadConfig_NewerSchema(t *testing.T) {
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	writeFile(t, filepath.Join(xdg, "q", configFileName), `{"schema_version": 999}`)
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("LoadConfig error = %v; want newer schema error", err)
+	}
+}
+
+func TestValidateFile_LegacyInMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	writeFile(t, path, `{"openai": "sk-legacy"}`)
+	if err := ValidateFile(path); err != nil {
+		t.Errorf("ValidateFile(legacy) error: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != `{"openai": "sk-legacy"}` {
+		t.Errorf("ValidateFile rewrote the file: %s", data)
+	}
+}
+
+func TestMigrateProfileKeys(t *testing.T) {
+	run := newRun(t, `{"api_keys": {"openai": "sk-1", "work/openai": "sk-2", "work/anthropic": "sk-3", "default/groq": "sk-4"},
+		"profiles": {"work": {"default_model": "openai/o3", "api_keys": {"anthropic": "sk-kept"}}}}`)
+	if err := migrateProfileKeys(run); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(run.config)
+	want := `{"api_keys":{"groq":"sk-4","openai":"sk-1"},"profiles":{"work":{"api_keys":{"anthropic":"sk-kept","openai":"sk-2"},"default_model":"openai/o3"}}}`
+	if string(got) != want {
+		t.Errorf("config = %s; want %s", got, want)
+	}
+}
+
+func TestLoadConfig_UnknownKeysKept(t *testing.T) {
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	path := filepath.Join(xdg, "q", configFileName)
+	const legacy = `{"openai": "sk-1", "theme": {}}`
+	writeFile(t, path, legacy)
+
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Errorf("LoadConfig error = %v; want the unknown key reported", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != legacy {
+		t.Errorf("config.json = %s; want it left untouched", data)
+	}
+	if _, err := os.Stat(path + ".v0.bak"); !os.IsNotExist(err) {
+		t.Errorf("backup written for a refused migration: %v", err)
+	}
+}
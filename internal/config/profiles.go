@@ -0,0 +1,218 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"q/internal/providers"
+	"q/internal/secrets"
+)
+
+// DefaultProfile names the settings stored at the top level of config.json.
+const DefaultProfile = "default"
+
+// ProfileEnv selects a profile for one invocation, like --profile.
+const ProfileEnv = "Q_PROFILE"
+
+// Profile is a named set of settings, selected with --profile, $Q_PROFILE or
+// `q profile use`.
+type Profile struct {
+	DefaultModel string            `json:"default_model,omitempty"`
+	APIKeys      map[string]string `json:"api_keys,omitempty"`
+	BaseURLs     map[string]string `json:"base_urls,omitempty"` // provider API base URL, e.g. https://api.openai.com/v1
+	Params       providers.Params  `json:"params,omitzero"`
+
+	// Fallbacks maps a provider/model to the models tried, in order, when it
+	// is rate-limited or unavailable.
+	Fallbacks map[string][]string `json:"fallbacks,omitempty"`
+}
+
+var (
+	profileMu       sync.RWMutex
+	profileOverride string
+)
+
+// UseProfile selects a profile for the rest of the process, ahead of
+// $Q_PROFILE and the stored selection. It backs the global --profile flag.
+func UseProfile(name string) {
+	profileMu.Lock()
+	defer profileMu.Unlock()
+	profileOverride = name
+}
+
+// Profile returns the named profile. DefaultProfile maps to the top-level
+// settings.
+func (c Config) Profile(name string) (Profile, bool) {
+	if name == DefaultProfile {
+		return Profile{
+			DefaultModel: c.DefaultModel,
+			APIKeys:      c.APIKeys,
+			BaseURLs:     c.BaseURLs,
+			Params:       c.Params,
+			Fallbacks:    c.Fallbacks,
+		}, true
+	}
+	p, ok := c.Profiles[name]
+	return p, ok
+}
+
+// SetProfile stores p under name, creating the profile if needed.
+func (c *Config) SetProfile(name string, p Profile) {
+	if name == DefaultProfile {
+		c.DefaultModel, c.APIKeys, c.BaseURLs, c.Params, c.Fallbacks = p.DefaultModel, p.APIKeys, p.BaseURLs, p.Params, p.Fallbacks
+		return
+	}
+	if c.Profiles == nil {
+		c.Profiles = make(map[string]Profile)
+	}
+	c.Profiles[name] = p
+}
+
+// ProfileNames returns all profile names, DefaultProfile first.
+func (c Config) ProfileNames() []string {
+	names := slices.Sorted(maps.Keys(c.Profiles))
+	return append([]string{DefaultProfile}, names...)
+}
+
+// CurrentProfile returns the name of the profile in effect: --profile, then
+// $Q_PROFILE, then the one chosen with `q profile use`, then DefaultProfile.
+func (c Config) CurrentProfile() string {
+	profileMu.RLock()
+	name := profileOverride
+	profileMu.RUnlock()
+	if name == "" {
+		name = os.Getenv(ProfileEnv)
+	}
+	if name == "" {
+		name = c.ActiveProfile
+	}
+	if name == "" {
+		name = DefaultProfile
+	}
+	return name
+}
+
+func (c Config) activeProfile() (string, Profile, error) {
+	name := c.CurrentProfile()
+	p, ok := c.Profile(name)
+	if !ok {
+		return "", Profile{}, fmt.Errorf("unknown profile: %s\n\nSee available: q profile list", name)
+	}
+	return name, p, nil
+}
+
+// ActiveProfile loads the config and returns the profile in effect.
+func ActiveProfile() (string, Profile, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return "", Profile{}, err
+	}
+	return cfg.activeProfile()
+}
+
+// GetBaseURL returns the active profile's API base URL for a provider, or
+// empty to use the provider's default.
+func GetBaseURL(provider string) (string, error) {
+	_, p, err := ActiveProfile()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(p.BaseURLs[provider], "/"), nil
+}
+
+// GetParams returns the default sampling parameters: the project config's
+// merged over the active profile's.
+func GetParams() (providers.Params, error) {
+	r, err := Resolve()
+	return r.Params, err
+}
+
+func validateProfileName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/. \t") {
+		return fmt.Errorf("invalid profile name %q", name)
+	}
+	return nil
+}
+
+// CreateProfile adds a new profile.
+func CreateProfile(name string, p Profile) error {
+	if err := validateProfileName(name); err != nil {
+		return err
+	}
+	return Update(func(cfg *Config) error {
+		if _, exists := cfg.Profile(name); exists {
+			return fmt.Errorf("profile %s already exists", name)
+		}
+		cfg.SetProfile(name, p)
+		return nil
+	})
+}
+
+// SelectProfile persists name as the active profile.
+func SelectProfile(name string) error {
+	return Update(func(cfg *Config) error {
+		if _, ok := cfg.Profile(name); !ok {
+			return fmt.Errorf("unknown profile: %s\n\nSee available: q profile list", name)
+		}
+		cfg.ActiveProfile = name
+		if name == DefaultProfile {
+			cfg.ActiveProfile = ""
+		}
+		return nil
+	})
+}
+
+// DeleteProfile removes a profile along with its stored API keys for the
+// given providers.
+func DeleteProfile(name string, providerNames []string) error {
+	if name == DefaultProfile {
+		return errors.New("the default profile cannot be deleted")
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Profiles[name]; !ok {
+		return fmt.Errorf("unknown profile: %s\n\nSee available: q profile list", name)
+	}
+
+	if b, err := SecretBackend(cfg); err == nil && b.Name() != secrets.Plaintext {
+		for _, provider := range providerNames {
+			_ = b.Delete(secretName(name, provider))
+		}
+	}
+
+	return Update(func(cfg *Config) error {
+		delete(cfg.Profiles, name)
+		if cfg.ActiveProfile == name {
+			cfg.ActiveProfile = ""
+		}
+		return nil
+	})
+}
+
+// secretName is the name a provider's key is stored under in a secret
+// backend: the provider itself for the default profile, profile/provider
+// otherwise.
+func secretName(profile, provider string) string {
+	if profile == DefaultProfile {
+		return provider
+	}
+	return profile + "/" + provider
+}
+
+// secretNames returns the secret names of every provider in every profile.
+func (c Config) secretNames(providerNames []string) []string {
+	var out []string
+	for _, profile := range c.ProfileNames() {
+		for _, provider := range providerNames {
+			out = append(out, secretName(profile, provider))
+		}
+	}
+	return out
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	"q/internal/secrets"
+)
+
+func TestProfiles_Isolation(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	t.Setenv(ProfileEnv, "")
+	t.Setenv(DefaultModelEnv, "")
+	t.Setenv("Q_OPENAI_API_KEY", "")
+	t.Setenv("OPENAI_API_KEY", "")
+
+	if err := SetAPIKey("openai", "personal-key"); err != nil {
+		t.Fatalf("SetAPIKey: %v", err)
+	}
+	if err := SetDefaultModel("openai/gpt-4o"); err != nil {
+		t.Fatalf("SetDefaultModel: %v", err)
+	}
+	if err := CreateProfile("work", Profile{DefaultModel: "openai/gpt-4.1"}); err != nil {
+		t.Fatalf("CreateProfile: %v", err)
+	}
+	if err := CreateProfile("work", Profile{}); err == nil {
+		t.Error("expected error creating a duplicate profile")
+	}
+
+	UseProfile("work")
+	t.Cleanup(func() { UseProfile("") })
+
+	if key, _ := GetAPIKey("openai"); key != "" {
+		t.Errorf("work profile sees key %q from default profile", key)
+	}
+	if err := SetAPIKey("openai", "work-key"); err != nil {
+		t.Fatalf("SetAPIKey: %v", err)
+	}
+	if key, _ := GetAPIKey("openai"); key != "work-key" {
+		t.Errorf("GetAPIKey in work = %q; want work-key", key)
+	}
+	if model, _ := GetDefaultModel(); model != "openai/gpt-4.1" {
+		t.Errorf("GetDefaultModel in work = %q; want openai/gpt-4.1", model)
+	}
+
+	UseProfile("")
+	if key, _ := GetAPIKey("openai"); key != "personal-key" {
+		t.Errorf("GetAPIKey in default = %q; want personal-key", key)
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.APIKeys["openai"] != "personal-key" || cfg.Profiles["work"].APIKeys["openai"] != "work-key" {
+		t.Errorf("unexpected stored keys: top=%v work=%v", cfg.APIKeys, cfg.Profiles["work"].APIKeys)
+	}
+}
+
+func TestProfiles_Selection(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	t.Setenv(ProfileEnv, "")
+
+	if err := SelectProfile("missing"); err == nil {
+		t.Error("expected error selecting an unknown profile")
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := CreateProfile(name, Profile{}); err != nil {
+			t.Fatalf("CreateProfile(%s): %v", name, err)
+		}
+	}
+	if err := SelectProfile("a"); err != nil {
+		t.Fatalf("SelectProfile: %v", err)
+	}
+
+	cfg, _ := LoadConfig()
+	if got := cfg.CurrentProfile(); got != "a" {
+		t.Errorf("CurrentProfile = %q; want a", got)
+	}
+	t.Setenv(ProfileEnv, "b")
+	if got := cfg.CurrentProfile(); got != "b" {
+		t.Errorf("CurrentProfile with %s = %q; want b", ProfileEnv, got)
+	}
+	UseProfile(DefaultProfile)
+	if got := cfg.CurrentProfile(); got != DefaultProfile {
+		t.Errorf("CurrentProfile with override = %q; want default", got)
+	}
+	UseProfile("")
+
+	if err := DeleteProfile(DefaultProfile, nil); err == nil {
+		t.Error("expected error deleting the default profile")
+	}
+	if err := DeleteProfile("a", nil); err != nil {
+		t.Fatalf("DeleteProfile: %v", err)
+	}
+	cfg, _ = LoadConfig()
+	if cfg.ActiveProfile != "" {
+		t.Errorf("ActiveProfile after deleting it = %q; want empty", cfg.ActiveProfile)
+	}
+	if want := []string{DefaultProfile, "b"}; len(cfg.ProfileNames()) != 2 || cfg.ProfileNames()[1] != "b" {
+		t.Errorf("ProfileNames = %v; want %v", cfg.ProfileNames(), want)
+	}
+}
+
+func TestProfiles_MigrateAllProfiles(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	t.Setenv(ProfileEnv, "")
+	t.Setenv(PassphraseEnv, "pw")
+
+	if err := SetAPIKey("openai", "k-default"); err != nil {
+		t.Fatalf("SetAPIKey: %v", err)
+	}
+	if err := CreateProfile("work", Profile{APIKeys: map[string]string{"openai": "k-work"}}); err != nil {
+		t.Fatalf("CreateProfile: %v", err)
+	}
+	moved, err := MigrateKeys([]string{"openai"}, SecretsConfig{Backend: secrets.Encrypted})
+	if err != nil {
+		t.Fatalf("MigrateKeys: %v", err)
+	}
+	if len(moved) != 2 {
+		t.Errorf("moved = %v; want openai and work/openai", moved)
+	}
+	cfg, _ := LoadConfig()
+	if len(cfg.APIKeys) != 0 || len(cfg.Profiles["work"].APIKeys) != 0 {
+		t.Errorf("plaintext keys left behind: %v %v", cfg.APIKeys, cfg.Profiles["work"].APIKeys)
+	}
+}
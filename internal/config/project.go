@@ -0,0 +1,408 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"q/internal/providers"
+)
+
+// ProjectFiles are the names of project config files, in order of preference
+// when a directory has more than one.
+var ProjectFiles = []string{".q.json", ".q.toml"}
+
+// defaultContextBytes caps the total size of project context files unless the
+// project sets context.max_bytes.
+const defaultContextBytes = 64 << 10
+
+// Project is a repository's checked-in config, found by walking up from the
+// working directory. It is merged over the active profile. It never holds
+// API keys or base URLs: a cloned repository must not be able to redirect
+// or read your credentials.
+type Project struct {
+	Path string `json:"-"` // the file it was loaded from
+
+	DefaultModel string           `json:"default_model,omitempty"`
+	System       string           `json:"system,omitempty"`
+	Params       providers.Params `json:"params,omitzero"`
+
+	// Templates is a directory of prompt templates, relative to the project
+	// file, searched before the user's own templates.
+	Templates string `json:"templates,omitempty"`
+
+	// Aliases add to, and override, the user's model aliases.
+	Aliases map[string]string `json:"aliases,omitempty"`
+
+	// Fallbacks add to, and override, the user's fallback chains.
+	Fallbacks map[string][]string `json:"fallbacks,omitempty"`
+
+	Context ContextRules `json:"context,omitzero"`
+}
+
+// ContextRules select project files sent along with every prompt, appended
+// to the system prompt.
+type ContextRules struct {
+	// Files are glob patterns relative to the project file, e.g. "docs/*.md".
+	Files []string `json:"files,omitempty"`
+	// Exclude drops matches of Files; patterns match the relative path or
+	// the base name.
+	Exclude []string `json:"exclude,omitempty"`
+	// MaxBytes caps the total size of the selected files (default 64 KiB).
+	MaxBytes int `json:"max_bytes,omitempty"`
+}
+
+// forbiddenProjectKeys are user config sections a project file may not set.
+var forbiddenProjectKeys = []string{"api_keys", "base_urls", "secrets", "profiles", "active_profile"}
+
+// Dir returns the directory holding the project file.
+func (p *Project) Dir() string { return filepath.Dir(p.Path) }
+
+// FindProject returns the path of the nearest project file in dir or one of
+// its parents, or empty if there is none.
+func FindProject(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, name := range ProjectFiles {
+			path := filepath.Join(dir, name)
+			if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+				return path, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// LoadProject loads the project file governing the working directory. It
+// returns nil if there is none.
+func LoadProject() (*Project, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	path, err := FindProject(wd)
+	if err != nil || path == "" {
+		return nil, err
+	}
+	return ReadProject(path)
+}
+
+// ReadProject parses a .q.json or .q.toml file.
+func ReadProject(path string) (*Project, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	if filepath.Ext(path) == ".toml" {
+		raw, err = parseTOML(string(data))
+	} else {
+		err = json.Unmarshal(data, &raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, key := range forbiddenProjectKeys {
+		if _, ok := raw[key]; ok {
+			return nil, fmt.Errorf("%s: %s is not allowed in a project config\n\nStore keys with: q keys set", path, key)
+		}
+	}
+
+	// Both formats decode through JSON so they share one schema.
+	norm, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(norm))
+	dec.DisallowUnknownFields()
+	p := &Project{Path: path}
+	if err := dec.Decode(p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// ContextFiles returns the files selected by the project's context rules,
+// sorted and deduplicated.
+func (p *Project) ContextFiles() ([]string, error) {
+	var out []string
+	for _, pattern := range p.Context.Files {
+		if filepath.IsAbs(pattern) || slices.Contains(strings.Split(filepath.ToSlash(pattern), "/"), "..") {
+			return nil, fmt.Errorf("%s: context file %q must stay inside the project", p.Path, pattern)
+		}
+		matches, err := filepath.Glob(filepath.Join(p.Dir(), pattern))
+		if err != nil {
+			return nil, fmt.Errorf("%s: context file %q: %w", p.Path, pattern, err)
+		}
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err != nil || fi.IsDir() || p.excluded(m) {
+				continue
+			}
+			if ok, err := p.contains(m); err != nil || !ok {
+				return nil, fmt.Errorf("%s: context file %s links outside the project", p.Path, m)
+			}
+			out = append(out, m)
+		}
+	}
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// TemplateDir returns the project's template directory, or empty if it
+// sets none.
+func (p *Project) TemplateDir() (string, error) {
+	if p.Templates == "" {
+		return "", nil
+	}
+	dir := filepath.Join(p.Dir(), p.Templates)
+	if filepath.IsAbs(p.Templates) {
+		return "", fmt.Errorf("%s: templates %q must stay inside the project", p.Path, p.Templates)
+	}
+	if ok, err := p.contains(dir); err != nil || !ok {
+		return "", fmt.Errorf("%s: templates %q must stay inside the project", p.Path, p.Templates)
+	}
+	return dir, nil
+}
+
+// contains reports whether path, with symlinks followed, is inside the
+// project directory, so a cloned repository cannot point q at files
+// elsewhere. A path that does not exist is judged as written.
+func (p *Project) contains(path string) (bool, error) {
+	root, real := p.Dir(), filepath.Clean(path)
+	if r, err := filepath.EvalSymlinks(path); err == nil {
+		if root, err = filepath.EvalSymlinks(root); err != nil {
+			return false, err
+		}
+		real = r
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	rel, err := filepath.Rel(root, real)
+	if err != nil {
+		return false, nil
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
+
+func (p *Project) excluded(path string) bool {
+	rel, err := filepath.Rel(p.Dir(), path)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range p.Context.Exclude {
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolved is the effective configuration: the active profile with the
+// project config and environment merged over it.
+type Resolved struct {
+	Profile      string
+	DefaultModel string
+	System       string
+	Params       providers.Params
+	Aliases      map[string]string
+	Fallbacks    map[string][]string
+	Models       map[string]providers.ModelInfo // catalog overrides
+
+	// TemplateDirs are searched in order; the project's comes first.
+	TemplateDirs []string
+	ContextFiles []string
+	Project      *Project
+
+	// Sources maps each set key, e.g. "default_model" or
+	// "params.temperature", to where its value came from.
+	Sources map[string]string
+}
+
+// Resolve loads the user and project configs and merges them. Precedence,
+// highest first: environment and flags, the project file, the active
+// profile.
+func Resolve() (Resolved, error) {
+	return resolveWith(LoadProject)
+}
+
+// ResolveGlobal is Resolve without the project config, for commands whose
+// requests do not come from the working directory, such as q serve.
+func ResolveGlobal() (Resolved, error) {
+	return resolveWith(func() (*Project, error) { return nil, nil })
+}
+
+func resolveWith(loadProject func() (*Project, error)) (Resolved, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return Resolved{}, err
+	}
+	name, prof, err := cfg.activeProfile()
+	if err != nil {
+		return Resolved{}, err
+	}
+	proj, err := loadProject()
+	if err != nil {
+		return Resolved{}, err
+	}
+	return resolve(cfg, name, prof, proj)
+}
+
+func resolve(cfg Config, name string, prof Profile, proj *Project) (Resolved, error) {
+	r := Resolved{Profile: name, Project: proj, Sources: make(map[string]string)}
+
+	profileSrc := configFileName
+	if name != DefaultProfile {
+		profileSrc = fmt.Sprintf("%s (profile %s)", configFileName, name)
+	}
+	r.Sources["profile"] = profileSource(cfg)
+
+	set := func(key string, isSet bool, src string) {
+		if isSet {
+			r.Sources[key] = src
+		}
+	}
+
+	r.DefaultModel, r.Params = prof.DefaultModel, prof.Params
+	r.Models = cfg.Models
+	r.Aliases = make(map[string]string)
+	for name, target := range cfg.Aliases {
+		r.Aliases[name] = target
+		set("aliases."+name, true, configFileName)
+	}
+	r.Fallbacks = make(map[string][]string)
+	for model, chain := range prof.Fallbacks {
+		r.Fallbacks[model] = chain
+		set("fallbacks."+model, true, profileSrc)
+	}
+	set("default_model", r.DefaultModel != "", profileSrc)
+	set("params.temperature", r.Params.Temperature != nil, profileSrc)
+	set("params.top_p", r.Params.TopP != nil, profileSrc)
+	set("params.max_tokens", r.Params.MaxTokens != 0, profileSrc)
+
+	if proj != nil {
+		if proj.DefaultModel != "" {
+			r.DefaultModel = proj.DefaultModel
+		}
+		set("default_model", proj.DefaultModel != "", proj.Path)
+		for name, target := range proj.Aliases {
+			if err := validateAlias(name, target); err != nil {
+				return Resolved{}, fmt.Errorf("%s: aliases.%s: %w", proj.Path, name, err)
+			}
+			r.Aliases[name] = target
+			set("aliases."+name, true, proj.Path)
+		}
+		for model, chain := range proj.Fallbacks {
+			if err := validateFallbacks(model, chain); err != nil {
+				return Resolved{}, fmt.Errorf("%s: fallbacks.%s: %w", proj.Path, model, err)
+			}
+			r.Fallbacks[model] = chain
+			set("fallbacks."+model, true, proj.Path)
+		}
+		r.System = proj.System
+		set("system", proj.System != "", proj.Path)
+		r.Params = proj.Params.WithDefaults(r.Params)
+		set("params.temperature", proj.Params.Temperature != nil, proj.Path)
+		set("params.top_p", proj.Params.TopP != nil, proj.Path)
+		set("params.max_tokens", proj.Params.MaxTokens != 0, proj.Path)
+
+		dir, err := proj.TemplateDir()
+		if err != nil {
+			return Resolved{}, err
+		}
+		if dir != "" {
+			r.TemplateDirs = append(r.TemplateDirs, dir)
+			set("templates", true, proj.Path)
+		}
+		files, err := proj.ContextFiles()
+		if err != nil {
+			return Resolved{}, err
+		}
+		r.ContextFiles = files
+		set("context", len(files) > 0, proj.Path)
+	}
+
+	if model := os.Getenv(DefaultModelEnv); model != "" {
+		r.DefaultModel = model
+		set("default_model", true, "$"+DefaultModelEnv)
+	}
+
+	dir, err := configDir()
+	if err != nil {
+		return Resolved{}, err
+	}
+	r.TemplateDirs = append(r.TemplateDirs, filepath.Join(dir, "templates"))
+	return r, nil
+}
+
+// profileSource reports why the current profile is in effect.
+func profileSource(cfg Config) string {
+	profileMu.RLock()
+	override := profileOverride
+	profileMu.RUnlock()
+	switch {
+	case override != "":
+		return "--profile"
+	case os.Getenv(ProfileEnv) != "":
+		return "$" + ProfileEnv
+	case cfg.ActiveProfile != "":
+		return configFileName
+	}
+	return "built-in default"
+}
+
+// SystemPrompt returns system, or the project's system prompt if it is
+// empty, followed by the contents of the project context files.
+func (r Resolved) SystemPrompt(system string) (string, error) {
+	if system == "" {
+		system = r.System
+	}
+	if len(r.ContextFiles) == 0 {
+		return system, nil
+	}
+
+	limit := defaultContextBytes
+	if r.Project != nil && r.Project.Context.MaxBytes > 0 {
+		limit = r.Project.Context.MaxBytes
+	}
+	var b strings.Builder
+	if system != "" {
+		b.WriteString(system)
+		b.WriteString("\n\n")
+	}
+	b.WriteString("Project files:\n")
+	total := 0
+	for _, path := range r.ContextFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		if total += len(data); total > limit {
+			return "", fmt.Errorf("project context files exceed %d bytes\n\nNarrow context.files or raise context.max_bytes in %s", limit, r.Project.Path)
+		}
+		name := path
+		if r.Project != nil {
+			if rel, err := filepath.Rel(r.Project.Dir(), path); err == nil {
+				name = filepath.ToSlash(rel)
+			}
+		}
+		fmt.Fprintf(&b, "\n--- %s ---\n%s\n", name, bytes.TrimRight(data, "\n"))
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,303 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"q/internal/providers"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindProject_WalksUp(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ".q.toml"), "")
+	deep := filepath.Join(root, "a", "b")
+	if err := os.MkdirAll(deep, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	got, err := FindProject(deep)
+	if err != nil {
+		t.Fatalf("FindProject error: %v", err)
+	}
+	if want := filepath.Join(root, ".q.toml"); got != want {
+		t.Errorf("FindProject = %q; want %q", got, want)
+	}
+
+	// The nearest file wins, and .q.json beats .q.toml in one directory.
+	writeFile(t, filepath.Join(root, "a", ".q.json"), "{}")
+	writeFile(t, filepath.Join(root, "a", ".q.toml"), "")
+	if got, _ = FindProject(deep); got != filepath.Join(root, "a", ".q.json") {
+		t.Errorf("FindProject = %q; want the nearer .q.json", got)
+	}
+}
+
+func TestReadProject_JSONAndTOMLAgree(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, ".q.json")
+	tomlPath := filepath.Join(dir, ".q.toml")
+	writeFile(t, jsonPath, `{"default_model": "openai/gpt-4o", "system": "Be terse.",
+		"params": {"temperature": 0.2, "max_tokens": 100},
+		"context": {"files": ["*.md"]}}`)
+	writeFile(t, tomlPath, "default_model = \"openai/gpt-4o\"\nsystem = \"Be terse.\"\n"+
+		"[params]\ntemperature = 0.2\nmax_tokens = 100\n[context]\nfiles = [\"*.md\"]\n")
+
+	for _, path := range []string{jsonPath, tomlPath} {
+		p, err := ReadProject(path)
+		if err != nil {
+			t.Fatalf("ReadProject(%s) error: %v", path, err)
+		}
+		if p.DefaultModel != "openai/gpt-4o" || p.System != "Be terse." {
+			t.Errorf("%s: got %+v", path, p)
+		}
+		if p.Params.Temperature == nil || *p.Params.Temperature != 0.2 || p.Params.MaxTokens != 100 {
+			t.Errorf("%s: Params = %+v", path, p.Params)
+		}
+		if len(p.Context.Files) != 1 || p.Context.Files[0] != "*.md" {
+			t.Errorf("%s: Context = %+v", path, p.Context)
+		}
+	}
+}
+
+func TestReadProject_RejectsCredentials(t *testing.T) {
+	dir := t.TempDir()
+	for _, src := range []string{
+		`{"api_keys": {"openai": "sk-x"}}`,
+		`{"base_urls": {"openai": "https://evil.example"}}`,
+		`{"secrets": {"backend": "command"}}`,
+	} {
+		path := filepath.Join(dir, ".q.json")
+		writeFile(t, path, src)
+		_, err := ReadProject(path)
+		if err == nil || !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("ReadProject(%s) error = %v; want not allowed", src, err)
+		}
+	}
+
+	path := filepath.Join(dir, ".q.toml")
+	writeFile(t, path, "defualt_model = \"x\"\n")
+	if _, err := ReadProject(path); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
+
+func TestResolve_ProjectOverridesProfile(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv(DefaultModelEnv, "")
+	t.Setenv(ProfileEnv, "")
+	temp, topP := 0.9, 0.5
+	if err := SaveConfig(Config{
+		DefaultModel: "openai/gpt-4o-mini",
+		APIKeys:      map[string]string{"openai": "sk-user"},
+		Params:       providers.Params{Temperature: &temp, TopP: &topP},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ".q.toml"),
+		"default_model = \"openai/gpt-4o\"\nsystem = \"Be terse.\"\ntemplates = \"prompts\"\n"+
+			"[params]\ntemperature = 0.2\n"+
+			"[context]\nfiles = [\"docs/*.md\"]\nexclude = [\"draft*\"]\n")
+	writeFile(t, filepath.Join(root, "docs", "style.md"), "Use tabs.\n")
+	writeFile(t, filepath.Join(root, "docs", "draft.md"), "unfinished\n")
+	t.Chdir(filepath.Join(root, "docs"))
+
+	r, err := Resolve()
+	if err != nil {
+		t.Fatalf("Resolve error: %v", err)
+	}
+	if r.DefaultModel != "openai/gpt-4o" {
+		t.Errorf("DefaultModel = %q; want project value", r.DefaultModel)
+	}
+	if *r.Params.Temperature != 0.2 || *r.Params.TopP != 0.5 {
+		t.Errorf("Params = %v/%v; want project temperature over profile top_p", *r.Params.Temperature, *r.Params.TopP)
+	}
+	projPath, _ := filepath.EvalSymlinks(filepath.Join(root, ".q.toml"))
+	if src, _ := filepath.EvalSymlinks(r.Sources["default_model"]); src != projPath {
+		t.Errorf("Sources[default_model] = %q; want %q", r.Sources["default_model"], projPath)
+	}
+	if src := r.Sources["params.top_p"]; src != configFileName {
+		t.Errorf("Sources[params.top_p] = %q; want %q", src, configFileName)
+	}
+	if len(r.TemplateDirs) != 2 || filepath.Base(r.TemplateDirs[0]) != "prompts" {
+		t.Errorf("TemplateDirs = %v; want project dir first", r.TemplateDirs)
+	}
+	if len(r.ContextFiles) != 1 || filepath.Base(r.ContextFiles[0]) != "style.md" {
+		t.Errorf("ContextFiles = %v; want only style.md", r.ContextFiles)
+	}
+
+	system, err := r.SystemPrompt("")
+	if err != nil {
+		t.Fatalf("SystemPrompt error: %v", err)
+	}
+	if !strings.HasPrefix(system, "Be terse.") || !strings.Contains(system, "--- docs/style.md ---\nUse tabs.") {
+		t.Errorf("SystemPrompt = %q", system)
+	}
+	if system, _ = r.SystemPrompt("Template system."); !strings.HasPrefix(system, "Template system.") {
+		t.Errorf("SystemPrompt(explicit) = %q; want explicit system first", system)
+	}
+
+	// The environment still wins, and keys never come from the project.
+	t.Setenv(DefaultModelEnv, "openai/o3")
+	if model, _ := GetDefaultModel(); model != "openai/o3" {
+		t.Errorf("GetDefaultModel = %q; want $%s", model, DefaultModelEnv)
+	}
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("Q_OPENAI_API_KEY", "")
+	if key, _ := GetAPIKey("openai"); key != "sk-user" {
+		t.Errorf("GetAPIKey = %q; want the user config key", key)
+	}
+}
+
+func TestResolveGlobal_IgnoresProject(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv(DefaultModelEnv, "")
+	t.Setenv(ProfileEnv, "")
+	if err := SaveConfig(Config{DefaultModel: "openai/gpt-4o-mini"}); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ".q.json"), `{"default_model": "openai/gpt-4o", "system": "Be terse."}`)
+	t.Chdir(root)
+
+	r, err := ResolveGlobal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Project != nil || r.DefaultModel != "openai/gpt-4o-mini" || r.System != "" {
+		t.Errorf("ResolveGlobal = project %v, default %q, system %q; want the user config only", r.Project, r.DefaultModel, r.System)
+	}
+}
+
+func TestProject_StaysInside(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(outside, "id_rsa"), "secret\n")
+	writeFile(t, filepath.Join(root, "docs", "ok.md"), "fine\n")
+	if err := os.Symlink(filepath.Join(outside, "id_rsa"), filepath.Join(root, "notes")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "prompts")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("ok.md", filepath.Join(root, "docs", "link.md")); err != nil {
+		t.Fatal(err)
+	}
+	p := &Project{Path: filepath.Join(root, ".q.json")}
+
+	p.Context.Files = []string{"docs/*.md"}
+	if files, err := p.ContextFiles(); err != nil || len(files) != 2 {
+		t.Errorf("ContextFiles with a link inside = %v, %v; want both files", files, err)
+	}
+	for _, pattern := range []string{"notes", "*", "../x", "/etc/passwd"} {
+		p.Context.Files = []string{pattern}
+		if files, err := p.ContextFiles(); err == nil {
+			t.Errorf("ContextFiles(%q) = %v; want an error", pattern, files)
+		}
+	}
+
+	for templates, ok := range map[string]bool{"": true, "docs": true, "missing": true, "prompts": false, "../x": false, outside: false} {
+		p.Templates = templates
+		if _, err := p.TemplateDir(); (err == nil) != ok {
+			t.Errorf("TemplateDir(%q) error = %v; want ok %v", templates, err, ok)
+		}
+	}
+}
+
+func TestSystemPrompt_MaxBytes(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "big.txt"), strings.Repeat("x", 100))
+	p := &Project{Path: filepath.Join(root, ".q.json"), Context: ContextRules{Files: []string{"*.txt"}, MaxBytes: 10}}
+	files, err := p.ContextFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Resolved{Project: p, ContextFiles: files}
+	if _, err := r.SystemPrompt(""); err == nil || !strings.Contains(err.Error(), "exceed") {
+		t.Errorf("SystemPrompt error = %v; want size limit error", err)
+	}
+
+	p.Context.Files = []string{"../outside/*"}
+	if _, err := p.ContextFiles(); err == nil {
+		t.Error("expected error for pattern escaping the project")
+	}
+}
+
+func TestResolve_Aliases(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv(DefaultModelEnv, "")
+	if err := SaveConfig(Config{Aliases: map[string]string{
+		"fast":  "openai/gpt-4.1-nano",
+		"smart": "openai/o3",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ".q.toml"), "[aliases]\nsmart = \"openai/o3-pro\"\nreview = \"openai/gpt-4.1\"\n")
+	t.Chdir(root)
+
+	r, err := Resolve()
+	if err != nil {
+		t.Fatalf("Resolve error: %v", err)
+	}
+	want := map[string]string{"fast": "openai/gpt-4.1-nano", "smart": "openai/o3-pro", "review": "openai/gpt-4.1"}
+	if !reflect.DeepEqual(r.Aliases, want) {
+		t.Errorf("Aliases = %v; want %v", r.Aliases, want)
+	}
+	if r.Sources["aliases.fast"] != configFileName || !strings.HasSuffix(r.Sources["aliases.smart"], ".q.toml") {
+		t.Errorf("alias sources = %v", r.Sources)
+	}
+
+	writeFile(t, filepath.Join(root, ".q.toml"), "[aliases]\nbad = \"gpt-4o\"\n")
+	if _, err := Resolve(); err == nil {
+		t.Error("expected error for alias target without provider")
+	}
+}
+
+func TestResolve_Fallbacks(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv(ProfileEnv, "work")
+	if err := SaveConfig(Config{
+		Fallbacks: map[string][]string{"openai/gpt-4o": {"openai/gpt-4o-mini"}},
+		Profiles: map[string]Profile{"work": {Fallbacks: map[string][]string{
+			"openai/gpt-4.1": {"openai/gpt-4.1-mini"},
+			"openai/o3":      {"openai/o4-mini"},
+		}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ".q.toml"), "[fallbacks]\n\"openai/o3\" = [\"openai/gpt-4.1\", \"openai/gpt-4o\"]\n")
+	t.Chdir(root)
+
+	want := map[string][]string{
+		"openai/gpt-4.1": {"openai/gpt-4.1-mini"},
+		"openai/o3":      {"openai/gpt-4.1", "openai/gpt-4o"},
+		"openai/gpt-4o":  nil, // only the default profile's
+	}
+	for model, chain := range want {
+		got, err := GetFallbacks(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, chain) {
+			t.Errorf("GetFallbacks(%s) = %v; want %v", model, got, chain)
+		}
+	}
+
+	writeFile(t, filepath.Join(root, ".q.toml"), "[fallbacks]\n\"openai/o3\" = [\"gpt-4o\"]\n")
+	if _, err := Resolve(); err == nil {
+		t.Error("expected error for a fallback without provider")
+	}
+}
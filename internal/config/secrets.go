@@ -0,0 +1,190 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"q/internal/secrets"
+)
+
+// PassphraseEnv holds the passphrase for the encrypted secret backend.
+const PassphraseEnv = "Q_PASSPHRASE"
+
+// PassphrasePrompt asks for the encrypted backend's passphrase when
+// Q_PASSPHRASE is unset. The CLI points it at an interactive prompt.
+var PassphrasePrompt func() (string, error)
+
+var (
+	backendsMu sync.Mutex
+	backends   = make(map[SecretsConfig]secrets.Backend)
+)
+
+func passphrase() (string, error) {
+	if s := os.Getenv(PassphraseEnv); s != "" {
+		return s, nil
+	}
+	if PassphrasePrompt == nil {
+		return "", fmt.Errorf("API keys are encrypted; set %s to unlock them", PassphraseEnv)
+	}
+	return PassphrasePrompt()
+}
+
+// SecretBackend returns the secret backend selected in cfg.
+func SecretBackend(cfg Config) (secrets.Backend, error) {
+	return NewSecretBackend(cfg.Secrets.Backend, cfg.Secrets)
+}
+
+// NewSecretBackend returns the backend called name, configured by sc. An
+// empty name means plaintext. Backends are reused for the life of the process
+// so that a passphrase is asked for at most once.
+func NewSecretBackend(name string, sc SecretsConfig) (secrets.Backend, error) {
+	if name == "" {
+		name = secrets.Plaintext
+	}
+	sc.Backend = name
+	if name == secrets.Encrypted && sc.File == "" {
+		dir, err := configDir()
+		if err != nil {
+			return nil, err
+		}
+		sc.File = filepath.Join(dir, "keys.enc")
+	}
+
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if b, ok := backends[sc]; ok {
+		return b, nil
+	}
+
+	var b secrets.Backend
+	switch name {
+	case secrets.Plaintext:
+		b = secrets.NewPlaintext(loadPlaintextKeys, updatePlaintextKeys)
+	case secrets.Encrypted:
+		b = secrets.NewEncrypted(sc.File, passphrase)
+	case secrets.Command:
+		if sc.GetCommand == "" {
+			return nil, errors.New("the command secret backend needs secrets.get_command")
+		}
+		b = secrets.NewCommand(secrets.CommandConfig{
+			Get:    sc.GetCommand,
+			Set:    sc.SetCommand,
+			Delete: sc.DeleteCommand,
+		})
+	default:
+		return nil, fmt.Errorf("unknown secret backend %q\n\nUse one of: %v", name, secrets.Names)
+	}
+	backends[sc] = b
+	return b, nil
+}
+
+// loadPlaintextKeys flattens the api_keys of every profile into one map
+// keyed by secret name (see secretName).
+func loadPlaintextKeys() (map[string]string, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.plaintextKeys(), nil
+}
+
+func (c Config) plaintextKeys() map[string]string {
+	keys := maps.Clone(c.APIKeys)
+	if keys == nil {
+		keys = make(map[string]string)
+	}
+	for profile, p := range c.Profiles {
+		for provider, key := range p.APIKeys {
+			keys[secretName(profile, provider)] = key
+		}
+	}
+	return keys
+}
+
+// errUnchanged aborts an Update that has nothing to write.
+var errUnchanged = errors.New("unchanged")
+
+// updatePlaintextKeys applies fn to the map from loadPlaintextKeys under the
+// config lock and splits the result back into profiles.
+func updatePlaintextKeys(fn func(map[string]string) bool) error {
+	err := Update(func(cfg *Config) error {
+		keys := cfg.plaintextKeys()
+		if !fn(keys) {
+			return errUnchanged
+		}
+		cfg.APIKeys = make(map[string]string)
+		for name, p := range cfg.Profiles {
+			p.APIKeys = nil
+			cfg.Profiles[name] = p
+		}
+		for name, key := range keys {
+			profile, provider, ok := strings.Cut(name, "/")
+			if !ok {
+				cfg.APIKeys[name] = key
+				continue
+			}
+			p := cfg.Profiles[profile]
+			if p.APIKeys == nil {
+				p.APIKeys = make(map[string]string)
+			}
+			p.APIKeys[provider] = key
+			cfg.SetProfile(profile, p)
+		}
+		return nil
+	})
+	if errors.Is(err, errUnchanged) {
+		return nil
+	}
+	return err
+}
+
+// MigrateKeys copies the keys of the given providers, in every profile, from
+// the current secret backend to the one described by sc, makes it the active
+// backend, and then removes the keys from the old one where it supports
+// deletion. It returns the secret names (provider or profile/provider) that
+// were moved.
+func MigrateKeys(providers []string, sc SecretsConfig) ([]string, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	src, err := SecretBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := NewSecretBackend(sc.Backend, sc)
+	if err != nil {
+		return nil, err
+	}
+	if src.Name() == dst.Name() {
+		return nil, fmt.Errorf("keys are already stored in the %s backend", src.Name())
+	}
+
+	moved, err := secrets.Migrate(src, dst, cfg.secretNames(providers))
+	if err != nil {
+		return nil, err
+	}
+
+	err = Update(func(cfg *Config) error {
+		cfg.Secrets = sc
+		if cfg.Secrets.Backend == secrets.Plaintext {
+			cfg.Secrets.Backend = ""
+		}
+		return nil
+	})
+	if err != nil {
+		return moved, err
+	}
+
+	for _, p := range moved {
+		if err := src.Delete(p); err != nil && !errors.Is(err, secrets.ErrUnsupported) {
+			return moved, fmt.Errorf("keys moved, but removing %s from %s failed: %w", p, src.Name(), err)
+		}
+	}
+	return moved, nil
+}
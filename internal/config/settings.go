@@ -0,0 +1,446 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"q/internal/providers"
+	"q/internal/secrets"
+)
+
+// Settings are addressed with dotted keys that follow config.json, e.g.
+// default_model, params.temperature, profiles.work.base_urls.openai or
+// fallbacks.openai/gpt-4.1.
+
+// errAPIKeys steers users away from editing keys as plain settings, which
+// would bypass the secret backend.
+var errAPIKeys = errors.New("API keys are not settings\n\nManage keys with: q keys set")
+
+// errUnknownSetting is returned by walkKey for a path that does not exist.
+var errUnknownSetting = errors.New("unknown setting")
+
+// keyPath splits a dotted key into its path through config.json and returns
+// the Go type stored there. Struct fields are matched by JSON name. Map keys
+// may themselves contain dots, since model names such as gpt-4.1 do: each
+// takes the fewest parts of the dotted key that leave a valid path.
+func keyPath(key string) ([]string, reflect.Type, error) {
+	if key == "" {
+		return nil, nil, errors.New("empty key")
+	}
+	path, t, err := walkKey(reflect.TypeFor[Config](), strings.Split(key, "."))
+	if err == errUnknownSetting {
+		return nil, nil, fmt.Errorf("unknown setting %q", key)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return path, t, nil
+}
+
+func walkKey(t reflect.Type, rest []string) ([]string, reflect.Type, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if len(rest) == 0 {
+		return nil, t, nil
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		part := rest[0]
+		f, ok := fieldByJSONName(t, part)
+		if !ok {
+			return nil, nil, errUnknownSetting
+		}
+		switch part {
+		case "api_keys":
+			return nil, nil, errAPIKeys
+		case "schema_version":
+			return nil, nil, errors.New("schema_version is managed by q")
+		}
+		path, t, err := walkKey(f.Type, rest[1:])
+		return append([]string{part}, path...), t, err
+	case reflect.Map:
+		for n := 1; n <= len(rest); n++ {
+			part := strings.Join(rest[:n], ".")
+			if part == "" {
+				return nil, nil, errUnknownSetting
+			}
+			path, t, err := walkKey(t.Elem(), rest[n:])
+			if err == errUnknownSetting {
+				continue
+			}
+			return append([]string{part}, path...), t, err
+		}
+	}
+	return nil, nil, errUnknownSetting
+}
+
+func fieldByJSONName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == name && tag != "-" && !strings.HasPrefix(tag, "//") {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// parseSetting converts a command-line value to the type stored under key.
+// Lists are comma separated or a JSON array.
+func parseSetting(key, value string) (any, error) {
+	_, t, err := keyPath(key)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	switch t.Kind() {
+	case reflect.String:
+		v = value
+	case reflect.Float64:
+		v, err = strconv.ParseFloat(value, 64)
+	case reflect.Int:
+		v, err = strconv.Atoi(value)
+	case reflect.Bool:
+		v, err = strconv.ParseBool(value)
+	case reflect.Slice:
+		var list []string
+		if strings.HasPrefix(strings.TrimSpace(value), "[") {
+			err = json.Unmarshal([]byte(value), &list)
+		} else {
+			for _, s := range strings.Split(value, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					list = append(list, s)
+				}
+			}
+		}
+		v = list
+	default:
+		return nil, fmt.Errorf("%s is a section; set one of its keys", key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	return v, nil
+}
+
+// tree returns c as generic JSON values.
+func (c Config) tree() (map[string]any, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	return m, json.Unmarshal(data, &m)
+}
+
+// fromTree decodes generic JSON values into a Config, rejecting unknown keys
+// and values of the wrong type.
+func fromTree(m map[string]any) (Config, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return Config{}, err
+	}
+	return decodeStrict(data)
+}
+
+func decodeStrict(data []byte) (Config, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil {
+		return Config{}, err
+	}
+	if cfg.APIKeys == nil {
+		cfg.APIKeys = make(map[string]string)
+	}
+	return cfg, nil
+}
+
+// Get returns the value stored under a dotted key, and whether it is set.
+func (c Config) Get(key string) (any, bool, error) {
+	path, _, err := keyPath(key)
+	if err != nil {
+		return nil, false, err
+	}
+	m, err := c.tree()
+	if err != nil {
+		return nil, false, err
+	}
+	var v any = m
+	for _, part := range path {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false, nil
+		}
+		if v, ok = obj[part]; !ok {
+			return nil, false, nil
+		}
+	}
+	return v, true, nil
+}
+
+// Set stores value under a dotted key, converting it to the key's type, and
+// validates the result.
+func (c *Config) Set(key, value string) error {
+	v, err := parseSetting(key, value)
+	if err != nil {
+		return err
+	}
+	return c.edit(key, func(parent map[string]any, last string) error {
+		parent[last] = v
+		return nil
+	})
+}
+
+// Unset removes the value under a dotted key.
+func (c *Config) Unset(key string) error {
+	return c.edit(key, func(parent map[string]any, last string) error {
+		if _, ok := parent[last]; !ok {
+			return fmt.Errorf("%s is not set", key)
+		}
+		delete(parent, last)
+		return nil
+	})
+}
+
+// edit applies fn to the map holding the last element of key, creating
+// intermediate sections as needed, then decodes and validates the result.
+func (c *Config) edit(key string, fn func(parent map[string]any, last string) error) error {
+	parts, _, err := keyPath(key)
+	if err != nil {
+		return err
+	}
+	m, err := c.tree()
+	if err != nil {
+		return err
+	}
+	parent := m
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := parent[part].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			parent[part] = next
+		}
+		parent = next
+	}
+	if err := fn(parent, parts[len(parts)-1]); err != nil {
+		return err
+	}
+	cfg, err := fromTree(m)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	*c = cfg
+	return nil
+}
+
+// validateFallbacks checks that model and its fallbacks are provider/model
+// pairs.
+func validateFallbacks(model string, fallbacks []string) error {
+	for _, m := range append([]string{model}, fallbacks...) {
+		if !strings.Contains(m, "/") {
+			return fmt.Errorf("%q: want provider/model", m)
+		}
+	}
+	return nil
+}
+
+// Validate reports settings that are well-formed JSON but cannot work.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(where string, p Profile) {
+		if p.DefaultModel != "" && !strings.Contains(p.DefaultModel, "/") {
+			errs = append(errs, fmt.Errorf("%sdefault_model %q: want provider/model", where, p.DefaultModel))
+		}
+		if t := p.Params.Temperature; t != nil && (*t < 0 || *t > 2) {
+			errs = append(errs, fmt.Errorf("%sparams.temperature %v: want 0 to 2", where, *t))
+		}
+		if tp := p.Params.TopP; tp != nil && (*tp < 0 || *tp > 1) {
+			errs = append(errs, fmt.Errorf("%sparams.top_p %v: want 0 to 1", where, *tp))
+		}
+		if p.Params.MaxTokens < 0 {
+			errs = append(errs, fmt.Errorf("%sparams.max_tokens %d: want a positive number", where, p.Params.MaxTokens))
+		}
+		for _, model := range slices.Sorted(maps.Keys(p.Fallbacks)) {
+			if err := validateFallbacks(model, p.Fallbacks[model]); err != nil {
+				errs = append(errs, fmt.Errorf("%sfallbacks.%s: %w", where, model, err))
+			}
+		}
+		for _, provider := range slices.Sorted(maps.Keys(p.BaseURLs)) {
+			if u := p.BaseURLs[provider]; !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+				errs = append(errs, fmt.Errorf("%sbase_urls.%s %q: want an http(s) URL", where, provider, u))
+			}
+		}
+	}
+
+	for _, name := range c.ProfileNames() {
+		where := ""
+		if name != DefaultProfile {
+			if err := validateProfileName(name); err != nil {
+				errs = append(errs, err)
+			}
+			where = "profiles." + name + "."
+		}
+		p, _ := c.Profile(name)
+		check(where, p)
+	}
+	if c.ActiveProfile != "" {
+		if _, ok := c.Profile(c.ActiveProfile); !ok {
+			errs = append(errs, fmt.Errorf("active_profile %q: no such profile", c.ActiveProfile))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Aliases)) {
+		if err := validateAlias(name, c.Aliases[name]); err != nil {
+			errs = append(errs, fmt.Errorf("aliases.%s: %w", name, err))
+		}
+	}
+	for _, model := range slices.Sorted(maps.Keys(c.Models)) {
+		if err := validateModelInfo(model, c.Models[model]); err != nil {
+			errs = append(errs, fmt.Errorf("models.%s: %w", model, err))
+		}
+	}
+	if h := c.Chat.History; h != "" && !slices.Contains(HistoryStrategies, h) {
+		errs = append(errs, fmt.Errorf("chat.history %q: want one of %s", h, strings.Join(HistoryStrategies, ", ")))
+	}
+	if c.Chat.Window < 0 || c.Chat.SummarizeAt < 0 {
+		errs = append(errs, errors.New("chat.window and chat.summarize_at must be positive"))
+	}
+	if ttl := c.Cache.TTL; ttl != "" {
+		if d, err := time.ParseDuration(ttl); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("cache.ttl %q: want a duration such as 24h", ttl))
+		}
+	}
+	if c.Cache.MaxSize < 0 {
+		errs = append(errs, errors.New("cache.max_size_mb must be positive"))
+	}
+	seen := map[string]string{}
+	for _, client := range slices.Sorted(maps.Keys(c.Serve.Tokens)) {
+		token := c.Serve.Tokens[client]
+		switch {
+		case len(token) < 16:
+			errs = append(errs, fmt.Errorf("serve.tokens.%s: want at least 16 characters", client))
+		case seen[token] != "":
+			errs = append(errs, fmt.Errorf("serve.tokens.%s: same token as %s", client, seen[token]))
+		default:
+			seen[token] = client
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.MCPServers)) {
+		if err := c.MCPServers[name].validate(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if b := c.Secrets.Backend; b != "" && !slices.Contains(secrets.Names, b) {
+		errs = append(errs, fmt.Errorf("secrets.backend %q: want one of %s", b, strings.Join(secrets.Names, ", ")))
+	}
+	if c.Secrets.Backend == secrets.Command && c.Secrets.GetCommand == "" {
+		errs = append(errs, errors.New("secrets.get_command: required by the command backend"))
+	}
+	return errors.Join(errs...)
+}
+
+// reMCPName matches the MCP server names that can prefix tool names.
+var reMCPName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (s MCPServerConfig) validate(name string) error {
+	key := "mcp_servers." + name
+	switch {
+	case !reMCPName.MatchString(name):
+		return fmt.Errorf("%s: server names may only hold letters, digits, _ and -", key)
+	case s.Command == "" && s.URL == "":
+		return fmt.Errorf("%s: set command or url", key)
+	case s.Command != "" && s.URL != "":
+		return fmt.Errorf("%s: set command or url, not both", key)
+	case s.URL == "" && len(s.Headers) > 0:
+		return fmt.Errorf("%s.headers: only used with url", key)
+	case s.Command == "" && (len(s.Args) > 0 || len(s.Env) > 0):
+		return fmt.Errorf("%s: args and env are only used with command", key)
+	}
+	if s.URL != "" && !strings.HasPrefix(s.URL, "https://") && !strings.HasPrefix(s.URL, "http://") {
+		return fmt.Errorf("%s.url %q: want an http(s) URL", key, s.URL)
+	}
+	return nil
+}
+
+// validateAlias checks that name can be told apart from a provider/model and
+// that target is one.
+func validateAlias(name, target string) error {
+	switch {
+	case name == "" || strings.ContainsAny(name, "/ \t"):
+		return fmt.Errorf("invalid alias name %q", name)
+	case !strings.Contains(target, "/"):
+		return fmt.Errorf("%q: want provider/model", target)
+	}
+	return nil
+}
+
+// validateModelInfo checks a catalog override for model.
+func validateModelInfo(model string, info providers.ModelInfo) error {
+	var errs []error
+	if !strings.Contains(model, "/") {
+		errs = append(errs, errors.New("want provider/model"))
+	}
+	if info.ContextWindow < 0 || info.MaxOutput < 0 {
+		errs = append(errs, errors.New("token limits must be positive"))
+	}
+	for _, m := range slices.Concat(info.Input, info.Output) {
+		if !slices.Contains(providers.Modalities, m) {
+			errs = append(errs, fmt.Errorf("modality %q: want one of %s", m, strings.Join(providers.Modalities, ", ")))
+		}
+	}
+	if p := info.Pricing; p != nil && (p.Input < 0 || p.Output < 0) {
+		errs = append(errs, errors.New("prices must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// ParseConfig decodes config.json contents, rejecting syntax errors, unknown
+// keys, values of the wrong type and settings that cannot work.
+func ParseConfig(data []byte) (Config, error) {
+	cfg, err := decodeStrict(data)
+	if err != nil {
+		return Config{}, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// ValidateFile checks a config file with ParseConfig, after upgrading it in
+// memory if it uses an older schema.
+func ValidateFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	run := &migrationRun{dir: filepath.Dir(path)}
+	if err := json.Unmarshal(data, &run.config); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if run.config == nil {
+		run.config = make(map[string]any)
+	}
+	if _, err := upgrade(run); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if data, err = json.Marshal(run.config); err != nil {
+		return err
+	}
+	if _, err := ParseConfig(data); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSetGetUnset(t *testing.T) {
+	var cfg Config
+	steps := []struct{ key, value string }{
+		{"default_model", "openai/gpt-4o"},
+		{"params.temperature", "0.2"},
+		{"params.max_tokens", "512"},
+		{"profiles.work.base_urls.openai", "https://gw.example.com/v1"},
+		{"fallbacks.openai/gpt-4.1", "openai/gpt-4o, openai/gpt-4o-mini"},
+		{"secrets.backend", "encrypted"},
+		{"models.openai/gpt-4.1.context_window", "65536"},
+		{"models.openai/gpt-4.1.pricing.input", "1.5"},
+		{"models.openai/gpt-4.1.tools", "false"},
+		{"mcp_servers.db.command", "db-mcp"},
+		{"mcp_servers.db.args", "--read-only, --dsn=postgres://localhost/app"},
+		{"mcp_servers.tracker.url", "https://tracker.example.com/mcp"},
+		{"mcp_servers.tracker.headers.Authorization", "Bearer t0ken"},
+	}
+	for _, s := range steps {
+		if err := cfg.Set(s.key, s.value); err != nil {
+			t.Fatalf("Set(%s, %s) error: %v", s.key, s.value, err)
+		}
+	}
+
+	if *cfg.Params.Temperature != 0.2 || cfg.Params.MaxTokens != 512 {
+		t.Errorf("Params = %+v", cfg.Params)
+	}
+	if got := cfg.Profiles["work"].BaseURLs["openai"]; got != "https://gw.example.com/v1" {
+		t.Errorf("work base URL = %q", got)
+	}
+	if got, want := cfg.Fallbacks["openai/gpt-4.1"], []string{"openai/gpt-4o", "openai/gpt-4o-mini"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fallbacks = %v; want %v (model keys keep their dots)", got, want)
+	}
+
+	if m := cfg.Models["openai/gpt-4.1"]; m.ContextWindow != 65536 || m.Pricing == nil || m.Pricing.Input != 1.5 || m.Tools == nil || *m.Tools {
+		t.Errorf("models[openai/gpt-4.1] = %+v", m)
+	}
+
+	if db, tr := cfg.MCPServers["db"], cfg.MCPServers["tracker"]; len(db.Args) != 2 || db.Args[1] != "--dsn=postgres://localhost/app" || tr.Headers["Authorization"] != "Bearer t0ken" {
+		t.Errorf("mcp_servers = %+v", cfg.MCPServers)
+	}
+
+	v, ok, err := cfg.Get("params.temperature")
+	if err != nil || !ok || v != 0.2 {
+		t.Errorf("Get(params.temperature) = %v, %v, %v", v, ok, err)
+	}
+	if _, ok, _ := cfg.Get("params.top_p"); ok {
+		t.Error("Get(params.top_p) reported an unset value as set")
+	}
+
+	if err := cfg.Unset("params.temperature"); err != nil {
+		t.Fatalf("Unset error: %v", err)
+	}
+	if cfg.Params.Temperature != nil {
+		t.Error("temperature still set after Unset")
+	}
+	if err := cfg.Unset("params.temperature"); err == nil {
+		t.Error("expected error unsetting a missing key")
+	}
+}
+
+func TestSet_Rejects(t *testing.T) {
+	tests := map[string][2]string{
+		"unknown key":     {"nope", "1"},
+		"unknown nested":  {"params.nope", "1"},
+		"wrong type":      {"params.max_tokens", "many"},
+		"out of range":    {"params.temperature", "3"},
+		"section":         {"params", "1"},
+		"bad model":       {"default_model", "gpt-4o"},
+		"bad backend":     {"secrets.backend", "keychain"},
+		"bad URL":         {"base_urls.openai", "gw.example.com"},
+		"missing profile": {"active_profile", "work"},
+		"alias target":    {"aliases.fast", "gpt-4.1-nano"},
+		"alias name":      {"aliases.a b", "openai/o3"},
+		"model key":       {"models.gpt-4o.max_output", "10"},
+		"modality":        {"models.openai/gpt-4o.input", "text,video"},
+		"model field":     {"models.openai/gpt-4o.nope", "1"},
+		"chat history":    {"chat.history", "forget"},
+		"chat window":     {"chat.window", "-1"},
+		"cache ttl":       {"cache.ttl", "a week"},
+		"cache size":      {"cache.max_size_mb", "-5"},
+		"serve token":     {"serve.tokens.editor", "short"},
+		"mcp name":        {"mcp_servers.my db.command", "db-mcp"},
+		"mcp url":         {"mcp_servers.tracker.url", "tracker.local/mcp"},
+		"mcp args":        {"mcp_servers.db.args", "--read-only"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cfg Config
+			if err := cfg.Set(tc[0], tc[1]); err == nil {
+				t.Errorf("Set(%s, %s) succeeded", tc[0], tc[1])
+			}
+		})
+	}
+
+	var cfg Config
+	for _, key := range []string{"api_keys.openai", "profiles.work.api_keys.openai"} {
+		if err := cfg.Set(key, "sk-x"); !errors.Is(err, errAPIKeys) {
+			t.Errorf("Set(%s) error = %v; want errAPIKeys", key, err)
+		}
+	}
+}
+
+func TestValidateFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	for src, wantErr := range map[string]string{
+		`{"default_model": "openai/gpt-4o"}`:      "",
+		`{"defualt_model": "openai/gpt-4o"}`:      "unknown field",
+		`{"params": {"temperature": "hot"}}`:      "cannot unmarshal",
+		`{"params": {"top_p": 2}}`:                "top_p",
+		`{"profiles": {"a.b": {}}}`:               "invalid profile name",
+		`{"secrets": {"backend": "command"}}`:     "get_command",
+		`{"fallbacks": {"openai/gpt-4o": ["x"]}}`: "want provider/model",
+	} {
+		writeFile(t, path, src)
+		err := ValidateFile(path)
+		switch {
+		case wantErr == "" && err != nil:
+			t.Errorf("ValidateFile(%s) error: %v", src, err)
+		case wantErr != "" && (err == nil || !strings.Contains(err.Error(), wantErr)):
+			t.Errorf("ValidateFile(%s) error = %v; want %q", src, err, wantErr)
+		}
+	}
+}
+
+func TestUpdate_Concurrent(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Update(func(cfg *Config) error {
+				if cfg.Fallbacks == nil {
+					cfg.Fallbacks = make(map[string][]string)
+				}
+				cfg.Fallbacks["p/m"+string(rune('a'+i))] = []string{"p/x"}
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Update error: %v", err)
+		}
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Fallbacks) != n {
+		t.Errorf("got %d fallbacks; want %d (an update was lost)", len(cfg.Fallbacks), n)
+	}
+	path, _ := ConfigPath()
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+}
+
+func TestSaveConfig_Atomic(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if err := SaveConfig(Config{DefaultModel: "a/b"}); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := ConfigPath()
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("config dir holds %d entries; want only config.json", len(entries))
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0o600 {
+		t.Errorf("config.json mode = %v; want 0600", fi.Mode().Perm())
+	}
+}
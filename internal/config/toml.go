@@ -0,0 +1,330 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML decodes the subset of TOML used by project config files: tables,
+// dotted keys, strings (basic, literal and multi-line), integers, floats,
+// booleans and arrays. Inline tables and dates are not supported.
+func parseTOML(src string) (map[string]any, error) {
+	p := &tomlParser{src: src, line: 1}
+	root := make(map[string]any)
+	cur := root
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return root, nil
+		}
+		if p.peek() == '[' {
+			if strings.HasPrefix(p.src[p.pos:], "[[") {
+				return nil, p.errorf("arrays of tables are not supported")
+			}
+			p.pos++
+			keys, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if !p.consume(']') {
+				return nil, p.errorf("expected ] after table name")
+			}
+			if cur, err = p.table(root, keys); err != nil {
+				return nil, err
+			}
+		} else {
+			keys, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if !p.consume('=') {
+				return nil, p.errorf("expected = after key")
+			}
+			p.skipSpace()
+			val, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			parent, err := p.table(cur, keys[:len(keys)-1])
+			if err != nil {
+				return nil, err
+			}
+			last := keys[len(keys)-1]
+			if _, dup := parent[last]; dup {
+				return nil, p.errorf("duplicate key %q", last)
+			}
+			parent[last] = val
+		}
+		p.skipSpace()
+		if !p.eof() && p.peek() != '\n' && p.peek() != '\r' && p.peek() != '#' {
+			return nil, p.errorf("unexpected %q", p.peek())
+		}
+	}
+}
+
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("toml line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) eof() bool  { return p.pos >= len(p.src) }
+func (p *tomlParser) peek() byte { return p.src[p.pos] }
+
+func (p *tomlParser) consume(c byte) bool {
+	if !p.eof() && p.peek() == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *tomlParser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments.
+func (p *tomlParser) skipBlank() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// table walks (creating as needed) the nested tables named by keys.
+func (p *tomlParser) table(root map[string]any, keys []string) (map[string]any, error) {
+	t := root
+	for _, k := range keys {
+		switch v := t[k].(type) {
+		case nil:
+			next := make(map[string]any)
+			t[k] = next
+			t = next
+		case map[string]any:
+			t = v
+		default:
+			return nil, p.errorf("key %q is not a table", k)
+		}
+	}
+	return t, nil
+}
+
+// key parses a possibly dotted key.
+func (p *tomlParser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, p.errorf("expected key")
+		}
+		var k string
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			s, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			k = s
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("invalid key character %q", c)
+			}
+			k = p.src[start:p.pos]
+		}
+		keys = append(keys, k)
+		p.skipSpace()
+		if !p.consume('.') {
+			return keys, nil
+		}
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) value() (any, error) {
+	if p.eof() {
+		return nil, p.errorf("expected value")
+	}
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.str()
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return nil, p.errorf("inline tables are not supported")
+	case strings.HasPrefix(p.src[p.pos:], "true"):
+		p.pos += 4
+		return true, nil
+	case strings.HasPrefix(p.src[p.pos:], "false"):
+		p.pos += 5
+		return false, nil
+	}
+	return p.number()
+}
+
+func (p *tomlParser) number() (any, error) {
+	start := p.pos
+	for !p.eof() && strings.IndexByte("+-0123456789._eE", p.peek()) >= 0 {
+		p.pos++
+	}
+	lit := strings.ReplaceAll(p.src[start:p.pos], "_", "")
+	if lit == "" {
+		return nil, p.errorf("invalid value")
+	}
+	if i, err := strconv.ParseInt(lit, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(lit, 64); err == nil {
+		return f, nil
+	}
+	return nil, p.errorf("invalid number %q", lit)
+}
+
+func (p *tomlParser) array() ([]any, error) {
+	p.pos++ // [
+	out := []any{}
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.consume(']') {
+			return out, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+		p.skipBlank()
+		if p.consume(']') {
+			return out, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("expected , or ] in array")
+		}
+	}
+}
+
+// str parses any of the four TOML string forms.
+func (p *tomlParser) str() (string, error) {
+	quote := p.peek()
+	multi := strings.HasPrefix(p.src[p.pos:], strings.Repeat(string(quote), 3))
+	if multi {
+		p.pos += 3
+		// A newline right after the opening delimiter is trimmed.
+		if strings.HasPrefix(p.src[p.pos:], "\r\n") {
+			p.pos += 2
+			p.line++
+		} else if p.consume('\n') {
+			p.line++
+		}
+	} else {
+		p.pos++
+	}
+
+	var b strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated string")
+		}
+		if multi && strings.HasPrefix(p.src[p.pos:], strings.Repeat(string(quote), 3)) {
+			p.pos += 3
+			return b.String(), nil
+		}
+		c := p.peek()
+		switch {
+		case !multi && c == quote:
+			p.pos++
+			return b.String(), nil
+		case !multi && c == '\n':
+			return "", p.errorf("newline in string")
+		case c == '\\' && quote == '"':
+			if err := p.escape(&b, multi); err != nil {
+				return "", err
+			}
+		default:
+			if c == '\n' {
+				p.line++
+			}
+			r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			b.WriteRune(r)
+			p.pos += size
+		}
+	}
+}
+
+func (p *tomlParser) escape(b *strings.Builder, multi bool) error {
+	p.pos++ // backslash
+	if p.eof() {
+		return p.errorf("unterminated escape")
+	}
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'n':
+		b.WriteByte('\n')
+	case 't':
+		b.WriteByte('\t')
+	case 'r':
+		b.WriteByte('\r')
+	case '"', '\\':
+		b.WriteByte(c)
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.src) {
+			return p.errorf("short unicode escape")
+		}
+		code, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+		if err != nil {
+			return p.errorf("invalid unicode escape")
+		}
+		p.pos += n
+		b.WriteRune(rune(code))
+	case '\n', ' ', '\t', '\r':
+		if !multi {
+			return p.errorf("invalid escape")
+		}
+		// Line-ending backslash: trim the newline and leading whitespace.
+		p.pos--
+		for !p.eof() && strings.IndexByte(" \t\r\n", p.peek()) >= 0 {
+			if p.peek() == '\n' {
+				p.line++
+			}
+			p.pos++
+		}
+	default:
+		return p.errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
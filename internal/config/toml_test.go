@@ -0,0 +1,77 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	src := `# comment
+name = "a \"quoted\"\tvalue" # trailing comment
+literal = 'C:\path'
+count = 1_000
+ratio = 0.5
+neg = -3
+on = true
+list = [
+  "x",
+  'y', # comment inside array
+]
+prompt = """
+line one
+line two"""
+
+[table]
+key = "v"
+dotted.inner = 2
+
+[table.sub]
+"quoted key" = false
+`
+	got, err := parseTOML(src)
+	if err != nil {
+		t.Fatalf("parseTOML error: %v", err)
+	}
+	want := map[string]any{
+		"name":    "a \"quoted\"\tvalue",
+		"literal": `C:\path`,
+		"count":   int64(1000),
+		"ratio":   0.5,
+		"neg":     int64(-3),
+		"on":      true,
+		"list":    []any{"x", "y"},
+		"prompt":  "line one\nline two",
+		"table": map[string]any{
+			"key":    "v",
+			"dotted": map[string]any{"inner": int64(2)},
+			"sub":    map[string]any{"quoted key": false},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTOML =\n%#v\nwant\n%#v", got, want)
+	}
+}
+
+func TestParseTOML_Errors(t *testing.T) {
+	tests := map[string]string{
+		"duplicate":     "a = 1\na = 2\n",
+		"missing value": "a =\n",
+		"unterminated":  "a = \"x\n",
+		"inline table":  "a = {b = 1}\n",
+		"trailing junk": "a = 1 2\n",
+		"bad escape":    `a = "\q"`,
+		"not a table":   "a = 1\n[a]\n",
+	}
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseTOML(src)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.HasPrefix(err.Error(), "toml line ") {
+				t.Errorf("error %q lacks line number", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+// Package docindex builds and searches local semantic indexes of text files.
+// An index holds the files' chunks and their embedding vectors, in
+// $XDG_DATA_HOME/q/indexes/NAME: the chunks in index.json and the vectors,
+// as little-endian float32s, in vectors.f32.
+package docindex
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"q/internal/config"
+	"q/internal/fsutil"
+)
+
+// Defaults for Options.
+const (
+	DefaultChunkSize   = 1500    // bytes, about 400 tokens
+	DefaultMaxFileSize = 1 << 20 // bytes
+)
+
+// overlapDiv sets how much of the end of a chunk is repeated at the start of
+// the next, as a fraction of the chunk size, so text cut at a boundary is
+// still found whole.
+const overlapDiv = 5
+
+const (
+	metaFile    = "index.json"
+	vectorsFile = "vectors.f32"
+)
+
+// ErrNotFound is returned by Load for an index that does not exist.
+var ErrNotFound = errors.New("no such index")
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// Chunk is a piece of a file: lines StartLine to EndLine, counted from 1.
+type Chunk struct {
+	File      string `json:"file"` // relative to the index root, with slashes
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Text      string `json:"text"`
+}
+
+// Index is a set of chunks and their embedding vectors.
+type Index struct {
+	Name      string            `json:"name"`
+	Root      string            `json:"root"`
+	Provider  string            `json:"provider"`
+	Model     string            `json:"model"`
+	ChunkSize int               `json:"chunk_size"`
+	Dims      int               `json:"dims"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	Files     map[string]string `json:"files"` // file -> SHA-256 of its contents
+	Chunks    []Chunk           `json:"chunks"`
+
+	vectors [][]float32 // one per chunk
+}
+
+// Options control which files are indexed and how they are cut up.
+type Options struct {
+	ChunkSize   int      // 0 means DefaultChunkSize
+	MaxFileSize int64    // larger files are skipped; 0 means DefaultMaxFileSize
+	Include     []string // base name patterns, as in filepath.Match; none means all
+}
+
+// Stats describes a Build.
+type Stats struct {
+	Files   int // text files indexed
+	Reused  int // of which unchanged since the previous index
+	Skipped int // files left out as binary or too large
+	Chunks  int
+}
+
+// EmbedFunc embeds texts, returning one vector per text.
+type EmbedFunc func(ctx context.Context, texts []string) ([][]float32, error)
+
+// Build indexes the text files under root. Hidden files and directories are
+// left out. The vectors of files unchanged since prev are reused, so prev
+// must have been built with the same embedding model and chunk size; it may
+// be nil.
+func Build(ctx context.Context, root string, opts Options, prev *Index, embed EmbedFunc) (*Index, Stats, error) {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = DefaultMaxFileSize
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+
+	// Chunks and vectors of prev, by file.
+	reuse := map[string][]int{}
+	if prev != nil {
+		for i, c := range prev.Chunks {
+			reuse[c.File] = append(reuse[c.File], i)
+		}
+	}
+
+	idx := &Index{Root: root, ChunkSize: opts.ChunkSize, Files: map[string]string{}}
+	var stats Stats
+	var pending []int // chunks still to embed
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !included(d.Name(), opts.Include) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > opts.MaxFileSize {
+			stats.Skipped++
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+			stats.Skipped++
+			return nil
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		idx.Files[rel] = hash
+		stats.Files++
+
+		if prev != nil && prev.Files[rel] == hash {
+			for _, i := range reuse[rel] {
+				idx.Chunks = append(idx.Chunks, prev.Chunks[i])
+				idx.vectors = append(idx.vectors, prev.vectors[i])
+			}
+			stats.Reused++
+			return nil
+		}
+		for _, c := range Split(string(data), opts.ChunkSize) {
+			c.File = rel
+			pending = append(pending, len(idx.Chunks))
+			idx.Chunks = append(idx.Chunks, c)
+			idx.vectors = append(idx.vectors, nil)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, Stats{}, err
+	}
+
+	if len(pending) > 0 {
+		texts := make([]string, len(pending))
+		for i, c := range pending {
+			texts[i] = embeddingText(idx.Chunks[c])
+		}
+		vectors, err := embed(ctx, texts)
+		if err != nil {
+			return nil, Stats{}, err
+		}
+		if len(vectors) != len(pending) {
+			return nil, Stats{}, fmt.Errorf("got %d vectors for %d chunks", len(vectors), len(pending))
+		}
+		for i, c := range pending {
+			idx.vectors[c] = vectors[i]
+		}
+	}
+	for _, v := range idx.vectors {
+		if idx.Dims == 0 {
+			idx.Dims = len(v)
+		}
+		if len(v) != idx.Dims {
+			return nil, Stats{}, fmt.Errorf("vectors of different sizes: %d and %d", idx.Dims, len(v))
+		}
+	}
+	stats.Chunks = len(idx.Chunks)
+	return idx, stats, nil
+}
+
+// embeddingText is what is embedded for c: its text, led by the file name,
+// which often says what the text is about.
+func embeddingText(c Chunk) string {
+	return c.File + "\n\n" + c.Text
+}
+
+func included(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(patterns, func(p string) bool {
+		ok, _ := filepath.Match(p, name)
+		return ok
+	})
+}
+
+// Split cuts text into chunks of whole lines of at most size bytes, except
+// that lines longer than size are cut into several chunks. Consecutive chunks
+// overlap by a few lines. Chunks holding only white space are dropped.
+func Split(text string, size int) []Chunk {
+	type segment struct {
+		line int
+		text string
+	}
+	var segs []segment
+	for i, line := range strings.SplitAfter(text, "\n") {
+		for len(line) > size {
+			cut := size
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				cut = size
+			}
+			segs = append(segs, segment{i + 1, line[:cut]})
+			line = line[cut:]
+		}
+		if line != "" {
+			segs = append(segs, segment{i + 1, line})
+		}
+	}
+
+	var chunks []Chunk
+	emit := func(cur []segment) {
+		var b strings.Builder
+		for _, s := range cur {
+			b.WriteString(s.text)
+		}
+		if strings.TrimSpace(b.String()) != "" {
+			chunks = append(chunks, Chunk{StartLine: cur[0].line, EndLine: cur[len(cur)-1].line, Text: b.String()})
+		}
+	}
+	var cur []segment
+	n := 0
+	for _, s := range segs {
+		if n+len(s.text) > size && len(cur) > 0 {
+			emit(cur)
+			// Keep the last lines, up to size/overlapDiv bytes.
+			keep, kept := len(cur), 0
+			for keep > 1 && kept+len(cur[keep-1].text) <= size/overlapDiv {
+				keep--
+				kept += len(cur[keep].text)
+			}
+			cur, n = slices.Clone(cur[keep:]), kept
+		}
+		cur = append(cur, s)
+		n += len(s.text)
+	}
+	if len(cur) > 0 {
+		emit(cur)
+	}
+	return chunks
+}
+
+// Hit is a search result.
+type Hit struct {
+	Chunk
+	Score float64 // cosine similarity to the query
+}
+
+// Search returns the k chunks most similar to the query vector, best first.
+func (idx *Index) Search(query []float32, k int) []Hit {
+	hits := make([]Hit, 0, len(idx.Chunks))
+	for i, c := range idx.Chunks {
+		hits = append(hits, Hit{c, cosine(query, idx.vectors[i])})
+	}
+	slices.SortStableFunc(hits, func(a, b Hit) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+	return hits[:min(k, len(hits))]
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// Dir returns the directory holding all indexes.
+func Dir() (string, error) {
+	dir, err := config.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "indexes"), nil
+}
+
+func indexDir(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("invalid index name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// Save writes idx under idx.Name, replacing any index of that name.
+func Save(idx *Index) error {
+	dir, err := indexDir(idx.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	vectors := make([]byte, 0, len(idx.vectors)*idx.Dims*4)
+	for _, v := range idx.vectors {
+		for _, f := range v {
+			vectors = binary.LittleEndian.AppendUint32(vectors, math.Float32bits(f))
+		}
+	}
+	meta, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	// The vectors go first: an index.json is only ever next to its vectors.
+	if err := fsutil.WriteFileAtomic(filepath.Join(dir, vectorsFile), vectors, 0o644); err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(filepath.Join(dir, metaFile), meta, 0o644)
+}
+
+// Load reads the index called name.
+func Load(name string) (*Index, error) {
+	dir, err := indexDir(name)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := os.ReadFile(filepath.Join(dir, metaFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	idx := &Index{}
+	if err := json.Unmarshal(meta, idx); err != nil {
+		return nil, fmt.Errorf("index %s: %w", name, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, vectorsFile))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != len(idx.Chunks)*idx.Dims*4 {
+		return nil, fmt.Errorf("index %s: vectors do not match its chunks; rebuild it", name)
+	}
+	idx.vectors = make([][]float32, len(idx.Chunks))
+	for i := range idx.vectors {
+		v := make([]float32, idx.Dims)
+		for j := range v {
+			v[j] = math.Float32frombits(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		}
+		idx.vectors[i] = v
+	}
+	return idx, nil
+}
+
+// List returns the names of all indexes, sorted.
+func List() ([]string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(dir, e.Name(), metaFile)); err == nil {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// Remove deletes the index called name.
+func Remove(name string) error {
+	dir, err := indexDir(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, metaFile)); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,179 @@
+package docindex
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	// Lines longer than a fifth of the size are not repeated.
+	text := "aaaa\nbbbb\ncccc\ndddd\n"
+	got := Split(text, 10)
+	want := []Chunk{
+		{StartLine: 1, EndLine: 2, Text: "aaaa\nbbbb\n"},
+		{StartLine: 3, EndLine: 4, Text: "cccc\ndddd\n"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Split = %+v; want %+v", got, want)
+	}
+}
+
+func TestSplit_Overlap(t *testing.T) {
+	// Short lines: each chunk repeats up to a fifth of the size from the last.
+	text := strings.Repeat("ab\n", 20)
+	chunks := Split(text, 30)
+	for i := 1; i < len(chunks); i++ {
+		if chunks[i].StartLine > chunks[i-1].EndLine {
+			t.Errorf("chunk %d starts at line %d, after the previous one ended at %d", i, chunks[i].StartLine, chunks[i-1].EndLine)
+		}
+		if len(chunks[i].Text) > 30 {
+			t.Errorf("chunk %d is %d bytes; want at most 30", i, len(chunks[i].Text))
+		}
+	}
+	if last := chunks[len(chunks)-1]; last.EndLine != 20 {
+		t.Errorf("last chunk ends at line %d; want 20", last.EndLine)
+	}
+}
+
+func TestSplit_LongLine(t *testing.T) {
+	got := Split("héllo wörld", 4)
+	var joined strings.Builder
+	for _, c := range got {
+		if c.StartLine != 1 || c.EndLine != 1 {
+			t.Errorf("chunk %+v: want line 1", c)
+		}
+		joined.WriteString(c.Text)
+	}
+	if joined.String() != "héllo wörld" {
+		t.Errorf("chunks = %+v; want the line cut on rune boundaries", got)
+	}
+}
+
+func TestSplit_Blank(t *testing.T) {
+	if got := Split("\n\n  \n", 100); len(got) != 0 {
+		t.Errorf("Split of blank text = %+v; want nothing", got)
+	}
+}
+
+// fakeEmbed embeds a text as the counts of a, b and c in it, and records how
+// many texts it was given.
+type fakeEmbed struct{ calls []int }
+
+func (f *fakeEmbed) embed(_ context.Context, texts []string) ([][]float32, error) {
+	f.calls = append(f.calls, len(texts))
+	var vs [][]float32
+	for _, s := range texts {
+		vs = append(vs, []float32{
+			float32(strings.Count(s, "a")),
+			float32(strings.Count(s, "b")),
+			float32(strings.Count(s, "c")),
+		})
+	}
+	return vs, nil
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"a.md":          "aaaa",
+		"sub/b.txt":     "bbbb",
+		"c.go":          "cccc",
+		".git/config":   "aaaa",
+		"bin/tool":      "a\x00b",
+		"notes/.hidden": "aaaa",
+	})
+	f := &fakeEmbed{}
+	idx, stats, err := Build(context.Background(), root, Options{}, nil, f.embed)
+	if err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	if stats != (Stats{Files: 3, Skipped: 1, Chunks: 3}) {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	hits := idx.Search([]float32{0, 1, 0}, 2)
+	if len(hits) != 2 || hits[0].File != "sub/b.txt" || hits[0].Score < 0.99 {
+		t.Errorf("Search = %+v; want sub/b.txt first", hits)
+	}
+
+	// Rebuilding embeds only what changed.
+	writeFiles(t, root, map[string]string{"c.go": "ccccc", "d.md": "abc"})
+	f.calls = nil
+	idx2, stats, err := Build(context.Background(), root, Options{}, idx, f.embed)
+	if err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	if stats.Files != 4 || stats.Reused != 2 || !slices.Equal(f.calls, []int{2}) {
+		t.Errorf("rebuild stats = %+v, embed calls %v; want 2 files reused and 2 embedded", stats, f.calls)
+	}
+	if hits := idx2.Search([]float32{0, 0, 1}, 1); hits[0].File != "c.go" || !strings.Contains(hits[0].Text, "ccccc") {
+		t.Errorf("Search after rebuild = %+v", hits)
+	}
+}
+
+func TestBuild_Include(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a.md": "aaaa", "b.txt": "bbbb"})
+	f := &fakeEmbed{}
+	idx, _, err := Build(context.Background(), root, Options{Include: []string{"*.md"}}, nil, f.embed)
+	if err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	if len(idx.Chunks) != 1 || idx.Chunks[0].File != "a.md" {
+		t.Errorf("chunks = %+v; want only a.md", idx.Chunks)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a.md": "aaaa", "b.md": "abcc"})
+	f := &fakeEmbed{}
+	idx, _, err := Build(context.Background(), root, Options{}, nil, f.embed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Name, idx.Provider, idx.Model = "runbooks", "openai", "text-embedding-3-small"
+	if err := Save(idx); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	got, err := Load("runbooks")
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if got.Model != idx.Model || got.Dims != 3 || !slices.EqualFunc(got.vectors, idx.vectors, slices.Equal) {
+		t.Errorf("Load = %+v; want what was saved", got)
+	}
+	if names, err := List(); err != nil || !slices.Equal(names, []string{"runbooks"}) {
+		t.Errorf("List = %v, %v", names, err)
+	}
+
+	if err := Remove("runbooks"); err != nil {
+		t.Fatalf("Remove error: %v", err)
+	}
+	if _, err := Load("runbooks"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Load after Remove error = %v; want ErrNotFound", err)
+	}
+	if err := Save(&Index{Name: "../escape"}); err == nil {
+		t.Error("Save with a path as the name succeeded")
+	}
+}
@@ -0,0 +1,49 @@
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Lock timing. A lock older than lockStale is assumed to belong to a process
+// that died without releasing it.
+var (
+	lockWait  = 5 * time.Second
+	lockPoll  = 10 * time.Millisecond
+	lockStale = 30 * time.Second
+)
+
+// Lock takes an exclusive lock on path by creating path.lock, waiting up to
+// lockWait for another holder. Processes that write the same file take it
+// around their read-modify-write. It uses O_EXCL rather than flock so it
+// behaves the same on every platform.
+func Lock(path string) (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	lock := path + ".lock"
+	deadline := time.Now().Add(lockWait)
+	for {
+		f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			_, _ = f.WriteString(strconv.Itoa(os.Getpid()))
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if fi, err := os.Stat(lock); err == nil && time.Since(fi.ModTime()) > lockStale {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is locked by another q process\n\nIf none is running, remove %s", path, lock)
+		}
+		time.Sleep(lockPoll)
+	}
+}
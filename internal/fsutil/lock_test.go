@@ -0,0 +1,35 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLock_StaleAndTimeout(t *testing.T) {
+	defer func(w, s time.Duration) { lockWait, lockStale = w, s }(lockWait, lockStale)
+	lockWait, lockStale = 50*time.Millisecond, time.Hour
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	unlock, err := Lock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Lock(path); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("second Lock error = %v; want locked", err)
+	}
+
+	// A lock older than lockStale is taken over.
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path+".lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+	unlock2, err := Lock(path)
+	if err != nil {
+		t.Fatalf("Lock over stale lock: %v", err)
+	}
+	unlock2()
+	unlock()
+}
@@ -0,0 +1,377 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variables selecting a cassette for FromEnv.
+const (
+	CassetteEnv     = "Q_CASSETTE"      // cassette file
+	CassetteModeEnv = "Q_CASSETTE_MODE" // ModeRecord or ModeReplay
+)
+
+// Cassette modes.
+const (
+	ModeRecord = "record"
+	ModeReplay = "replay"
+)
+
+// Redacted replaces secrets in recorded requests.
+const Redacted = "REDACTED"
+
+// secretHeaders are never written to a cassette.
+var secretHeaders = []string{
+	"Authorization", "Proxy-Authorization", "Api-Key", "X-Api-Key", "X-Goog-Api-Key",
+	"Cookie", "Set-Cookie", "Openai-Organization", "Openai-Project",
+}
+
+// secretParams are URL query parameters never written to a cassette.
+var secretParams = []string{"key", "api_key", "access_token"}
+
+// Cassette is a recording of HTTP interactions, stored as JSON.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a request and the response it got.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request with its secrets redacted.
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// RecordedResponse is a response whose body is kept in the chunks it was read
+// in, so streamed responses replay at their original pace.
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Chunks     []Chunk     `json:"chunks,omitempty"`
+}
+
+// Chunk is part of a response body and how long after the previous one, or
+// after the request was sent, it arrived.
+type Chunk struct {
+	DelayMS int64  `json:"delay_ms"`
+	Data    string `json:"data"`
+}
+
+// FromEnv returns a client recording to or replaying from the cassette named
+// by $Q_CASSETTE, or http.DefaultClient if it is unset. Problems with the
+// cassette are reported by the client's Do.
+func FromEnv() HTTPClient {
+	path := os.Getenv(CassetteEnv)
+	if path == "" {
+		return http.DefaultClient
+	}
+	switch mode := os.Getenv(CassetteModeEnv); mode {
+	case ModeRecord:
+		return NewRecorder(http.DefaultClient, path)
+	case ModeReplay, "":
+		return NewReplayer(path, true)
+	default:
+		return errClient{fmt.Errorf("%s=%q: want %s or %s", CassetteModeEnv, mode, ModeRecord, ModeReplay)}
+	}
+}
+
+// Replaying reports whether FromEnv replays a cassette, in which case no
+// real API keys are needed.
+func Replaying() bool {
+	mode := os.Getenv(CassetteModeEnv)
+	return os.Getenv(CassetteEnv) != "" && (mode == ModeReplay || mode == "")
+}
+
+type errClient struct{ err error }
+
+func (c errClient) Do(*http.Request) (*http.Response, error) { return nil, c.err }
+
+// redactor collects the secrets of a request and blanks them out wherever
+// they appear.
+type redactor struct{ secrets []string }
+
+func (r *redactor) header(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range secretHeaders {
+		for _, v := range h.Values(name) {
+			_, token, ok := strings.Cut(v, " ") // "Bearer sk-..."
+			if !ok {
+				token = v
+			}
+			if len(token) >= 8 {
+				r.secrets = append(r.secrets, token)
+			}
+		}
+		if h.Get(name) != "" {
+			h.Set(name, Redacted)
+		}
+	}
+	return h
+}
+
+func (r *redactor) url(u *url.URL) string {
+	q := u.Query()
+	changed := false
+	for _, name := range secretParams {
+		if v := q.Get(name); v != "" {
+			r.secrets = append(r.secrets, v)
+			q.Set(name, Redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return r.text(u.String())
+	}
+	c := *u
+	c.RawQuery = q.Encode()
+	return r.text(c.String())
+}
+
+func (r *redactor) text(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	return s
+}
+
+// recordRequest reads and restores req's body, returning the request as it
+// is recorded.
+func recordRequest(req *http.Request) (RecordedRequest, *redactor, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return RecordedRequest{}, nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	r := &redactor{}
+	header := r.header(req.Header)
+	return RecordedRequest{
+		Method: req.Method,
+		URL:    r.url(req.URL),
+		Header: header,
+		Body:   r.text(string(body)),
+	}, r, nil
+}
+
+// Recorder is an HTTPClient that passes requests on and appends each
+// interaction to a cassette file once its response body is closed. Secrets
+// in headers and query parameters are redacted, also where they reappear.
+type Recorder struct {
+	next HTTPClient
+	path string
+
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewRecorder returns a Recorder sending requests with next and recording
+// them to path, after any interactions already there.
+func NewRecorder(next HTTPClient, path string) *Recorder {
+	return &Recorder{next: next, path: path, now: time.Now}
+}
+
+func (c *Recorder) Do(req *http.Request) (*http.Response, error) {
+	recorded, redact, err := recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	sent := c.now()
+	resp, err := c.next.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &recordingBody{
+		body: resp.Body,
+		last: sent,
+		now:  c.now,
+		done: func(chunks []Chunk) error {
+			for i := range chunks {
+				chunks[i].Data = redact.text(chunks[i].Data)
+			}
+			return c.save(Interaction{
+				Request: recorded,
+				Response: RecordedResponse{
+					StatusCode: resp.StatusCode,
+					Header:     redact.header(resp.Header),
+					Chunks:     chunks,
+				},
+			})
+		},
+	}
+	return resp, nil
+}
+
+func (c *Recorder) save(in Interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var cas Cassette
+	data, err := os.ReadFile(c.path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &cas); err != nil {
+			return fmt.Errorf("cassette %s: %w", c.path, err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	cas.Interactions = append(cas.Interactions, in)
+	if data, err = json.MarshalIndent(cas, "", "  "); err != nil {
+		return err
+	}
+	if dir := filepath.Dir(c.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(c.path, append(data, '\n'), 0o644)
+}
+
+// recordingBody keeps what is read through it, and when, and hands the
+// chunks to done on Close.
+type recordingBody struct {
+	body   io.ReadCloser
+	chunks []Chunk
+	last   time.Time
+	now    func() time.Time
+	done   func([]Chunk) error
+	closed bool
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		t := b.now()
+		b.chunks = append(b.chunks, Chunk{DelayMS: t.Sub(b.last).Milliseconds(), Data: string(p[:n])})
+		b.last = t
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.body.Close()
+	if b.closed {
+		return err
+	}
+	b.closed = true
+	return errors.Join(err, b.done(b.chunks))
+}
+
+// Replayer is an HTTPClient answering requests from a cassette file instead
+// of the network. A request gets the first unused interaction with the same
+// method, URL and body; headers, where API keys live, are not compared.
+type Replayer struct {
+	path     string
+	realtime bool // wait between chunks as recorded
+
+	once     sync.Once
+	cassette Cassette
+	err      error
+
+	mu   sync.Mutex
+	used []bool
+}
+
+// NewReplayer returns a Replayer for the cassette at path. With realtime,
+// response bodies arrive at their recorded pace.
+func NewReplayer(path string, realtime bool) *Replayer {
+	return &Replayer{path: path, realtime: realtime}
+}
+
+func (c *Replayer) load() error {
+	c.once.Do(func() {
+		data, err := os.ReadFile(c.path)
+		if err != nil {
+			c.err = fmt.Errorf("cassette: %w\n\nRecord it first: %s=%s %s=%s q ...", err, CassetteEnv, c.path, CassetteModeEnv, ModeRecord)
+			return
+		}
+		if err := json.Unmarshal(data, &c.cassette); err != nil {
+			c.err = fmt.Errorf("cassette %s: %w", c.path, err)
+			return
+		}
+		c.used = make([]bool, len(c.cassette.Interactions))
+	})
+	return c.err
+}
+
+func (c *Replayer) Do(req *http.Request) (*http.Response, error) {
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	recorded, _, err := recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	var match *Interaction
+	for i := range c.cassette.Interactions {
+		in := &c.cassette.Interactions[i]
+		if !c.used[i] && in.Request.Method == recorded.Method && in.Request.URL == recorded.URL && in.Request.Body == recorded.Body {
+			c.used[i], match = true, in
+			break
+		}
+	}
+	c.mu.Unlock()
+	if match == nil {
+		return nil, fmt.Errorf("cassette %s has no response left for %s %s\n\nRecord it again: %s=%s", c.path, recorded.Method, recorded.URL, CassetteModeEnv, ModeRecord)
+	}
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", match.Response.StatusCode, http.StatusText(match.Response.StatusCode)),
+		StatusCode: match.Response.StatusCode,
+		Header:     match.Response.Header.Clone(),
+		Body:       &replayBody{ctx: req.Context(), chunks: match.Response.Chunks, realtime: c.realtime},
+		Request:    req,
+	}, nil
+}
+
+// replayBody returns recorded chunks, waiting before each as recorded.
+type replayBody struct {
+	ctx      context.Context
+	chunks   []Chunk
+	data     string // rest of the current chunk
+	realtime bool
+}
+
+func (b *replayBody) Read(p []byte) (int, error) {
+	for b.data == "" {
+		if len(b.chunks) == 0 {
+			return 0, io.EOF
+		}
+		chunk := b.chunks[0]
+		b.chunks = b.chunks[1:]
+		if b.realtime && chunk.DelayMS > 0 {
+			if err := sleepContext(b.ctx, time.Duration(chunk.DelayMS)*time.Millisecond); err != nil {
+				return 0, err
+			}
+		}
+		b.data = chunk.Data
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	return n, nil
+}
+
+func (b *replayBody) Close() error { return nil }
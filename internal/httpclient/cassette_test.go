@@ -0,0 +1,172 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const secretKey = "sk-test-0123456789"
+
+// sseServer streams three events, flushing each, and echoes the API key in
+// a header, as a careless proxy might.
+func sseServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("X-Api-Key", strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		for _, ev := range []string{"one", "two", "three"} {
+			io.WriteString(w, "data: "+ev+"\n\n")
+			w.(http.Flusher).Flush()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func send(t *testing.T, c HTTPClient, url, body string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+secretKey)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Fatalf("closing body: %v", err)
+	}
+	return resp, string(data)
+}
+
+func TestCassette_RecordReplay(t *testing.T) {
+	srv := sseServer(t)
+	path := filepath.Join(t.TempDir(), "cassettes", "chat.json")
+	url := srv.URL + "/v1/chat/completions?key=" + secretKey
+
+	_, live := send(t, NewRecorder(http.DefaultClient, path), url, `{"prompt":"hi"}`)
+	if live != "data: one\n\ndata: two\n\ndata: three\n\n" {
+		t.Fatalf("recorded body = %q", live)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secretKey) {
+		t.Errorf("cassette contains the API key:\n%s", data)
+	}
+	var cas Cassette
+	if err := json.Unmarshal(data, &cas); err != nil {
+		t.Fatal(err)
+	}
+	in := cas.Interactions[0]
+	if in.Request.Header.Get("Authorization") != Redacted || !strings.Contains(in.Request.URL, "key="+Redacted) {
+		t.Errorf("request not redacted: %+v", in.Request)
+	}
+	if len(in.Response.Chunks) < 2 {
+		t.Errorf("response recorded in %d chunks; want the stream's events kept apart", len(in.Response.Chunks))
+	}
+
+	r := NewReplayer(path, false)
+	resp, replayed := send(t, r, url, `{"prompt":"hi"}`)
+	if replayed != live || resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("replayed %d %q, %v; want the recording", resp.StatusCode, replayed, resp.Header)
+	}
+
+	// Each interaction is used once, and requests must match.
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(`{"prompt":"hi"}`))
+	if _, err := r.Do(req); err == nil || !strings.Contains(err.Error(), "no response left") {
+		t.Errorf("second replay error = %v; want no response left", err)
+	}
+	req, _ = http.NewRequest(http.MethodPost, url, strings.NewReader(`{"prompt":"bye"}`))
+	if _, err := NewReplayer(path, false).Do(req); err == nil {
+		t.Error("replay of a different body succeeded")
+	}
+
+	// Recording again appends.
+	send(t, NewRecorder(http.DefaultClient, path), url, `{"prompt":"bye"}`)
+	if _, body := send(t, NewReplayer(path, false), url, `{"prompt":"bye"}`); body != live {
+		t.Errorf("appended interaction replayed %q", body)
+	}
+}
+
+func TestCassette_ReplayTiming(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c.json")
+	cas := Cassette{Interactions: []Interaction{{
+		Request:  RecordedRequest{Method: http.MethodGet, URL: "https://api.example.com/v1/models"},
+		Response: RecordedResponse{StatusCode: 200, Chunks: []Chunk{{0, "a"}, {30, "b"}}},
+	}}}
+	data, _ := json.Marshal(cas)
+	os.WriteFile(path, data, 0o644)
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/v1/models", nil)
+	start := time.Now()
+	resp, err := NewReplayer(path, true).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "ab" || time.Since(start) < 30*time.Millisecond {
+		t.Errorf("replayed %q in %v; want ab after 30ms", body, time.Since(start))
+	}
+
+	// Cancelling the request stops the wait.
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, "https://api.example.com/v1/models", nil)
+	cas.Interactions[0].Response.Chunks[1].DelayMS = 60_000
+	data, _ = json.Marshal(cas)
+	os.WriteFile(path, data, 0o644)
+	resp, err = NewReplayer(path, true).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Error("read of a cancelled replay succeeded")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(CassetteEnv, "")
+	if FromEnv() != http.DefaultClient || Replaying() {
+		t.Error("FromEnv without a cassette is not the default client")
+	}
+	t.Setenv(CassetteEnv, filepath.Join(t.TempDir(), "missing.json"))
+	for mode, want := range map[string]string{ModeRecord: "*httpclient.Recorder", "": "*httpclient.Replayer", ModeReplay: "*httpclient.Replayer"} {
+		t.Setenv(CassetteModeEnv, mode)
+		if got := typeName(FromEnv()); got != want {
+			t.Errorf("mode %q: FromEnv = %s; want %s", mode, got, want)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/", nil)
+	if _, err := FromEnv().Do(req); err == nil || !strings.Contains(err.Error(), "Record it first") {
+		t.Errorf("replay of a missing cassette error = %v", err)
+	}
+	t.Setenv(CassetteModeEnv, "rewind")
+	if _, err := FromEnv().Do(req); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case *Recorder:
+		return "*httpclient.Recorder"
+	case *Replayer:
+		return "*httpclient.Replayer"
+	}
+	return "other"
+}
@@ -0,0 +1,250 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Retry defaults.
+const (
+	DefaultMaxAttempts = 4
+	DefaultBaseDelay   = 500 * time.Millisecond
+	DefaultMaxDelay    = 20 * time.Second
+	DefaultMaxElapsed  = time.Minute
+)
+
+// RetryClient decorates an HTTPClient with jittered exponential backoff for
+// transient failures: 408, 429, 5xx responses and connection errors. A 429
+// for an exhausted quota is not transient and is returned at once.
+//
+// Only the request/response handshake is retried. Once a successful response
+// is returned the caller owns the body, so a stream that breaks after output
+// has started is never replayed.
+type RetryClient struct {
+	next HTTPClient
+
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	maxElapsed  time.Duration
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// WithMaxAttempts sets the total number of attempts, including the first one.
+func WithMaxAttempts(n int) func(*RetryClient) {
+	return func(c *RetryClient) { c.maxAttempts = max(n, 1) }
+}
+
+// WithBackoff sets the initial and maximum delay between attempts.
+func WithBackoff(base, maxDelay time.Duration) func(*RetryClient) {
+	return func(c *RetryClient) { c.baseDelay, c.maxDelay = base, maxDelay }
+}
+
+// WithMaxElapsed caps the total time spent across all attempts and waits.
+func WithMaxElapsed(d time.Duration) func(*RetryClient) {
+	return func(c *RetryClient) { c.maxElapsed = d }
+}
+
+type attemptsKey struct{}
+
+// WithoutRetries returns ctx for requests that should fail at once on a
+// transient error rather than be retried, e.g. because the caller has
+// another model to fall back to.
+func WithoutRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, attemptsKey{}, 1)
+}
+
+// NewRetryClient wraps next with retry behavior.
+func NewRetryClient(next HTTPClient, opts ...func(*RetryClient)) *RetryClient {
+	c := &RetryClient{
+		next:        next,
+		maxAttempts: DefaultMaxAttempts,
+		baseDelay:   DefaultBaseDelay,
+		maxDelay:    DefaultMaxDelay,
+		maxElapsed:  DefaultMaxElapsed,
+		now:         time.Now,
+		sleep:       sleepContext,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// Do implements HTTPClient.
+func (c *RetryClient) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	start := c.now()
+
+	// A body that cannot be rewound can only be sent once.
+	attempts := c.maxAttempts
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		attempts = 1
+	}
+	if n, ok := ctx.Value(attemptsKey{}).(int); ok {
+		attempts = min(attempts, n)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		resp, err := c.next.Do(req)
+		if attempt+1 >= attempts || !shouldRetry(ctx, resp, err) {
+			return resp, err
+		}
+
+		delay := c.backoff(attempt)
+		if resp != nil {
+			if d, ok := RetryAfter(resp.Header, resp.StatusCode, c.now()); ok {
+				delay = d
+			}
+		}
+		if c.now().Add(delay).Sub(start) > c.maxElapsed {
+			return resp, err
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		if err := c.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff returns a jittered exponential delay for the given attempt.
+func (c *RetryClient) backoff(attempt int) time.Duration {
+	d := c.baseDelay << attempt
+	if d <= 0 || d > c.maxDelay {
+		d = c.maxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	// Equal jitter: somewhere in [d/2, d].
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return IsTransientError(err)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests && quotaExhausted(resp) {
+		return false
+	}
+	return IsTransientStatus(resp.StatusCode)
+}
+
+// quotaExhausted reports whether a 429 says the account is out of credit,
+// which no wait will fix. The body is peeked at and left readable.
+func quotaExhausted(resp *http.Response) bool {
+	peek, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peek), resp.Body), resp.Body}
+	if err != nil {
+		return false
+	}
+	var body struct {
+		Error struct {
+			Type string `json:"type"`
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(peek, &body) != nil {
+		return false
+	}
+	return body.Error.Code == "insufficient_quota" || body.Error.Type == "insufficient_quota"
+}
+
+// IsTransientStatus reports whether an HTTP status is worth retrying.
+func IsTransientStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+		529: // overloaded
+		return true
+	}
+	return false
+}
+
+// IsTransientError reports whether a transport error is worth retrying.
+func IsTransientError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// RetryAfter extracts how long to wait from Retry-After or, for a 429 with
+// the given status, the OpenAI-style x-ratelimit-reset-* headers. OpenAI
+// sends those on every response, but they only say when a limit resets,
+// which is no reason to wait after other failures. It returns the longest
+// delay found.
+func RetryAfter(h http.Header, status int, now time.Time) (time.Duration, bool) {
+	var delay time.Duration
+	found := false
+
+	if v := strings.TrimSpace(h.Get("Retry-After")); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs >= 0 {
+			delay, found = time.Duration(secs*float64(time.Second)), true
+		} else if t, err := http.ParseTime(v); err == nil {
+			delay, found = max(t.Sub(now), 0), true
+		}
+	}
+	if v := h.Get("Retry-After-Ms"); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms >= 0 {
+			delay, found = max(delay, time.Duration(ms*float64(time.Millisecond))), true
+		}
+	}
+	if status != http.StatusTooManyRequests {
+		return delay, found
+	}
+	for _, name := range []string{"X-Ratelimit-Reset-Requests", "X-Ratelimit-Reset-Tokens"} {
+		if d, err := time.ParseDuration(h.Get(name)); err == nil && d >= 0 {
+			delay, found = max(delay, d), true
+		}
+	}
+	return delay, found
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,219 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer fails the first n requests with status, then echoes the body.
+func flakyServer(t *testing.T, n int32, status int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= n {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func newTestClient(opts ...func(*RetryClient)) (*RetryClient, *[]time.Duration) {
+	var waits []time.Duration
+	c := NewRetryClient(http.DefaultClient, opts...)
+	c.sleep = func(_ context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	return c, &waits
+}
+
+func post(t *testing.T, c HTTPClient, url, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestRetry_RecoversFromTransientStatus(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, 529} {
+		srv, calls := flakyServer(t, 2, status, nil)
+		c, waits := newTestClient()
+
+		resp := post(t, c, srv.URL, "payload")
+		got, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(got) != "payload" {
+			t.Errorf("status %d: got %d %q; want 200 with replayed body", status, resp.StatusCode, got)
+		}
+		if calls.Load() != 3 || len(*waits) != 2 {
+			t.Errorf("status %d: calls = %d, waits = %d; want 3, 2", status, calls.Load(), len(*waits))
+		}
+	}
+}
+
+func TestRetry_GivesUpAfterMaxAttempts(t *testing.T) {
+	srv, calls := flakyServer(t, 10, http.StatusBadGateway, nil)
+	c, _ := newTestClient(WithMaxAttempts(3))
+
+	resp := post(t, c, srv.URL, "x")
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status = %d; want %d", resp.StatusCode, http.StatusBadGateway)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("calls = %d; want 3", calls.Load())
+	}
+}
+
+func TestRetry_WithoutRetries(t *testing.T) {
+	srv, calls := flakyServer(t, 1, http.StatusServiceUnavailable, nil)
+	c, waits := newTestClient()
+
+	req, _ := http.NewRequestWithContext(WithoutRetries(context.Background()), http.MethodPost, srv.URL, strings.NewReader("x"))
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || calls.Load() != 1 || len(*waits) != 0 {
+		t.Errorf("got %d after %d calls and %d waits; want 503 at once", resp.StatusCode, calls.Load(), len(*waits))
+	}
+}
+
+func TestRetry_DoesNotRetryClientErrors(t *testing.T) {
+	srv, calls := flakyServer(t, 1, http.StatusBadRequest, nil)
+	c, _ := newTestClient()
+
+	resp := post(t, c, srv.URL, "x")
+	if resp.StatusCode != http.StatusBadRequest || calls.Load() != 1 {
+		t.Errorf("got status %d after %d calls; want 400 after 1", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestRetry_HonorsRetryAfter(t *testing.T) {
+	h := http.Header{"Retry-After": {"3"}, "X-Ratelimit-Reset-Tokens": {"1.5s"}}
+	srv, _ := flakyServer(t, 1, http.StatusTooManyRequests, h)
+	c, waits := newTestClient()
+
+	post(t, c, srv.URL, "x")
+	if len(*waits) != 1 || (*waits)[0] != 3*time.Second {
+		t.Errorf("waits = %v; want [3s]", *waits)
+	}
+}
+
+func TestRetry_IgnoresResetHeadersOutside429(t *testing.T) {
+	h := http.Header{"X-Ratelimit-Reset-Requests": {"6m0s"}, "X-Ratelimit-Reset-Tokens": {"1s"}}
+	srv, calls := flakyServer(t, 1, http.StatusInternalServerError, h)
+	c, waits := newTestClient(WithBackoff(100*time.Millisecond, time.Second))
+
+	resp := post(t, c, srv.URL, "x")
+	if resp.StatusCode != http.StatusOK || calls.Load() != 2 {
+		t.Fatalf("got %d after %d calls; want 200 after 2", resp.StatusCode, calls.Load())
+	}
+	if len(*waits) != 1 || (*waits)[0] > 100*time.Millisecond {
+		t.Errorf("waits = %v; want one backoff, not the reset time", *waits)
+	}
+}
+
+func TestRetry_DoesNotRetryExhaustedQuota(t *testing.T) {
+	const body = `{"error":{"message":"out of credits","type":"insufficient_quota","code":"insufficient_quota"}}`
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	c, waits := newTestClient()
+
+	resp := post(t, c, srv.URL, "x")
+	got, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusTooManyRequests || calls.Load() != 1 || len(*waits) != 0 || string(got) != body {
+		t.Errorf("got %d %q after %d calls and %d waits; want the 429 body after 1 call", resp.StatusCode, got, calls.Load(), len(*waits))
+	}
+}
+
+func TestRetry_MaxElapsedStopsEarly(t *testing.T) {
+	h := http.Header{"Retry-After": {"120"}}
+	srv, calls := flakyServer(t, 1, http.StatusTooManyRequests, h)
+	c, waits := newTestClient(WithMaxElapsed(10 * time.Second))
+
+	resp := post(t, c, srv.URL, "x")
+	if resp.StatusCode != http.StatusTooManyRequests || calls.Load() != 1 || len(*waits) != 0 {
+		t.Errorf("got %d after %d calls and %d waits; want 429 after 1 call, no waits",
+			resp.StatusCode, calls.Load(), len(*waits))
+	}
+}
+
+func TestRetry_BackoffGrowsAndCaps(t *testing.T) {
+	c := NewRetryClient(http.DefaultClient, WithBackoff(100*time.Millisecond, time.Second))
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		if want > time.Second {
+			want = time.Second
+		}
+		d := c.backoff(attempt)
+		if d < want/2 || d > want {
+			t.Errorf("backoff(%d) = %v; want within [%v, %v]", attempt, d, want/2, want)
+		}
+	}
+}
+
+func TestRetryAfter_HTTPDate(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := http.Header{"Retry-After": {now.Add(5 * time.Second).Format(http.TimeFormat)}}
+	if d, ok := RetryAfter(h, http.StatusServiceUnavailable, now); !ok || d != 5*time.Second {
+		t.Errorf("RetryAfter = %v, %v; want 5s, true", d, ok)
+	}
+	if _, ok := RetryAfter(http.Header{}, http.StatusTooManyRequests, now); ok {
+		t.Error("RetryAfter on empty header reported a delay")
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	if IsTransientError(context.Canceled) {
+		t.Error("context.Canceled should not be transient")
+	}
+	if !IsTransientError(io.ErrUnexpectedEOF) {
+		t.Error("io.ErrUnexpectedEOF should be transient")
+	}
+}
+
+func TestRetry_RecoversFromDroppedConnection(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+	c, _ := newTestClient()
+
+	resp := post(t, c, srv.URL, "x")
+	if got, _ := io.ReadAll(resp.Body); string(got) != "ok" || calls.Load() != 2 {
+		t.Errorf("got %q after %d calls; want ok after 2", got, calls.Load())
+	}
+}
@@ -0,0 +1,91 @@
+// Package images prepares pictures given on the command line for vision
+// models.
+package images
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"q/internal/providers"
+)
+
+// MaxBytes is the largest image file accepted, matching OpenAI's limit.
+const MaxBytes = 20 << 20
+
+// Types are the accepted MIME types.
+var Types = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// Load returns the image named by ref: an http(s) URL, passed on as is, a
+// data URL, or a local file, which is read and embedded as a data URL.
+func Load(ref string) (providers.Image, error) {
+	switch {
+	case strings.HasPrefix(ref, "https://") || strings.HasPrefix(ref, "http://"):
+		return providers.Image{URL: ref}, nil
+	case strings.HasPrefix(ref, "data:"):
+		params, payload, _ := strings.Cut(strings.TrimPrefix(ref, "data:"), ",")
+		mime, _, _ := strings.Cut(params, ";")
+		if err := checkType(ref, mime); err != nil {
+			return providers.Image{}, err
+		}
+		if n := dataSize(params, payload); n > MaxBytes {
+			return providers.Image{}, fmt.Errorf("image data URL is %d MB, over the %d MB limit", n>>20, MaxBytes>>20)
+		}
+		return providers.Image{URL: ref}, nil
+	}
+
+	fi, err := os.Stat(ref)
+	if err != nil {
+		return providers.Image{}, err
+	}
+	if fi.IsDir() {
+		return providers.Image{}, fmt.Errorf("image %s is a directory", ref)
+	}
+	if fi.Size() > MaxBytes {
+		return providers.Image{}, fmt.Errorf("image %s is %d MB, over the %d MB limit", ref, fi.Size()>>20, MaxBytes>>20)
+	}
+	data, err := os.ReadFile(ref)
+	if err != nil {
+		return providers.Image{}, err
+	}
+	mime := http.DetectContentType(data)
+	if err := checkType(ref, mime); err != nil {
+		return providers.Image{}, err
+	}
+	return providers.Image{URL: "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data)}, nil
+}
+
+// LoadAll loads every ref with Load.
+func LoadAll(refs []string) ([]providers.Image, error) {
+	var out []providers.Image
+	for _, ref := range refs {
+		img, err := Load(ref)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, img)
+	}
+	return out, nil
+}
+
+// dataSize returns the size of the image in a data URL with the given params
+// and payload, without decoding it.
+func dataSize(params, payload string) int {
+	if !strings.HasSuffix(params, ";base64") {
+		return len(payload)
+	}
+	return base64.RawStdEncoding.DecodedLen(len(strings.TrimRight(payload, "=")))
+}
+
+func checkType(ref, mime string) error {
+	if !slices.Contains(Types, mime) {
+		if len(ref) > 60 {
+			ref = ref[:60] + "..."
+		}
+		return fmt.Errorf("image %s: unsupported type %s; want PNG, JPEG, GIF or WebP", ref, mime)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package images
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// png is the 8-byte PNG signature followed by an IHDR chunk header, enough
+// for content sniffing.
+var png = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "cat.png")
+	if err := os.WriteFile(file, png, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	img, err := Load(file)
+	if err != nil {
+		t.Fatalf("Load(%s): %v", file, err)
+	}
+	if want := "data:image/png;base64," + base64.StdEncoding.EncodeToString(png); img.URL != want {
+		t.Errorf("URL = %q; want %q", img.URL, want)
+	}
+
+	atLimit := "data:image/png;base64," + strings.Repeat("A", base64.RawStdEncoding.EncodedLen(MaxBytes))
+	for _, ref := range []string{"https://example.com/cat.jpg", "data:image/webp;base64,UklGRg==", atLimit} {
+		if img, err := Load(ref); err != nil || img.URL != ref {
+			t.Errorf("Load(%.60s) = %.60v, %v; want it passed through", ref, img, err)
+		}
+	}
+}
+
+func TestLoad_Rejects(t *testing.T) {
+	dir := t.TempDir()
+	text := filepath.Join(dir, "notes.png")
+	if err := os.WriteFile(text, []byte("not an image"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	big := filepath.Join(dir, "big.png")
+	if err := os.WriteFile(big, png, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(big, MaxBytes+1); err != nil {
+		t.Fatal(err)
+	}
+
+	bigData := "data:image/png;base64," + base64.StdEncoding.EncodeToString(make([]byte, MaxBytes+1))
+	for ref, want := range map[string]string{
+		bigData: "over the 20 MB limit",
+		"data:image/png," + strings.Repeat("x", MaxBytes+1): "over the 20 MB limit",
+		text:                           "unsupported type text/plain",
+		big:                            "over the 20 MB limit",
+		dir:                            "is a directory",
+		filepath.Join(dir, "none.png"): "no such file",
+		"data:text/html;base64,PGI+":   "unsupported type text/html",
+	} {
+		if _, err := Load(ref); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Load(%.60s) error = %v; want %q", ref, err, want)
+		}
+	}
+}
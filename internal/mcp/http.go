@@ -0,0 +1,168 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"q/internal/httpclient"
+)
+
+// Headers of the streamable HTTP transport.
+const (
+	sessionHeader  = "Mcp-Session-Id"
+	protocolHeader = "MCP-Protocol-Version"
+)
+
+// streamableHTTP is the transport to a server at a URL: each message is
+// POSTed, and responses come back as JSON or as a stream of server-sent
+// events.
+type streamableHTTP struct {
+	url     string
+	headers map[string]string
+	client  httpclient.HTTPClient
+
+	protocol string // negotiated version, sent after initialization
+
+	mu      sync.Mutex
+	session string
+}
+
+func newStreamableHTTP(url string, headers map[string]string, client httpclient.HTTPClient) *streamableHTTP {
+	return &streamableHTTP{url: url, headers: headers, client: client}
+}
+
+func (t *streamableHTTP) request(ctx context.Context, method string, m *message) (*http.Response, error) {
+	var body io.Reader
+	if m != nil {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.url, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if t.protocol != "" {
+		req.Header.Set(protocolHeader, t.protocol)
+	}
+	t.mu.Lock()
+	session := t.session
+	t.mu.Unlock()
+	if session != "" {
+		req.Header.Set(sessionHeader, session)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if s := resp.Header.Get(sessionHeader); s != "" {
+		t.mu.Lock()
+		t.session = s
+		t.mu.Unlock()
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if resp.StatusCode == http.StatusNotFound && session != "" {
+			return nil, fmt.Errorf("session expired (HTTP 404); restart q chat to reconnect")
+		}
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return resp, nil
+}
+
+func (t *streamableHTTP) call(ctx context.Context, req *message) (*message, error) {
+	resp, err := t.request(ctx, http.MethodPost, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if ct != "text/event-stream" {
+		var m message
+		if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+			return nil, fmt.Errorf("invalid response: %w", err)
+		}
+		return &m, nil
+	}
+
+	// The stream may carry requests and notifications before the response.
+	events := bufio.NewReader(resp.Body)
+	for {
+		data, err := readEvent(events)
+		if len(data) > 0 {
+			var m message
+			if json.Unmarshal(data, &m) == nil {
+				switch {
+				case m.isResponse() && string(m.ID) == string(req.ID):
+					return &m, nil
+				case m.ID != nil && m.Method != "":
+					if r, err := t.request(ctx, http.MethodPost, reply(&m)); err == nil {
+						r.Body.Close()
+					}
+				}
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("%s: stream ended without a response", req.Method)
+			}
+			return nil, err
+		}
+	}
+}
+
+// readEvent returns the data of the next server-sent event.
+func readEvent(r *bufio.Reader) ([]byte, error) {
+	var data [][]byte
+	for {
+		line, err := r.ReadBytes('\n')
+		line = bytes.TrimRight(line, "\r\n")
+		if d, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			data = append(data, bytes.TrimPrefix(d, []byte(" ")))
+		}
+		if len(line) == 0 && len(data) > 0 || err != nil {
+			return bytes.Join(data, []byte("\n")), err
+		}
+	}
+}
+
+func (t *streamableHTTP) notify(ctx context.Context, n *message) error {
+	resp, err := t.request(ctx, http.MethodPost, n)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// close ends the session, if the server keeps one.
+func (t *streamableHTTP) close() error {
+	t.mu.Lock()
+	session := t.session
+	t.mu.Unlock()
+	if session == "" {
+		return nil
+	}
+	resp, err := t.request(context.Background(), http.MethodDelete, nil)
+	if err != nil {
+		return nil // servers may not allow ending sessions
+	}
+	return resp.Body.Close()
+}
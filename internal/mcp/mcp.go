@@ -0,0 +1,356 @@
+// Package mcp is a client for the Model Context Protocol, which lets q chat
+// use the tools, resources and prompts of external servers. Servers are
+// started as commands speaking JSON-RPC over stdin and stdout, or reached
+// over streamable HTTP.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"q/internal/config"
+)
+
+// ProtocolVersion is the protocol revision q asks for.
+const ProtocolVersion = "2025-06-18"
+
+// supportedVersions are the revisions q can speak, newest first.
+var supportedVersions = []string{ProtocolVersion, "2025-03-26", "2024-11-05"}
+
+// ClientVersion is reported to servers along with the name "q".
+var ClientVersion = "dev"
+
+// Error is a JSON-RPC error returned by a server.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("%s (code %d)", e.Message, e.Code) }
+
+// JSON-RPC error codes.
+const (
+	codeMethodNotFound = -32601
+)
+
+// message is a JSON-RPC request, notification or response.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+func (m *message) isResponse() bool { return m.Method == "" && m.ID != nil }
+
+// reply answers a request the server sent. Only pings are supported; q
+// offers no sampling, roots or elicitation.
+func reply(req *message) *message {
+	out := &message{JSONRPC: "2.0", ID: req.ID}
+	if req.Method == "ping" {
+		out.Result = json.RawMessage("{}")
+	} else {
+		out.Error = &Error{Code: codeMethodNotFound, Message: "method not supported by q: " + req.Method}
+	}
+	return out
+}
+
+// transport carries messages to and from a server.
+type transport interface {
+	// call sends a request and returns the response with its ID.
+	call(ctx context.Context, req *message) (*message, error)
+	// notify sends a notification.
+	notify(ctx context.Context, n *message) error
+	close() error
+}
+
+// Tool is a function a server offers.
+type Tool struct {
+	Name        string          `json:"name"`
+	Title       string          `json:"title,omitempty"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"inputSchema,omitempty"`
+}
+
+// Resource is data a server can be asked for by URI.
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	MIMEType    string `json:"mimeType,omitempty"`
+}
+
+// Prompt is a message template a server offers.
+type Prompt struct {
+	Name        string           `json:"name"`
+	Title       string           `json:"title,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Arguments   []PromptArgument `json:"arguments,omitempty"`
+}
+
+// PromptArgument is a value a Prompt is filled in with.
+type PromptArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+// PromptMessage is a message of a filled-in Prompt.
+type PromptMessage struct {
+	Role    string  `json:"role"` // "user" or "assistant"
+	Content Content `json:"content"`
+}
+
+// Content is part of a tool result or prompt message.
+type Content struct {
+	Type     string            `json:"type"` // text, image, audio, resource or resource_link
+	Text     string            `json:"text,omitempty"`
+	MIMEType string            `json:"mimeType,omitempty"`
+	Data     string            `json:"data,omitempty"` // base64, for images and audio
+	URI      string            `json:"uri,omitempty"`  // for resource links
+	Resource *ResourceContents `json:"resource,omitempty"`
+}
+
+// String returns c as text; binary content is only described.
+func (c Content) String() string {
+	switch c.Type {
+	case "text":
+		return c.Text
+	case "resource":
+		if c.Resource != nil {
+			return c.Resource.String()
+		}
+	case "resource_link":
+		return "[resource " + c.URI + "]"
+	}
+	return fmt.Sprintf("[%s content, %s]", c.Type, c.MIMEType)
+}
+
+// ResourceContents is the data of a resource, as text or base64 bytes.
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MIMEType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
+}
+
+// String returns r's text, or describes its binary data.
+func (r ResourceContents) String() string {
+	if r.Blob != "" {
+		return fmt.Sprintf("[binary resource %s, %s]", r.URI, r.MIMEType)
+	}
+	return r.Text
+}
+
+// ToolResult is what a tool call returned.
+type ToolResult struct {
+	Content           []Content       `json:"content"`
+	StructuredContent json.RawMessage `json:"structuredContent,omitempty"`
+	IsError           bool            `json:"isError,omitempty"`
+}
+
+// Text returns the result as text for the model.
+func (r *ToolResult) Text() string {
+	var parts []string
+	for _, c := range r.Content {
+		parts = append(parts, c.String())
+	}
+	if len(parts) == 0 && len(r.StructuredContent) > 0 {
+		parts = append(parts, string(r.StructuredContent))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// ServerInfo names a server's implementation.
+type ServerInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Client is a connection to one server.
+type Client struct {
+	Name         string     // as declared in the config
+	Server       ServerInfo // as the server reports it
+	Instructions string     // how to use the server, for the model
+
+	t            transport
+	nextID       atomic.Int64
+	capabilities map[string]json.RawMessage
+}
+
+// Connect starts or reaches the server declared as cfg and performs the
+// protocol handshake.
+func Connect(ctx context.Context, name string, cfg config.MCPServerConfig) (*Client, error) {
+	var (
+		t   transport
+		err error
+	)
+	if cfg.URL != "" {
+		t = newStreamableHTTP(cfg.URL, cfg.Headers, http.DefaultClient)
+	} else if t, err = startStdio(cfg.Command, cfg.Args, cfg.Env); err != nil {
+		return nil, fmt.Errorf("mcp server %s: %w", name, err)
+	}
+	c := &Client{Name: name, t: t}
+	if err := c.initialize(ctx); err != nil {
+		t.close()
+		return nil, fmt.Errorf("mcp server %s: %w", name, err)
+	}
+	return c, nil
+}
+
+func (c *Client) initialize(ctx context.Context) error {
+	var res struct {
+		ProtocolVersion string                     `json:"protocolVersion"`
+		Capabilities    map[string]json.RawMessage `json:"capabilities"`
+		ServerInfo      ServerInfo                 `json:"serverInfo"`
+		Instructions    string                     `json:"instructions"`
+	}
+	err := c.call(ctx, "initialize", map[string]any{
+		"protocolVersion": ProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]string{"name": "q", "version": ClientVersion},
+	}, &res)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(supportedVersions, res.ProtocolVersion) {
+		return fmt.Errorf("unsupported protocol version %q", res.ProtocolVersion)
+	}
+	if h, ok := c.t.(*streamableHTTP); ok {
+		h.protocol = res.ProtocolVersion
+	}
+	c.Server, c.Instructions, c.capabilities = res.ServerInfo, res.Instructions, res.Capabilities
+	return c.t.notify(ctx, &message{JSONRPC: "2.0", Method: "notifications/initialized"})
+}
+
+// call sends a request and decodes its result into result.
+func (c *Client) call(ctx context.Context, method string, params, result any) error {
+	req := &message{JSONRPC: "2.0", ID: json.RawMessage(strconv.FormatInt(c.nextID.Add(1), 10)), Method: method}
+	if params != nil {
+		var err error
+		if req.Params, err = json.Marshal(params); err != nil {
+			return err
+		}
+	}
+	resp, err := c.t.call(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s: %w", method, resp.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("%s: invalid result: %w", method, err)
+	}
+	return nil
+}
+
+// Has reports whether the server offers a capability: "tools", "resources"
+// or "prompts".
+func (c *Client) Has(capability string) bool {
+	_, ok := c.capabilities[capability]
+	return ok
+}
+
+// list fetches every page of a list method, whose results are under key.
+func list[T any](ctx context.Context, c *Client, method, key string) ([]T, error) {
+	var (
+		all    []T
+		cursor string
+	)
+	for {
+		var params any
+		if cursor != "" {
+			params = map[string]string{"cursor": cursor}
+		}
+		var page map[string]json.RawMessage
+		if err := c.call(ctx, method, params, &page); err != nil {
+			return nil, err
+		}
+		var items []T
+		if err := json.Unmarshal(page[key], &items); err != nil && page[key] != nil {
+			return nil, fmt.Errorf("%s: invalid result: %w", method, err)
+		}
+		all = append(all, items...)
+		cursor = ""
+		if next := page["nextCursor"]; next != nil {
+			json.Unmarshal(next, &cursor)
+		}
+		if cursor == "" {
+			return all, nil
+		}
+	}
+}
+
+// Tools returns the server's tools, if it offers any.
+func (c *Client) Tools(ctx context.Context) ([]Tool, error) {
+	if !c.Has("tools") {
+		return nil, nil
+	}
+	return list[Tool](ctx, c, "tools/list", "tools")
+}
+
+// Resources returns the server's resources, if it offers any.
+func (c *Client) Resources(ctx context.Context) ([]Resource, error) {
+	if !c.Has("resources") {
+		return nil, nil
+	}
+	return list[Resource](ctx, c, "resources/list", "resources")
+}
+
+// Prompts returns the server's prompts, if it offers any.
+func (c *Client) Prompts(ctx context.Context) ([]Prompt, error) {
+	if !c.Has("prompts") {
+		return nil, nil
+	}
+	return list[Prompt](ctx, c, "prompts/list", "prompts")
+}
+
+// CallTool calls a tool with arguments, a JSON object. A tool that fails
+// reports it in the result, with IsError set.
+func (c *Client) CallTool(ctx context.Context, name string, arguments json.RawMessage) (*ToolResult, error) {
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+	if !json.Valid(arguments) || !strings.HasPrefix(strings.TrimSpace(string(arguments)), "{") {
+		return nil, errors.New("tool arguments are not a JSON object")
+	}
+	var res ToolResult
+	err := c.call(ctx, "tools/call", map[string]any{"name": name, "arguments": arguments}, &res)
+	return &res, err
+}
+
+// ReadResource returns the contents of the resource at uri.
+func (c *Client) ReadResource(ctx context.Context, uri string) ([]ResourceContents, error) {
+	var res struct {
+		Contents []ResourceContents `json:"contents"`
+	}
+	err := c.call(ctx, "resources/read", map[string]string{"uri": uri}, &res)
+	return res.Contents, err
+}
+
+// GetPrompt returns the messages of a prompt filled in with args.
+func (c *Client) GetPrompt(ctx context.Context, name string, args map[string]string) ([]PromptMessage, error) {
+	var res struct {
+		Messages []PromptMessage `json:"messages"`
+	}
+	err := c.call(ctx, "prompts/get", map[string]any{"name": name, "arguments": args}, &res)
+	return res.Messages, err
+}
+
+// Close ends the session, stopping the server if q started it.
+func (c *Client) Close() error { return c.t.close() }
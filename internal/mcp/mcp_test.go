@@ -0,0 +1,222 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"q/internal/config"
+)
+
+// handle is a fake server's answer to a request: a "notes" server with one
+// tool, two pages of resources and no prompts.
+func handle(m *message) *message {
+	out := &message{JSONRPC: "2.0", ID: m.ID}
+	var params struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+		Cursor    string          `json:"cursor"`
+		URI       string          `json:"uri"`
+	}
+	json.Unmarshal(m.Params, &params)
+	var result any
+	switch m.Method {
+	case "initialize":
+		result = map[string]any{
+			"protocolVersion": ProtocolVersion,
+			"capabilities":    map[string]any{"tools": map[string]any{}, "resources": map[string]any{}},
+			"serverInfo":      ServerInfo{Name: "notes", Version: "1.0"},
+			"instructions":    "Search before you read.",
+		}
+	case "tools/list":
+		result = map[string]any{"tools": []Tool{{Name: "search", Description: "Search notes", InputSchema: json.RawMessage(`{"type":"object"}`)}}}
+	case "tools/call":
+		var args struct{ Query string }
+		json.Unmarshal(params.Arguments, &args)
+		if args.Query == "" {
+			result = ToolResult{Content: []Content{{Type: "text", Text: "query is required"}}, IsError: true}
+		} else {
+			result = ToolResult{Content: []Content{{Type: "text", Text: "found " + args.Query}, {Type: "image", MIMEType: "image/png", Data: "iVBO"}}}
+		}
+	case "resources/list":
+		if params.Cursor == "" {
+			result = map[string]any{"resources": []Resource{{URI: "notes://1", Name: "one"}}, "nextCursor": "2"}
+		} else {
+			result = map[string]any{"resources": []Resource{{URI: "notes://2", Name: "two"}}}
+		}
+	case "resources/read":
+		result = map[string]any{"contents": []ResourceContents{{URI: params.URI, Text: "contents of " + params.URI}}}
+	case "crash":
+		os.Exit(3)
+	default:
+		out.Error = &Error{Code: codeMethodNotFound, Message: "no method " + m.Method}
+		return out
+	}
+	out.Result, _ = json.Marshal(result)
+	return out
+}
+
+// TestHelperServer is not a test: it runs the fake server over stdin and
+// stdout when started by startStdio in the tests below.
+func TestHelperServer(t *testing.T) {
+	if os.Getenv("MCP_TEST_SERVER") != "1" {
+		t.Skip("helper process")
+	}
+	fmt.Fprintln(os.Stderr, "notes server starting")
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		var m message
+		if json.Unmarshal(sc.Bytes(), &m) != nil || m.ID == nil {
+			continue
+		}
+		if m.Method == "tools/call" {
+			// Ping the client first, as servers may.
+			fmt.Println(`{"jsonrpc":"2.0","id":"p1","method":"ping"}`)
+			if !sc.Scan() || !strings.Contains(sc.Text(), `"id":"p1"`) {
+				os.Exit(4)
+			}
+		}
+		data, _ := json.Marshal(handle(&m))
+		fmt.Println("log: not JSON") // ignored by the client
+		fmt.Println(string(data))
+	}
+	os.Exit(0)
+}
+
+func helperConfig() config.MCPServerConfig {
+	return config.MCPServerConfig{
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^TestHelperServer$"},
+		Env:     map[string]string{"MCP_TEST_SERVER": "1"},
+	}
+}
+
+func testClient(t *testing.T, c *Client) {
+	t.Helper()
+	ctx := context.Background()
+	if c.Server.Name != "notes" || c.Instructions == "" || !c.Has("tools") || c.Has("prompts") {
+		t.Errorf("handshake: %+v", c)
+	}
+
+	tools, err := c.Tools(ctx)
+	if err != nil || len(tools) != 1 || tools[0].Name != "search" || string(tools[0].InputSchema) != `{"type":"object"}` {
+		t.Errorf("Tools = %+v, %v", tools, err)
+	}
+	resources, err := c.Resources(ctx)
+	if err != nil || len(resources) != 2 || resources[1].URI != "notes://2" {
+		t.Errorf("Resources = %+v, %v; want both pages", resources, err)
+	}
+	if prompts, err := c.Prompts(ctx); prompts != nil || err != nil {
+		t.Errorf("Prompts of a server without them = %+v, %v", prompts, err)
+	}
+
+	res, err := c.CallTool(ctx, "search", json.RawMessage(`{"query":"mcp"}`))
+	if err != nil || res.IsError || res.Text() != "found mcp\n[image content, image/png]" {
+		t.Errorf("CallTool = %+v, %v", res, err)
+	}
+	if res, err := c.CallTool(ctx, "search", nil); err != nil || !res.IsError {
+		t.Errorf("failing CallTool = %+v, %v; want IsError", res, err)
+	}
+	if _, err := c.CallTool(ctx, "search", json.RawMessage(`[1]`)); err == nil {
+		t.Error("CallTool with array arguments succeeded")
+	}
+
+	contents, err := c.ReadResource(ctx, "notes://1")
+	if err != nil || len(contents) != 1 || contents[0].String() != "contents of notes://1" {
+		t.Errorf("ReadResource = %+v, %v", contents, err)
+	}
+	if _, err := c.GetPrompt(ctx, "nope", nil); err == nil || !strings.Contains(err.Error(), "no method") {
+		t.Errorf("GetPrompt error = %v", err)
+	}
+}
+
+func TestStdio(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := Connect(ctx, "notes", helperConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	testClient(t, c)
+
+	err = c.call(ctx, "crash", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "server exited") || !strings.Contains(err.Error(), "notes server starting") {
+		t.Errorf("call to a crashed server: %v; want the exit and its stderr", err)
+	}
+}
+
+func TestStdio_Cancel(t *testing.T) {
+	// A server that never answers.
+	r, w := io.Pipe()
+	defer w.Close()
+	tr := newStdio(nopCloser{io.Discard}, r)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := tr.call(ctx, &message{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: "tools/list"}); err != context.DeadlineExceeded {
+		t.Errorf("call error = %v; want the deadline", err)
+	}
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestStreamableHTTP(t *testing.T) {
+	var deleted bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			deleted = r.Header.Get(sessionHeader) == "s-1"
+			return
+		}
+		var m message
+		json.NewDecoder(r.Body).Decode(&m)
+		if m.Method != "initialize" && (r.Header.Get(sessionHeader) != "s-1" || r.Header.Get(protocolHeader) != ProtocolVersion) {
+			http.Error(w, "no session", http.StatusBadRequest)
+			return
+		}
+		if m.ID == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		resp, _ := json.Marshal(handle(&m))
+		switch m.Method {
+		case "initialize":
+			w.Header().Set(sessionHeader, "s-1")
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(resp)
+		default:
+			// Answer in a stream, after a notification.
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n")
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", resp)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	if _, err := Connect(ctx, "notes", config.MCPServerConfig{URL: srv.URL}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Connect without the header: %v", err)
+	}
+	c, err := Connect(ctx, "notes", config.MCPServerConfig{URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testClient(t, c)
+	c.Close()
+	if !deleted {
+		t.Error("Close did not end the session")
+	}
+}
@@ -0,0 +1,194 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stderrTail is how much of a server's stderr is kept for error messages.
+const stderrTail = 2048
+
+// stdio is the transport to a server q starts: newline-delimited JSON-RPC
+// messages over its stdin and stdout.
+type stdio struct {
+	cmd    *exec.Cmd // nil if q did not start the server
+	stderr *tail
+
+	wmu sync.Mutex
+	w   io.WriteCloser
+
+	mu      sync.Mutex
+	pending map[string]chan *message
+	err     error         // why reading stopped
+	done    chan struct{} // closed when reading stops
+}
+
+func startStdio(command string, args []string, env map[string]string) (*stdio, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	stderr := &tail{}
+	cmd.Stderr = stderr
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	t := newStdio(w, r)
+	t.cmd, t.stderr = cmd, stderr
+	return t, nil
+}
+
+// newStdio returns a transport writing to w and reading from r.
+func newStdio(w io.WriteCloser, r io.Reader) *stdio {
+	t := &stdio{w: w, pending: map[string]chan *message{}, done: make(chan struct{})}
+	go t.read(r)
+	return t
+}
+
+func (t *stdio) read(r io.Reader) {
+	br := bufio.NewReader(r)
+	var err error
+	for {
+		var line []byte
+		if line, err = br.ReadBytes('\n'); len(bytes.TrimSpace(line)) > 0 {
+			var m message
+			if json.Unmarshal(line, &m) != nil {
+				continue // not a message; some servers log to stdout
+			}
+			switch {
+			case m.isResponse():
+				t.mu.Lock()
+				ch := t.pending[string(m.ID)]
+				delete(t.pending, string(m.ID))
+				t.mu.Unlock()
+				if ch != nil {
+					ch <- &m
+				}
+			case m.ID != nil:
+				t.write(reply(&m))
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == io.EOF {
+		err = errors.New("server exited")
+	}
+	if t.stderr != nil {
+		if s := strings.TrimSpace(t.stderr.String()); s != "" {
+			err = fmt.Errorf("%w: %s", err, s)
+		}
+	}
+	t.mu.Lock()
+	t.err = err
+	t.mu.Unlock()
+	close(t.done)
+}
+
+func (t *stdio) write(m *message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	_, err = t.w.Write(append(data, '\n'))
+	return err
+}
+
+func (t *stdio) call(ctx context.Context, req *message) (*message, error) {
+	ch := make(chan *message, 1)
+	t.mu.Lock()
+	if t.err != nil {
+		t.mu.Unlock()
+		return nil, t.err
+	}
+	t.pending[string(req.ID)] = ch
+	t.mu.Unlock()
+	forget := func() {
+		t.mu.Lock()
+		delete(t.pending, string(req.ID))
+		t.mu.Unlock()
+	}
+
+	if err := t.write(req); err != nil {
+		forget()
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		forget()
+		params, _ := json.Marshal(map[string]any{"requestId": req.ID, "reason": ctx.Err().Error()})
+		t.write(&message{JSONRPC: "2.0", Method: "notifications/cancelled", Params: params})
+		return nil, ctx.Err()
+	case <-t.done:
+		return nil, t.err
+	}
+}
+
+func (t *stdio) notify(_ context.Context, n *message) error { return t.write(n) }
+
+// close closes the server's stdin, which asks it to exit, and kills it if it
+// has not within a few seconds.
+func (t *stdio) close() error {
+	t.w.Close()
+	if t.cmd == nil {
+		return nil
+	}
+	exited := make(chan struct{})
+	go func() {
+		t.cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(3 * time.Second):
+		t.cmd.Process.Kill()
+		<-exited
+	}
+	return nil
+}
+
+// tail is a writer keeping the last stderrTail bytes written to it.
+type tail struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > stderrTail {
+		t.buf = t.buf[len(t.buf)-stderrTail:]
+	}
+	return len(p), nil
+}
+
+func (t *tail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
@@ -0,0 +1,89 @@
+// Package modelcache remembers the model lists fetched from provider APIs,
+// in $XDG_CACHE_HOME/q/models.json.
+package modelcache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"q/internal/config"
+	"q/internal/fsutil"
+)
+
+// DefaultTTL is how long a fetched model list is considered current.
+const DefaultTTL = 24 * time.Hour
+
+// Entry is one provider's cached model list.
+type Entry struct {
+	FetchedAt time.Time `json:"fetched_at"`
+	Models    []string  `json:"models"`
+}
+
+// Cache is a file of Entries keyed by provider (and base URL, when one is
+// configured, since a gateway may offer different models).
+type Cache struct {
+	path string
+	ttl  time.Duration
+	now  func() time.Time
+}
+
+// New returns a cache stored at path.
+func New(path string, ttl time.Duration) *Cache {
+	return &Cache{path: path, ttl: ttl, now: time.Now}
+}
+
+// Open returns the default cache in config.CacheDir.
+func Open() (*Cache, error) {
+	dir, err := config.CacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return New(filepath.Join(dir, "models.json"), DefaultTTL), nil
+}
+
+// Key identifies a provider's list. baseURL is the configured API base URL,
+// or empty for the provider's default.
+func Key(provider, baseURL string) string {
+	if baseURL == "" {
+		return provider
+	}
+	return provider + " " + baseURL
+}
+
+func (c *Cache) load() map[string]Entry {
+	entries := make(map[string]Entry)
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return entries
+	}
+	// A corrupt cache is as good as an empty one.
+	_ = json.Unmarshal(data, &entries)
+	return entries
+}
+
+// Get returns the cached entry for key, whatever its age.
+func (c *Cache) Get(key string) (Entry, bool) {
+	e, ok := c.load()[key]
+	return e, ok
+}
+
+// Fresh reports whether e is younger than the cache's TTL.
+func (c *Cache) Fresh(e Entry) bool {
+	return c.now().Sub(e.FetchedAt) < c.ttl
+}
+
+// Put stores models under key, stamped with the current time.
+func (c *Cache) Put(key string, models []string) error {
+	entries := c.load()
+	entries[key] = Entry{FetchedAt: c.now().UTC(), Models: models}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(c.path, data, 0o600)
+}
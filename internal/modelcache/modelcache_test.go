@@ -0,0 +1,58 @@
+package modelcache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCache_PutGetFresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "models.json")
+	c := New(path, time.Hour)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	if _, ok := c.Get("openai"); ok {
+		t.Fatal("Get on empty cache reported an entry")
+	}
+	if err := c.Put("openai", []string{"gpt-5", "gpt-4o"}); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	if err := c.Put(Key("openai", "https://gw.example.com/v1"), []string{"llama"}); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+
+	e, ok := c.Get("openai")
+	if !ok || !reflect.DeepEqual(e.Models, []string{"gpt-5", "gpt-4o"}) {
+		t.Errorf("Get = %+v, %v", e, ok)
+	}
+	if e, _ := c.Get("openai https://gw.example.com/v1"); len(e.Models) != 1 {
+		t.Errorf("gateway entry = %+v; want its own list", e)
+	}
+	if !c.Fresh(e) {
+		t.Error("new entry is not fresh")
+	}
+	now = now.Add(2 * time.Hour)
+	if c.Fresh(e) {
+		t.Error("entry older than the TTL is still fresh")
+	}
+}
+
+func TestCache_CorruptFileIsEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := New(path, time.Hour)
+	if _, ok := c.Get("openai"); ok {
+		t.Error("corrupt cache reported an entry")
+	}
+	if err := c.Put("openai", []string{"gpt-4o"}); err != nil {
+		t.Fatalf("Put over corrupt cache: %v", err)
+	}
+	if e, ok := c.Get("openai"); !ok || len(e.Models) != 1 {
+		t.Errorf("Get after Put = %+v, %v", e, ok)
+	}
+}
@@ -0,0 +1,152 @@
+package providers
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Modalities a model may accept or produce.
+const (
+	ModalityText  = "text"
+	ModalityImage = "image"
+	ModalityAudio = "audio"
+)
+
+// Modalities lists every known modality.
+var Modalities = []string{ModalityText, ModalityImage, ModalityAudio}
+
+// ModelInfo describes a model's limits, capabilities and prices. Zero and nil
+// fields mean unknown, and unknown never causes a request to be rejected.
+type ModelInfo struct {
+	ContextWindow int      `json:"context_window,omitempty"` // tokens, prompt plus output
+	MaxOutput     int      `json:"max_output,omitempty"`     // tokens
+	Input         []string `json:"input,omitempty"`          // accepted modalities
+	Output        []string `json:"output,omitempty"`         // produced modalities
+	Encoding      string   `json:"encoding,omitempty"`       // tokenizer, e.g. "o200k_base"
+
+	Tools    *bool `json:"tools,omitempty"`     // function calling
+	JSONMode *bool `json:"json_mode,omitempty"` // structured JSON output
+	Sampling *bool `json:"sampling,omitempty"`  // accepts temperature and top_p
+
+	// API is the transport the model needs, e.g. "responses" for OpenAI
+	// models only served by the Responses API; see Request.API.
+	API string `json:"api,omitempty"`
+
+	Pricing *Pricing `json:"pricing,omitempty"`
+}
+
+// Pricing is in US dollars per million tokens.
+type Pricing struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// Cataloger is implemented by providers that know the limits and prices of
+// their models.
+type Cataloger interface {
+	ModelInfo(model string) (ModelInfo, bool)
+}
+
+// Merge returns m with every field set in o replacing its own.
+func (m ModelInfo) Merge(o ModelInfo) ModelInfo {
+	if o.ContextWindow != 0 {
+		m.ContextWindow = o.ContextWindow
+	}
+	if o.MaxOutput != 0 {
+		m.MaxOutput = o.MaxOutput
+	}
+	if o.Input != nil {
+		m.Input = o.Input
+	}
+	if o.Output != nil {
+		m.Output = o.Output
+	}
+	if o.Encoding != "" {
+		m.Encoding = o.Encoding
+	}
+	if o.Tools != nil {
+		m.Tools = o.Tools
+	}
+	if o.JSONMode != nil {
+		m.JSONMode = o.JSONMode
+	}
+	if o.Sampling != nil {
+		m.Sampling = o.Sampling
+	}
+	if o.API != "" {
+		m.API = o.API
+	}
+	if o.Pricing != nil {
+		m.Pricing = o.Pricing
+	}
+	return m
+}
+
+// Accepts reports whether the model takes modality as input. A model with
+// unknown inputs is assumed to accept anything.
+func (m ModelInfo) Accepts(modality string) bool {
+	return m.Input == nil || slices.Contains(m.Input, modality)
+}
+
+// Cost returns the price in US dollars of a request, and false if the
+// model's prices are unknown.
+func (m ModelInfo) Cost(inputTokens, outputTokens int) (float64, bool) {
+	if m.Pricing == nil {
+		return 0, false
+	}
+	return (float64(inputTokens)*m.Pricing.Input + float64(outputTokens)*m.Pricing.Output) / 1e6, true
+}
+
+// Check rejects a request the model is known not to support, before it is
+// sent. promptTokens is the size of the system prompt and messages.
+func (m ModelInfo) Check(provider string, req Request, promptTokens int) error {
+	id := provider + "/" + req.Model
+	if !m.Accepts(ModalityImage) && slices.ContainsFunc(req.Messages, func(msg Message) bool { return len(msg.Images) > 0 }) {
+		return fmt.Errorf("%s does not accept images\n\nSee models that do: q models list --long", id)
+	}
+	if m.Tools != nil && !*m.Tools && len(req.Tools) > 0 {
+		return fmt.Errorf("%s does not support function calling, so it cannot use tools", id)
+	}
+	if m.Sampling != nil && !*m.Sampling && (req.Params.Temperature != nil || req.Params.TopP != nil) {
+		return fmt.Errorf("%s does not accept temperature or top_p\n\nRemove them from the template or request", id)
+	}
+	if m.MaxOutput > 0 && req.Params.MaxTokens > m.MaxOutput {
+		return fmt.Errorf("max_tokens %d is over the %d-token output limit of %s", req.Params.MaxTokens, m.MaxOutput, id)
+	}
+	if m.ContextWindow > 0 && promptTokens+req.Params.MaxTokens > m.ContextWindow {
+		return &ContextLengthExceededError{
+			Provider:  provider,
+			Limit:     m.ContextWindow,
+			Requested: promptTokens + req.Params.MaxTokens,
+		}
+	}
+	return nil
+}
+
+// EstimateText returns a rough token count of s, at about four bytes per
+// token.
+func EstimateText(s string) int { return (len(s) + 3) / 4 }
+
+// ImageTokens is a rough token cost of an image: OpenAI's for a 1024x1024
+// image at high detail. Actual costs depend on the image size.
+const ImageTokens = 765
+
+// EstimateTokens returns a rough token count of the system prompt and
+// messages of req, with a few tokens of framing per message.
+func EstimateTokens(req Request) int {
+	const perMessage = 4
+	n := 0
+	if req.System != "" {
+		n += EstimateText(req.System) + perMessage
+	}
+	for _, m := range req.Messages {
+		n += EstimateText(m.Content) + perMessage + len(m.Images)*ImageTokens
+		for _, c := range m.ToolCalls {
+			n += EstimateText(c.Name) + EstimateText(c.Arguments)
+		}
+	}
+	for _, t := range req.Tools {
+		n += EstimateText(t.Name) + EstimateText(t.Description) + EstimateText(string(t.Parameters))
+	}
+	return n
+}
@@ -0,0 +1,78 @@
+package providers_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"q/internal/providers"
+)
+
+func flag(b bool) *bool { return &b }
+
+func TestModelInfoMerge(t *testing.T) {
+	base := providers.ModelInfo{
+		ContextWindow: 128000, MaxOutput: 16384,
+		Input: []string{"text", "image"}, Tools: flag(true),
+		Pricing: &providers.Pricing{Input: 2.5, Output: 10},
+	}
+	got := base.Merge(providers.ModelInfo{ContextWindow: 32000, Tools: flag(false)})
+	want := base
+	want.ContextWindow, want.Tools = 32000, flag(false)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge = %+v; want %+v", got, want)
+	}
+}
+
+func TestModelInfoCheck(t *testing.T) {
+	temp := 0.5
+	info := providers.ModelInfo{ContextWindow: 100, MaxOutput: 40, Sampling: flag(false)}
+	req := func(p providers.Params) providers.Request {
+		return providers.Request{Model: "m", Params: p}
+	}
+
+	if err := info.Check("x", req(providers.Params{MaxTokens: 40}), 60); err != nil {
+		t.Errorf("request that fits: %v", err)
+	}
+	if err := info.Check("x", req(providers.Params{Temperature: &temp}), 10); err == nil || !strings.Contains(err.Error(), "temperature") {
+		t.Errorf("temperature on a fixed-sampling model: %v", err)
+	}
+	noTools := providers.ModelInfo{Tools: flag(false)}
+	if err := noTools.Check("x", providers.Request{Model: "m", Tools: []providers.Tool{{Name: "f"}}}, 10); err == nil || !strings.Contains(err.Error(), "function calling") {
+		t.Errorf("tools on a model without function calling: %v", err)
+	}
+	if err := info.Check("x", req(providers.Params{MaxTokens: 41}), 10); err == nil || !strings.Contains(err.Error(), "output limit") {
+		t.Errorf("max_tokens over the output limit: %v", err)
+	}
+
+	var ctxErr *providers.ContextLengthExceededError
+	err := info.Check("x", req(providers.Params{MaxTokens: 20}), 90)
+	if !errors.As(err, &ctxErr) || ctxErr.Limit != 100 || ctxErr.Requested != 110 {
+		t.Errorf("over the context window: %v", err)
+	}
+
+	if err := (providers.ModelInfo{}).Check("x", req(providers.Params{Temperature: &temp, MaxTokens: 1 << 20}), 1<<30); err != nil {
+		t.Errorf("unknown limits should not reject: %v", err)
+	}
+}
+
+func TestModelInfoCost(t *testing.T) {
+	info := providers.ModelInfo{Pricing: &providers.Pricing{Input: 2, Output: 8}}
+	if cost, ok := info.Cost(1_000_000, 500_000); !ok || cost != 6 {
+		t.Errorf("Cost = %v, %v; want 6, true", cost, ok)
+	}
+	if _, ok := (providers.ModelInfo{}).Cost(1, 1); ok {
+		t.Error("Cost reported a price for a model without one")
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	req := providers.Request{
+		System:   strings.Repeat("a", 40),
+		Messages: []providers.Message{{Role: "user", Content: strings.Repeat("b", 401)}},
+	}
+	if got, want := providers.EstimateTokens(req), 10+4+101+4; got != want {
+		t.Errorf("EstimateTokens = %d; want %d", got, want)
+	}
+}
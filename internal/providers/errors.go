@@ -0,0 +1,157 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"q/internal/httpclient"
+)
+
+// InvalidAPIKeyError represents an invalid API key error that any provider can return
+type InvalidAPIKeyError struct {
+	Provider string
+}
+
+func (e *InvalidAPIKeyError) Error() string {
+	return fmt.Sprintf(
+		"Invalid API key for %s. Set your key with:\n  "+
+			"q keys set --provider %s --key YOUR_API_KEY",
+		e.Provider,
+		e.Provider,
+	)
+}
+
+// IsInvalidAPIKeyError checks if an error is an InvalidAPIKeyError
+func IsInvalidAPIKeyError(err error) bool {
+	var e *InvalidAPIKeyError
+	return errors.As(err, &e)
+}
+
+// apiMessage formats a vendor error message, falling back to def.
+func apiMessage(msg, def string) string {
+	if msg == "" {
+		return def
+	}
+	return "API error: " + msg
+}
+
+// RateLimitedError means the provider throttled the request.
+type RateLimitedError struct {
+	Provider   string
+	Message    string
+	RetryAfter time.Duration // zero if the provider gave no hint
+}
+
+func (e *RateLimitedError) Error() string {
+	s := apiMessage(e.Message, "rate limited by "+e.Provider)
+	if e.RetryAfter > 0 {
+		s += fmt.Sprintf(" (retry after %s)", e.RetryAfter.Round(time.Millisecond))
+	}
+	return s
+}
+
+// QuotaExceededError means the account has run out of credits or quota.
+type QuotaExceededError struct {
+	Provider string
+	Message  string
+}
+
+func (e *QuotaExceededError) Error() string {
+	return apiMessage(e.Message, "quota exceeded for "+e.Provider)
+}
+
+// ContextLengthExceededError means the request does not fit the model's
+// context window. Limit and Requested are zero when unknown.
+type ContextLengthExceededError struct {
+	Provider  string
+	Message   string
+	Limit     int
+	Requested int
+}
+
+func (e *ContextLengthExceededError) Error() string {
+	if e.Message == "" && e.Limit > 0 {
+		return fmt.Sprintf("context length exceeded: %d tokens requested, limit is %d", e.Requested, e.Limit)
+	}
+	return apiMessage(e.Message, "context length exceeded")
+}
+
+// ContentFilteredError means the prompt or response was blocked by the
+// provider's content policy.
+type ContentFilteredError struct {
+	Provider string
+	Message  string
+}
+
+func (e *ContentFilteredError) Error() string {
+	return apiMessage(e.Message, "response blocked by "+e.Provider+" content filter")
+}
+
+// ModelNotFoundError means the provider does not know the model or the key
+// has no access to it.
+type ModelNotFoundError struct {
+	Provider string
+	Model    string
+	Message  string
+}
+
+func (e *ModelNotFoundError) Error() string {
+	return apiMessage(e.Message, fmt.Sprintf("model %s/%s not found", e.Provider, e.Model))
+}
+
+// ServerError is a 5xx response, including overload responses.
+type ServerError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+	Body       string
+}
+
+func (e *ServerError) Error() string {
+	return apiMessage(e.Message, fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, e.Body))
+}
+
+// TimeoutError means the request did not complete in time.
+type TimeoutError struct {
+	Provider string
+	Err      error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("request to %s timed out: %v", e.Provider, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// APIError is any other non-success response from a provider's API.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Message    string // vendor error message, if the body could be parsed
+	Body       string // raw response body otherwise
+}
+
+func (e *APIError) Error() string {
+	return apiMessage(e.Message, fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, e.Body))
+}
+
+// IsTransient reports whether err is a rate limit, overload, server-side,
+// timeout or connection failure, where trying again or trying another model
+// may succeed.
+func IsTransient(err error) bool {
+	var (
+		rateErr    *RateLimitedError
+		serverErr  *ServerError
+		timeoutErr *TimeoutError
+		apiErr     *APIError
+	)
+	switch {
+	case errors.As(err, &rateErr), errors.As(err, &serverErr), errors.As(err, &timeoutErr):
+		return true
+	case errors.As(err, &apiErr):
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	return httpclient.IsTransientError(err)
+}
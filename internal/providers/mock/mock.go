@@ -0,0 +1,267 @@
+// Package mock is a provider that answers without a network or API key, for
+// tests, CI and scripts that exercise q end to end. Its models are:
+//
+//   - echo: repeats the last user message
+//   - fixed: always gives the same response, $Q_MOCK_RESPONSE or the
+//     contents of $Q_MOCK_FILE
+//   - script: gives the responses in $Q_MOCK_FILE in turn; they are
+//     separated by lines holding only "---"
+//   - embed: an embedding model hashing words into 64 dimensions
+//
+// Streamed responses arrive a word at a time, $Q_MOCK_RATE words per second
+// (default: no delay). $Q_MOCK_ERROR makes every request fail as the API
+// would: 401, 402, 404, 429, 500, 503, timeout, context_length or
+// content_filter; with $Q_MOCK_ERROR_AFTER, only after that many words.
+package mock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"q/internal/providers"
+)
+
+// Environment variables configuring the provider.
+const (
+	ResponseEnv   = "Q_MOCK_RESPONSE"
+	FileEnv       = "Q_MOCK_FILE"
+	RateEnv       = "Q_MOCK_RATE"
+	ErrorEnv      = "Q_MOCK_ERROR"
+	ErrorAfterEnv = "Q_MOCK_ERROR_AFTER"
+)
+
+// Models.
+const (
+	Echo   = "echo"
+	Fixed  = "fixed"
+	Script = "script"
+	Embed  = "embed"
+)
+
+// DefaultResponse is what fixed answers without $Q_MOCK_RESPONSE or
+// $Q_MOCK_FILE.
+const DefaultResponse = "This is a mock response."
+
+// embedDims is the size of the vectors of the embed model.
+const embedDims = 64
+
+var (
+	reWord      = regexp.MustCompile(`\S+\s*|\s+`)
+	reSeparator = regexp.MustCompile(`(?m)^---[ \t]*\r?\n`)
+)
+
+type provider struct {
+	getenv func(string) string
+
+	mu      sync.Mutex
+	history []providers.Message
+	turn    int // script responses given
+}
+
+func NewProvider(opts ...func(*provider)) *provider {
+	p := &provider{getenv: os.Getenv}
+	for _, o := range opts {
+		o(p)
+	}
+	return p
+}
+
+func (p *provider) Name() string              { return "mock" }
+func (p *provider) SupportedModels() []string { return []string{Echo, Fixed, Script} }
+
+// NeedsKey implements providers.KeyOptional.
+func (p *provider) NeedsKey() bool { return false }
+
+func (p *provider) Prompt(ctx context.Context, model, prompt string) (string, error) {
+	return p.Complete(ctx, providers.Request{Model: model, Messages: []providers.Message{{Role: "user", Content: prompt}}})
+}
+
+func (p *provider) Stream(ctx context.Context, model, prompt string) (string, error) {
+	return p.Complete(ctx, providers.Request{
+		Model:    model,
+		Messages: []providers.Message{{Role: "user", Content: prompt}},
+		OnDelta:  func(s string) { fmt.Print(s) },
+	})
+}
+
+func (p *provider) ChatPrompt(ctx context.Context, model, msg string) (string, error) {
+	return p.chat(ctx, model, msg, nil)
+}
+
+func (p *provider) ChatStream(ctx context.Context, model, msg string) (string, error) {
+	return p.chat(ctx, model, msg, func(s string) { fmt.Print(s) })
+}
+
+func (p *provider) chat(ctx context.Context, model, msg string, onDelta func(string)) (string, error) {
+	p.mu.Lock()
+	p.history = append(p.history, providers.Message{Role: "user", Content: msg})
+	req := providers.Request{Model: model, Messages: append([]providers.Message(nil), p.history...), OnDelta: onDelta}
+	p.mu.Unlock()
+
+	resp, err := p.Complete(ctx, req)
+	if err == nil {
+		p.mu.Lock()
+		p.history = append(p.history, providers.Message{Role: "assistant", Content: resp})
+		p.mu.Unlock()
+	}
+	return resp, err
+}
+
+func (p *provider) ResetChat() { p.mu.Lock(); p.history = nil; p.mu.Unlock() }
+
+// Complete implements providers.Completer.
+func (p *provider) Complete(ctx context.Context, r providers.Request) (string, error) {
+	resp, err := p.respond(r)
+	if err != nil {
+		return "", err
+	}
+
+	failAfter := -1
+	if p.getenv(ErrorEnv) != "" {
+		failAfter = 0
+		if s := p.getenv(ErrorAfterEnv); s != "" {
+			if failAfter, err = strconv.Atoi(s); err != nil || failAfter < 0 {
+				return "", fmt.Errorf("mock: %s=%q: want a number of words", ErrorAfterEnv, s)
+			}
+		}
+	}
+	var delay time.Duration
+	if s := p.getenv(RateEnv); s != "" {
+		rate, err := strconv.ParseFloat(s, 64)
+		if err != nil || rate <= 0 {
+			return "", fmt.Errorf("mock: %s=%q: want words per second", RateEnv, s)
+		}
+		delay = time.Duration(float64(time.Second) / rate)
+	}
+
+	var out strings.Builder
+	for i, word := range reWord.FindAllString(resp, -1) {
+		if i == failAfter {
+			return out.String(), p.injected(r.Model)
+		}
+		if delay > 0 && r.OnDelta != nil {
+			select {
+			case <-ctx.Done():
+				return out.String(), ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		if r.OnDelta != nil {
+			r.OnDelta(word)
+		}
+		out.WriteString(word)
+	}
+	if failAfter >= 0 {
+		return out.String(), p.injected(r.Model)
+	}
+	return out.String(), nil
+}
+
+// respond returns the response of model to r.
+func (p *provider) respond(r providers.Request) (string, error) {
+	switch r.Model {
+	case Echo:
+		for i := len(r.Messages) - 1; i >= 0; i-- {
+			if r.Messages[i].Role == "user" {
+				return r.Messages[i].Content, nil
+			}
+		}
+		return "", errors.New("mock: nothing to echo")
+	case Fixed:
+		if path := p.getenv(FileEnv); path != "" {
+			data, err := os.ReadFile(path)
+			return string(data), err
+		}
+		if s := p.getenv(ResponseEnv); s != "" {
+			return s, nil
+		}
+		return DefaultResponse, nil
+	case Script:
+		path := p.getenv(FileEnv)
+		if path == "" {
+			return "", fmt.Errorf("mock: %s needs $%s", Script, FileEnv)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		responses := reSeparator.Split(string(data), -1)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.turn >= len(responses) {
+			return "", fmt.Errorf("mock: script %s has only %d responses", path, len(responses))
+		}
+		p.turn++
+		return strings.TrimSuffix(responses[p.turn-1], "\n"), nil
+	}
+	return "", &providers.ModelNotFoundError{Provider: p.Name(), Model: r.Model}
+}
+
+// injected returns the error named by $Q_MOCK_ERROR.
+func (p *provider) injected(model string) error {
+	name := p.Name()
+	switch e := p.getenv(ErrorEnv); e {
+	case "401":
+		return &providers.InvalidAPIKeyError{Provider: name}
+	case "402":
+		return &providers.QuotaExceededError{Provider: name}
+	case "404":
+		return &providers.ModelNotFoundError{Provider: name, Model: model}
+	case "429":
+		return &providers.RateLimitedError{Provider: name, RetryAfter: time.Second}
+	case "timeout":
+		return &providers.TimeoutError{Provider: name, Err: context.DeadlineExceeded}
+	case "context_length":
+		return &providers.ContextLengthExceededError{Provider: name}
+	case "content_filter":
+		return &providers.ContentFilteredError{Provider: name}
+	default:
+		if code, err := strconv.Atoi(e); err == nil && code >= 500 && code < 600 {
+			return &providers.ServerError{Provider: name, StatusCode: code, Body: "mock server error"}
+		}
+		return fmt.Errorf("mock: unknown %s %q", ErrorEnv, e)
+	}
+}
+
+// EmbeddingModels implements providers.Embedder.
+func (p *provider) EmbeddingModels() []string { return []string{Embed} }
+
+// Embed implements providers.Embedder. Each word adds to one of the vector's
+// dimensions, so texts sharing words are similar.
+func (p *provider) Embed(_ context.Context, model string, inputs []string) ([][]float32, error) {
+	if model != Embed {
+		return nil, &providers.ModelNotFoundError{Provider: p.Name(), Model: model}
+	}
+	if p.getenv(ErrorEnv) != "" {
+		return nil, p.injected(model)
+	}
+	vectors := make([][]float32, len(inputs))
+	for i, s := range inputs {
+		v := make([]float32, embedDims)
+		for _, w := range strings.Fields(strings.ToLower(s)) {
+			h := fnv.New32a()
+			h.Write([]byte(strings.Trim(w, ".,;:!?\"'()[]{}")))
+			v[h.Sum32()%embedDims]++
+		}
+		var norm float64
+		for _, f := range v {
+			norm += float64(f) * float64(f)
+		}
+		if norm > 0 {
+			for j := range v {
+				v[j] /= float32(math.Sqrt(norm))
+			}
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
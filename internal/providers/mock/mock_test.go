@@ -0,0 +1,159 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"q/internal/providers"
+)
+
+func newTestProvider(env map[string]string) *provider {
+	return NewProvider(func(p *provider) {
+		p.getenv = func(k string) string { return env[k] }
+	})
+}
+
+func TestComplete_Models(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fixed := filepath.Join(dir, "fixed.txt")
+	os.WriteFile(fixed, []byte("from a file"), 0o644)
+	script := filepath.Join(dir, "script.txt")
+	os.WriteFile(script, []byte("first\n---\nsecond\nline\n---\nthird\n"), 0o644)
+
+	p := newTestProvider(nil)
+	req := providers.Request{Model: Echo, Messages: []providers.Message{
+		{Role: "user", Content: "one"}, {Role: "assistant", Content: "two"}, {Role: "user", Content: "three"},
+	}}
+	if got, err := p.Complete(ctx, req); got != "three" || err != nil {
+		t.Errorf("echo = %q, %v; want the last user message", got, err)
+	}
+	if got, _ := p.Prompt(ctx, Fixed, "hi"); got != DefaultResponse {
+		t.Errorf("fixed = %q; want %q", got, DefaultResponse)
+	}
+	if got, _ := newTestProvider(map[string]string{ResponseEnv: "canned"}).Prompt(ctx, Fixed, "hi"); got != "canned" {
+		t.Errorf("fixed with %s = %q", ResponseEnv, got)
+	}
+	if got, _ := newTestProvider(map[string]string{FileEnv: fixed}).Prompt(ctx, Fixed, "hi"); got != "from a file" {
+		t.Errorf("fixed with %s = %q", FileEnv, got)
+	}
+
+	p = newTestProvider(map[string]string{FileEnv: script})
+	for _, want := range []string{"first", "second\nline", "third"} {
+		if got, err := p.Prompt(ctx, Script, "hi"); got != want || err != nil {
+			t.Errorf("script = %q, %v; want %q", got, err, want)
+		}
+	}
+	if _, err := p.Prompt(ctx, Script, "hi"); err == nil {
+		t.Error("script past its end succeeded")
+	}
+
+	var notFound *providers.ModelNotFoundError
+	if _, err := p.Prompt(ctx, "gpt-4o", "hi"); !errors.As(err, &notFound) {
+		t.Errorf("unknown model error = %v", err)
+	}
+}
+
+func TestChat(t *testing.T) {
+	p := newTestProvider(nil)
+	ctx := context.Background()
+	p.ChatPrompt(ctx, Echo, "hello")
+	p.ChatPrompt(ctx, Echo, "again")
+	if len(p.history) != 4 || p.history[3].Content != "again" {
+		t.Errorf("history = %+v", p.history)
+	}
+	p.ResetChat()
+	if len(p.history) != 0 {
+		t.Error("ResetChat kept the history")
+	}
+}
+
+func TestComplete_Stream(t *testing.T) {
+	p := newTestProvider(map[string]string{RateEnv: "100"})
+	var deltas []string
+	start := time.Now()
+	got, err := p.Complete(context.Background(), providers.Request{
+		Model:    Echo,
+		Messages: []providers.Message{{Role: "user", Content: "one two  three"}},
+		OnDelta:  func(s string) { deltas = append(deltas, s) },
+	})
+	if err != nil || got != "one two  three" || strings.Join(deltas, "|") != "one |two  |three" {
+		t.Errorf("Complete = %q, %v, deltas %q", got, err, deltas)
+	}
+	if time.Since(start) < 30*time.Millisecond {
+		t.Errorf("3 words at 100/s streamed in %v", time.Since(start))
+	}
+
+	// Cancelling stops the stream.
+	p = newTestProvider(map[string]string{RateEnv: "0.1"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req := providers.Request{Model: Fixed, OnDelta: func(string) {}}
+	if _, err := p.Complete(ctx, req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("cancelled stream error = %v", err)
+	}
+}
+
+func TestComplete_Errors(t *testing.T) {
+	for e, target := range map[string]any{
+		"401":            new(*providers.InvalidAPIKeyError),
+		"402":            new(*providers.QuotaExceededError),
+		"404":            new(*providers.ModelNotFoundError),
+		"429":            new(*providers.RateLimitedError),
+		"503":            new(*providers.ServerError),
+		"timeout":        new(*providers.TimeoutError),
+		"context_length": new(*providers.ContextLengthExceededError),
+		"content_filter": new(*providers.ContentFilteredError),
+	} {
+		p := newTestProvider(map[string]string{ErrorEnv: e})
+		if _, err := p.Prompt(context.Background(), Fixed, "hi"); !errors.As(err, target) {
+			t.Errorf("%s=%s: error %T %v", ErrorEnv, e, err, err)
+		}
+	}
+	if _, err := newTestProvider(map[string]string{ErrorEnv: "teapot"}).Prompt(context.Background(), Fixed, "hi"); err == nil {
+		t.Error("unknown error accepted")
+	}
+
+	p := newTestProvider(map[string]string{ErrorEnv: "429", ErrorAfterEnv: "2"})
+	var deltas []string
+	got, err := p.Complete(context.Background(), providers.Request{
+		Model:    Echo,
+		Messages: []providers.Message{{Role: "user", Content: "one two three"}},
+		OnDelta:  func(s string) { deltas = append(deltas, s) },
+	})
+	var rl *providers.RateLimitedError
+	if !errors.As(err, &rl) || got != "one two " || len(deltas) != 2 {
+		t.Errorf("error after 2 words: %q, %v, deltas %q", got, err, deltas)
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	p := newTestProvider(nil)
+	vs, err := p.Embed(context.Background(), Embed, []string{"the cat sat", "The cat sat.", "stock prices fell", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 4 || len(vs[0]) != embedDims {
+		t.Fatalf("Embed returned %d vectors", len(vs))
+	}
+	dot := func(a, b []float32) (s float32) {
+		for i := range a {
+			s += a[i] * b[i]
+		}
+		return s
+	}
+	if d := dot(vs[0], vs[1]); d < 0.999 {
+		t.Errorf("same words have similarity %v", d)
+	}
+	if dot(vs[0], vs[2]) >= dot(vs[0], vs[1]) {
+		t.Error("different words as similar as the same ones")
+	}
+	if _, err := p.Embed(context.Background(), Echo, []string{"x"}); err == nil {
+		t.Error("Embed with a chat model succeeded")
+	}
+}
@@ -0,0 +1,93 @@
+package openai
+
+import (
+	"regexp"
+
+	"q/internal/providers"
+	"q/internal/tokenizer"
+)
+
+func flag(b bool) *bool { return &b }
+
+var (
+	textIn   = []string{providers.ModalityText}
+	visionIn = []string{providers.ModalityText, providers.ModalityImage}
+	textOut  = []string{providers.ModalityText}
+)
+
+// catalog holds the published limits and list prices of supportedModels.
+var catalog = map[string]providers.ModelInfo{
+	"gpt-3.5-turbo": {
+		ContextWindow: 16385, MaxOutput: 4096, Input: textIn, Output: textOut, Encoding: tokenizer.CL100K,
+		Tools: flag(true), JSONMode: flag(true), Sampling: flag(true),
+		Pricing: &providers.Pricing{Input: 0.50, Output: 1.50},
+	},
+	"gpt-3.5-turbo-0613": {
+		ContextWindow: 4096, MaxOutput: 4096, Input: textIn, Output: textOut, Encoding: tokenizer.CL100K,
+		Tools: flag(true), JSONMode: flag(false), Sampling: flag(true),
+		Pricing: &providers.Pricing{Input: 1.50, Output: 2.00},
+	},
+	"gpt-4o": {
+		ContextWindow: 128000, MaxOutput: 16384, Input: visionIn, Output: textOut, Encoding: tokenizer.O200K,
+		Tools: flag(true), JSONMode: flag(true), Sampling: flag(true),
+		Pricing: &providers.Pricing{Input: 2.50, Output: 10.00},
+	},
+	"gpt-4o-mini": {
+		ContextWindow: 128000, MaxOutput: 16384, Input: visionIn, Output: textOut, Encoding: tokenizer.O200K,
+		Tools: flag(true), JSONMode: flag(true), Sampling: flag(true),
+		Pricing: &providers.Pricing{Input: 0.15, Output: 0.60},
+	},
+	"gpt-4.1": {
+		ContextWindow: 1047576, MaxOutput: 32768, Input: visionIn, Output: textOut, Encoding: tokenizer.O200K,
+		Tools: flag(true), JSONMode: flag(true), Sampling: flag(true),
+		Pricing: &providers.Pricing{Input: 2.00, Output: 8.00},
+	},
+	"gpt-4.1-mini": {
+		ContextWindow: 1047576, MaxOutput: 32768, Input: visionIn, Output: textOut, Encoding: tokenizer.O200K,
+		Tools: flag(true), JSONMode: flag(true), Sampling: flag(true),
+		Pricing: &providers.Pricing{Input: 0.40, Output: 1.60},
+	},
+	"gpt-4.1-nano": {
+		ContextWindow: 1047576, MaxOutput: 32768, Input: visionIn, Output: textOut, Encoding: tokenizer.O200K,
+		Tools: flag(true), JSONMode: flag(true), Sampling: flag(true),
+		Pricing: &providers.Pricing{Input: 0.10, Output: 0.40},
+	},
+	"o3-mini": {
+		ContextWindow: 200000, MaxOutput: 100000, Input: textIn, Output: textOut, Encoding: tokenizer.O200K,
+		Tools: flag(true), JSONMode: flag(true), Sampling: flag(false),
+		Pricing: &providers.Pricing{Input: 1.10, Output: 4.40},
+	},
+	"o3": {
+		ContextWindow: 200000, MaxOutput: 100000, Input: visionIn, Output: textOut, Encoding: tokenizer.O200K,
+		Tools: flag(true), JSONMode: flag(true), Sampling: flag(false),
+		Pricing: &providers.Pricing{Input: 2.00, Output: 8.00},
+	},
+	"o3-pro": {
+		ContextWindow: 200000, MaxOutput: 100000, Input: visionIn, Output: textOut, Encoding: tokenizer.O200K,
+		Tools: flag(true), JSONMode: flag(true), Sampling: flag(false),
+		API:     APIResponses,
+		Pricing: &providers.Pricing{Input: 20.00, Output: 80.00},
+	},
+	"o4-mini": {
+		ContextWindow: 200000, MaxOutput: 100000, Input: visionIn, Output: textOut, Encoding: tokenizer.O200K,
+		Tools: flag(true), JSONMode: flag(true), Sampling: flag(false),
+		Pricing: &providers.Pricing{Input: 1.10, Output: 4.40},
+	},
+}
+
+// reSnapshot matches the date suffix of a pinned model snapshot, e.g. the
+// "-2024-08-06" of gpt-4o-2024-08-06.
+var reSnapshot = regexp.MustCompile(`-\d{4}-\d{2}-\d{2}$`)
+
+// ModelInfo implements providers.Cataloger. Dated snapshots of a catalogued
+// model share its entry.
+func (p *provider) ModelInfo(model string) (providers.ModelInfo, bool) {
+	if info, ok := catalog[model]; ok {
+		return info, true
+	}
+	if base := reSnapshot.ReplaceAllString(model, ""); base != model {
+		info, ok := catalog[base]
+		return info, ok
+	}
+	return providers.ModelInfo{}, false
+}
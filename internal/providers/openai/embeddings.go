@@ -0,0 +1,99 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"q/internal/providers"
+)
+
+const embeddingsPath = "/embeddings"
+
+var embeddingModels = []string{"text-embedding-3-small", "text-embedding-3-large", "text-embedding-ada-002"}
+
+// Limits of a single /embeddings request. Token counts are estimated, so
+// maxBatchTokens leaves room below the API's 300,000.
+const (
+	maxBatchInputs = 2048
+	maxBatchTokens = 250_000
+)
+
+type embeddingsReq struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	EncodingFormat string   `json:"encoding_format"`
+}
+
+type embeddingsResp struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (p *provider) EmbeddingModels() []string { return embeddingModels }
+
+// Embed implements providers.Embedder with POST /embeddings, splitting inputs
+// into batches within the request limits. Failed requests are retried by the
+// client.
+func (p *provider) Embed(ctx context.Context, model string, inputs []string) ([][]float32, error) {
+	for i, s := range inputs {
+		if s == "" {
+			return nil, fmt.Errorf("openai: input %d is empty; embeddings need text", i)
+		}
+	}
+	vectors := make([][]float32, 0, len(inputs))
+	for start := 0; start < len(inputs); {
+		end, tokens := start, 0
+		for end < len(inputs) && end-start < maxBatchInputs {
+			n := providers.EstimateText(inputs[end])
+			if end > start && tokens+n > maxBatchTokens {
+				break
+			}
+			tokens += n
+			end++
+		}
+		batch, err := p.embedBatch(ctx, model, inputs[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+		start = end
+	}
+	return vectors, nil
+}
+
+func (p *provider) embedBatch(ctx context.Context, model string, inputs []string) ([][]float32, error) {
+	body, _ := json.Marshal(embeddingsReq{Model: model, Input: inputs, EncodingFormat: "float"})
+	resp, err := p.do(ctx, http.MethodPost, embeddingsPath, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(resp.Body)
+		return nil, handleAPIError(p.Name(), model, resp, responseBody)
+	}
+
+	var res embeddingsResp
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("openai: invalid embeddings response: %w", err)
+	}
+	vectors := make([][]float32, len(inputs))
+	for _, d := range res.Data {
+		if d.Index < 0 || d.Index >= len(inputs) {
+			return nil, fmt.Errorf("openai: embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for _, v := range vectors {
+		if v == nil {
+			return nil, errors.New("openai: embeddings response is missing vectors")
+		}
+	}
+	return vectors, nil
+}
@@ -0,0 +1,82 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// embedClient answers /embeddings requests with vectors [i, len(input)], in
+// reverse order to check that results are placed by index.
+type embedClient struct {
+	batches []int
+}
+
+func (c *embedClient) Do(req *http.Request) (*http.Response, error) {
+	var r embeddingsReq
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	c.batches = append(c.batches, len(r.Input))
+	var data []string
+	for i := len(r.Input) - 1; i >= 0; i-- {
+		data = append(data, fmt.Sprintf(`{"index":%d,"embedding":[%d,%d]}`, i, i, len(r.Input[i])))
+	}
+	body := `{"data":[` + strings.Join(data, ",") + `]}`
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+func TestEmbed(t *testing.T) {
+	setupKey(t)
+	c := &embedClient{}
+	p := NewProvider(func(p *provider) { p.client = c })
+
+	got, err := p.Embed(context.Background(), "text-embedding-3-small", []string{"a", "bb", "ccc"})
+	if err != nil {
+		t.Fatalf("Embed error: %v", err)
+	}
+	want := [][]float32{{0, 1}, {1, 2}, {2, 3}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("Embed = %v; want %v", got, want)
+	}
+}
+
+func TestEmbed_Batches(t *testing.T) {
+	setupKey(t)
+	c := &embedClient{}
+	p := NewProvider(func(p *provider) { p.client = c })
+
+	inputs := make([]string, maxBatchInputs+10)
+	for i := range inputs {
+		inputs[i] = "x"
+	}
+	long := strings.Repeat("y", maxBatchTokens*4/2+4) // just over half a batch
+	inputs = append(inputs, long, long)
+
+	got, err := p.Embed(context.Background(), "text-embedding-3-small", inputs)
+	if err != nil {
+		t.Fatalf("Embed error: %v", err)
+	}
+	if len(got) != len(inputs) {
+		t.Fatalf("Embed returned %d vectors for %d inputs", len(got), len(inputs))
+	}
+	if want := []int{maxBatchInputs, 11, 1}; !slices.Equal(c.batches, want) {
+		t.Errorf("batches = %v; want %v", c.batches, want)
+	}
+	if got[maxBatchInputs][0] != 0 || got[len(got)-1][1] != float32(len(long)) {
+		t.Errorf("vectors out of order across batches")
+	}
+}
+
+func TestEmbed_EmptyInput(t *testing.T) {
+	setupKey(t)
+	p := NewProvider(func(p *provider) { p.client = &embedClient{} })
+	if _, err := p.Embed(context.Background(), "text-embedding-3-small", []string{"a", ""}); err == nil {
+		t.Error("Embed with an empty input succeeded; want an error")
+	}
+}
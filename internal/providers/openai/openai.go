@@ -8,9 +8,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"q/internal/config"
 	"q/internal/httpclient"
@@ -39,38 +44,180 @@ type apiErr struct {
 	} `json:"error"`
 }
 
-func handleAPIError(provider string, statusCode int, responseBody []byte) error {
+var (
+	reMaxContext = regexp.MustCompile(`maximum context length is (\d+) tokens`)
+	reRequested  = regexp.MustCompile(`(?:resulted in|requested) (\d+) tokens`)
+)
+
+// handleAPIError maps an error response onto the providers error taxonomy.
+func handleAPIError(provider, model string, resp *http.Response, responseBody []byte) error {
+	statusCode := resp.StatusCode
 	var apiError apiErr
-	if json.Unmarshal(responseBody, &apiError) == nil { // parsed
-		// bad / missing key?
-		if statusCode == http.StatusUnauthorized ||
-			strings.Contains(apiError.Error.Code, "invalid_api_key") ||
-			strings.Contains(apiError.Error.Message, "Incorrect API key") {
-			return &providers.InvalidAPIKeyError{Provider: provider}
+	if json.Unmarshal(responseBody, &apiError) != nil { // not JSON
+		body := string(responseBody)
+		if statusCode >= 500 {
+			return &providers.ServerError{Provider: provider, StatusCode: statusCode, Body: body}
 		}
-		return fmt.Errorf("API error: %s", apiError.Error.Message)
+		return &providers.APIError{Provider: provider, StatusCode: statusCode, Body: body}
 	}
-	return fmt.Errorf("API request failed with status %d: %s", statusCode, string(responseBody))
+
+	e := apiError.Error
+	return classifyError(provider, model, statusCode, resp.Header, e.Type, e.Code, e.Message)
+}
+
+// classifyError maps an API error onto the providers error taxonomy.
+// statusCode is 0 for errors reported inside a stream.
+func classifyError(provider, model string, statusCode int, header http.Header, typ, code, msg string) error {
+	switch {
+	// bad / missing key?
+	case statusCode == http.StatusUnauthorized ||
+		strings.Contains(code, "invalid_api_key") ||
+		strings.Contains(msg, "Incorrect API key"):
+		return &providers.InvalidAPIKeyError{Provider: provider}
+	case code == "insufficient_quota" || typ == "insufficient_quota":
+		return &providers.QuotaExceededError{Provider: provider, Message: msg}
+	case code == "context_length_exceeded":
+		e := &providers.ContextLengthExceededError{Provider: provider, Message: msg}
+		if m := reMaxContext.FindStringSubmatch(msg); m != nil {
+			e.Limit, _ = strconv.Atoi(m[1])
+		}
+		if m := reRequested.FindStringSubmatch(msg); m != nil {
+			e.Requested, _ = strconv.Atoi(m[1])
+		}
+		return e
+	case code == "content_filter" || code == "content_policy_violation":
+		return &providers.ContentFilteredError{Provider: provider, Message: msg}
+	case code == "model_not_found" || statusCode == http.StatusNotFound:
+		return &providers.ModelNotFoundError{Provider: provider, Model: model, Message: msg}
+	case statusCode == http.StatusTooManyRequests || code == "rate_limit_exceeded":
+		retryAfter, _ := httpclient.RetryAfter(header, http.StatusTooManyRequests, time.Now())
+		return &providers.RateLimitedError{Provider: provider, Message: msg, RetryAfter: retryAfter}
+	case statusCode >= 500 || code == "server_error":
+		return &providers.ServerError{Provider: provider, StatusCode: statusCode, Message: msg}
+	}
+	return &providers.APIError{Provider: provider, StatusCode: statusCode, Message: msg}
 }
 
 type message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    content    `json:"content"`
+	ToolCalls  []toolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+// toolCall is a function call in a message, or in a streamed delta, where
+// Index says which call the fragment belongs to.
+type toolCall struct {
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type tool struct {
+	Type     string `json:"type"` // "function"
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Parameters  json.RawMessage `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+func toolCalls(calls []providers.ToolCall) []toolCall {
+	var out []toolCall
+	for _, c := range calls {
+		tc := toolCall{ID: c.ID, Type: "function"}
+		tc.Function.Name, tc.Function.Arguments = c.Name, c.Arguments
+		out = append(out, tc)
+	}
+	return out
+}
+
+func fromToolCalls(calls []toolCall) []providers.ToolCall {
+	var out []providers.ToolCall
+	for _, c := range calls {
+		out = append(out, providers.ToolCall{ID: c.ID, Name: c.Function.Name, Arguments: c.Function.Arguments})
+	}
+	return out
+}
+
+// content is a message's text or, when it carries images, an array of
+// content parts.
+type content struct {
+	Text  string
+	Parts []part
+}
+
+type part struct {
+	Type     string    `json:"type"` // "text" or "image_url"
+	Text     string    `json:"text,omitempty"`
+	ImageURL *imageURL `json:"image_url,omitempty"`
+}
+
+type imageURL struct {
+	URL string `json:"url"`
+}
+
+func text(s string) content { return content{Text: s} }
+
+// messageContent converts m's text and images into content.
+func messageContent(m providers.Message) content {
+	if len(m.Images) == 0 {
+		return text(m.Content)
+	}
+	var parts []part
+	if m.Content != "" {
+		parts = append(parts, part{Type: "text", Text: m.Content})
+	}
+	for _, img := range m.Images {
+		parts = append(parts, part{Type: "image_url", ImageURL: &imageURL{URL: img.URL}})
+	}
+	return content{Parts: parts}
+}
+
+func (c content) MarshalJSON() ([]byte, error) {
+	if c.Parts != nil {
+		return json.Marshal(c.Parts)
+	}
+	return json.Marshal(c.Text)
+}
+
+func (c *content) UnmarshalJSON(data []byte) error {
+	*c = content{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, &c.Parts)
+	}
+	return json.Unmarshal(data, &c.Text)
 }
 
 type chatReq struct {
-	Model    string    `json:"model"`
-	Messages []message `json:"messages"`
-	Stream   bool      `json:"stream,omitempty"`
+	Model       string    `json:"model"`
+	Messages    []message `json:"messages"`
+	Tools       []tool    `json:"tools,omitempty"`
+	Stream      bool      `json:"stream,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	TopP        *float64  `json:"top_p,omitempty"`
+	MaxTokens   int       `json:"max_completion_tokens,omitempty"`
 }
 
 type chatResp struct {
 	Choices []struct {
-		Message struct{ Content string } `json:"message"`
-		Delta   struct{ Content string } `json:"delta"`
+		Message      reply  `json:"message"`
+		Delta        reply  `json:"delta"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
 }
 
+type reply struct {
+	Content   string     `json:"content"`
+	ToolCalls []toolCall `json:"tool_calls"`
+}
+
+const finishContentFilter = "content_filter"
+
 type provider struct {
 	client httpclient.HTTPClient
 	apiURL string
@@ -80,7 +227,7 @@ type provider struct {
 }
 
 func NewProvider(opts ...func(*provider)) *provider {
-	p := &provider{client: http.DefaultClient, apiURL: defaultAPIURL}
+	p := &provider{client: httpclient.NewRetryClient(httpclient.FromEnv()), apiURL: defaultAPIURL}
 	for _, o := range opts {
 		o(p)
 	}
@@ -91,12 +238,13 @@ func (p *provider) Name() string              { return "openai" }
 func (p *provider) SupportedModels() []string { return supportedModels }
 
 func (p *provider) Prompt(ctx context.Context, model, prompt string) (string, error) {
-	return p.send(ctx, model, []message{{Role: "user", Content: prompt}}, false, nil)
+	return p.send(ctx, chatReq{Model: model, Messages: []message{{Role: "user", Content: text(prompt)}}}, nil)
 }
 
 func (p *provider) Stream(ctx context.Context, model, prompt string) (string, error) {
 	var out strings.Builder
-	_, err := p.send(ctx, model, []message{{Role: "user", Content: prompt}}, true, func(s string) {
+	req := chatReq{Model: model, Messages: []message{{Role: "user", Content: text(prompt)}}, Stream: true}
+	_, err := p.send(ctx, req, func(s string) {
 		fmt.Print(s)
 		out.WriteString(s)
 	})
@@ -105,7 +253,7 @@ func (p *provider) Stream(ctx context.Context, model, prompt string) (string, er
 
 func (p *provider) ChatPrompt(ctx context.Context, model, msg string) (string, error) {
 	p.push("user", msg)
-	resp, err := p.send(ctx, model, p.copyHistory(), false, nil)
+	resp, err := p.send(ctx, chatReq{Model: model, Messages: p.copyHistory()}, nil)
 	if err == nil {
 		p.push("assistant", resp)
 	}
@@ -116,7 +264,7 @@ func (p *provider) ChatStream(ctx context.Context, model, msg string) (string, e
 	p.push("user", msg)
 
 	var out strings.Builder
-	_, err := p.send(ctx, model, p.copyHistory(), true, func(s string) {
+	_, err := p.send(ctx, chatReq{Model: model, Messages: p.copyHistory(), Stream: true}, func(s string) {
 		fmt.Print(s)
 		out.WriteString(s)
 	})
@@ -128,59 +276,208 @@ func (p *provider) ChatStream(ctx context.Context, model, msg string) (string, e
 
 func (p *provider) ResetChat() { p.mu.Lock(); p.history = nil; p.mu.Unlock() }
 
-func (p *provider) send(
-	ctx context.Context,
-	model string,
-	msgs []message,
-	stream bool,
-	onDelta func(string),
-) (string, error) {
+// Complete implements providers.Completer. It does not touch the chat history.
+// Requests go to /chat/completions unless r.API or r.Background selects the
+// Responses API.
+func (p *provider) Complete(ctx context.Context, r providers.Request) (string, error) {
+	switch {
+	case r.API == APIResponses || r.API == "" && r.Background:
+		return p.respond(ctx, r)
+	case r.Background:
+		return "", errors.New("openai: background mode needs the responses API")
+	case r.API != "" && r.API != APIChat:
+		return "", fmt.Errorf("openai: unknown API %q; want %s or %s", r.API, APIChat, APIResponses)
+	}
+
+	return p.send(ctx, chatRequest(r), r.OnDelta)
+}
+
+// CompleteTools implements providers.ToolCompleter with /chat/completions.
+func (p *provider) CompleteTools(ctx context.Context, r providers.Request) (providers.Message, error) {
+	if r.API == APIResponses || r.Background {
+		return providers.Message{}, errors.New("openai: tools need the chat API; use --api chat")
+	}
+	return p.sendMessage(ctx, chatRequest(r), r.OnDelta)
+}
+
+func chatRequest(r providers.Request) chatReq {
+	msgs := make([]message, 0, len(r.Messages)+1)
+	if r.System != "" {
+		msgs = append(msgs, message{Role: "system", Content: text(r.System)})
+	}
+	for _, m := range r.Messages {
+		msgs = append(msgs, message{Role: m.Role, Content: messageContent(m), ToolCalls: toolCalls(m.ToolCalls), ToolCallID: m.ToolCallID})
+	}
+	req := chatReq{
+		Model:       r.Model,
+		Messages:    msgs,
+		Stream:      r.OnDelta != nil,
+		Temperature: r.Params.Temperature,
+		TopP:        r.Params.TopP,
+		MaxTokens:   r.Params.MaxTokens,
+	}
+	for _, t := range r.Tools {
+		var f tool
+		f.Type = "function"
+		f.Function.Name, f.Function.Description, f.Function.Parameters = t.Name, t.Description, t.Parameters
+		req.Tools = append(req.Tools, f)
+	}
+	return req
+}
+
+const chatPath = "/chat/completions"
+
+// endpoint returns the URL of an API path such as /chat/completions,
+// honoring a base URL set in the active config profile.
+func (p *provider) endpoint(path string) (string, error) {
+	base, err := config.GetBaseURL(p.Name())
+	if err != nil {
+		return "", err
+	}
+	if base == "" {
+		if path == chatPath {
+			return p.apiURL, nil
+		}
+		base = strings.TrimSuffix(p.apiURL, chatPath)
+	}
+	return base + path, nil
+}
+
+// do sends an authenticated request to path and maps transport timeouts onto
+// the error taxonomy. body may be nil.
+func (p *provider) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
 	key, err := config.GetAPIKey(p.Name())
 	switch {
 	case err != nil:
-		return "", err
+		return nil, err
 	case key == "":
-		return "", fmt.Errorf(errKeyFmt, p.Name())
+		return nil, fmt.Errorf(errKeyFmt, p.Name())
 	}
 
-	body, _ := json.Marshal(chatReq{Model: model, Messages: msgs, Stream: stream})
-
-	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, p.apiURL, bytes.NewReader(body))
+	url, err := p.endpoint(path)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, _ := http.NewRequestWithContext(ctx, method, url, r)
 	req.Header.Set("Authorization", "Bearer "+key)
-	req.Header.Set("Content-Type", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return "", err
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, &providers.TimeoutError{Provider: p.Name(), Err: err}
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// nonChatModels are prefixes of the OpenAI model IDs served by /models that
+// cannot be used for chat completions. Prefixes, not substrings, so that chat
+// models such as gpt-4o-search-preview and the models of other servers with
+// similar names are kept.
+var nonChatModels = []string{
+	"text-embedding-", "whisper-", "tts-", "dall-e-", "gpt-image-", "sora-",
+	"omni-moderation-", "text-moderation-", "davinci-", "babbage-",
+	"gpt-realtime", "gpt-4o-realtime-", "gpt-4o-mini-realtime-",
+	"gpt-4o-transcribe", "gpt-4o-mini-transcribe", "gpt-4o-mini-tts",
+}
+
+// ListModels implements providers.ModelLister with GET /models, leaving out
+// embedding, audio, image and other non-chat models.
+func (p *provider) ListModels(ctx context.Context) ([]string, error) {
+	resp, err := p.do(ctx, http.MethodGet, "/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(resp.Body)
+		return nil, handleAPIError(p.Name(), "", resp, responseBody)
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("openai: invalid model list: %w", err)
+	}
+	var models []string
+	for _, m := range list.Data {
+		if !slices.ContainsFunc(nonChatModels, func(s string) bool { return strings.HasPrefix(m.ID, s) }) {
+			models = append(models, m.ID)
+		}
+	}
+	slices.Sort(models)
+	return models, nil
+}
+
+func (p *provider) send(ctx context.Context, chat chatReq, onDelta func(string)) (string, error) {
+	m, err := p.sendMessage(ctx, chat, onDelta)
+	return m.Content, err
+}
+
+// sendMessage sends chat and returns the assistant's reply, with any tool
+// calls it asks for.
+func (p *provider) sendMessage(ctx context.Context, chat chatReq, onDelta func(string)) (providers.Message, error) {
+	out := providers.Message{Role: "assistant"}
+	body, _ := json.Marshal(chat)
+	resp, err := p.do(ctx, http.MethodPost, chatPath, body)
+	if err != nil {
+		return out, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		responseBody, _ := io.ReadAll(resp.Body)
-		return "", handleAPIError(p.Name(), resp.StatusCode, responseBody)
+		return out, handleAPIError(p.Name(), chat.Model, resp, responseBody)
 	}
 
 	/* -------- Non-streaming -------- */
-	if !stream {
+	if !chat.Stream {
 		var response chatResp
 		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-			return "", err
+			return out, err
+		}
+		if len(response.Choices) == 0 {
+			return out, errors.New("openai: empty response")
 		}
-		if len(response.Choices) == 0 || response.Choices[0].Message.Content == "" {
-			return "", errors.New("openai: empty response")
+		choice := response.Choices[0]
+		out.Content, out.ToolCalls = choice.Message.Content, fromToolCalls(choice.Message.ToolCalls)
+		if choice.FinishReason == finishContentFilter {
+			return out, &providers.ContentFilteredError{Provider: p.Name()}
 		}
-		return response.Choices[0].Message.Content, nil
+		if out.Content == "" && len(out.ToolCalls) == 0 {
+			return out, errors.New("openai: empty response")
+		}
+		return out, nil
 	}
 
 	/* -------- Streaming -------- */
 	scanner := bufio.NewScanner(resp.Body)
-	var fullResponse strings.Builder
+	var (
+		fullResponse strings.Builder
+		calls        []toolCall
+	)
+	done := func(err error) (providers.Message, error) {
+		out.Content, out.ToolCalls = fullResponse.String(), fromToolCalls(calls)
+		return out, err
+	}
 
 	for scanner.Scan() {
 		// Check for context cancellation
 		select {
 		case <-ctx.Done():
-			return fullResponse.String(), ctx.Err()
+			return done(ctx.Err())
 		default:
 		}
 
@@ -197,18 +494,53 @@ func (p *provider) send(
 			continue
 		}
 		content := chunk.Choices[0].Delta.Content
-		if onDelta != nil {
+		if onDelta != nil && content != "" {
 			onDelta(content)
 		}
 		fullResponse.WriteString(content)
+		for _, d := range chunk.Choices[0].Delta.ToolCalls {
+			i, err := callIndex(calls, d)
+			if err != nil {
+				return done(err)
+			}
+			if i == len(calls) {
+				calls = append(calls, toolCall{})
+			}
+			if d.ID != "" {
+				calls[i].ID = d.ID
+			}
+			calls[i].Function.Name += d.Function.Name
+			calls[i].Function.Arguments += d.Function.Arguments
+		}
+		if chunk.Choices[0].FinishReason == finishContentFilter {
+			return done(&providers.ContentFilteredError{Provider: p.Name()})
+		}
+	}
+	return done(scanner.Err())
+}
+
+// callIndex returns which of calls a streamed tool call delta continues, or
+// len(calls) if it starts another. Some gateways leave out the index; then a
+// new ID starts a call and a delta without one continues the last.
+func callIndex(calls []toolCall, d toolCall) (int, error) {
+	switch {
+	case d.Index != nil:
+		if i := *d.Index; i >= 0 && i <= len(calls) {
+			return i, nil
+		}
+		return 0, fmt.Errorf("openai: tool call delta with index %d after %d calls", *d.Index, len(calls))
+	case d.ID != "" && (len(calls) == 0 || calls[len(calls)-1].ID != d.ID):
+		return len(calls), nil
+	case len(calls) > 0:
+		return len(calls) - 1, nil
 	}
-	return fullResponse.String(), scanner.Err()
+	return 0, errors.New("openai: tool call delta without an index or ID")
 }
 
 func (p *provider) push(role, content string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.history = append(p.history, message{Role: role, Content: content})
+	p.history = append(p.history, message{Role: role, Content: text(content)})
 }
 
 func (p *provider) copyHistory() []message {
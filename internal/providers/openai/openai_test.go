@@ -3,14 +3,19 @@ package openai
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"q/internal/config"
+	"q/internal/providers"
 )
 
 // fakeClient is an HTTPClient stub for testing.
@@ -25,6 +30,8 @@ func (f *fakeClient) Do(req *http.Request) (*http.Response, error) {
 func TestPrompt_NoAPIKey(t *testing.T) {
 	tmp := t.TempDir()
 	os.Setenv("XDG_CONFIG_HOME", tmp)
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("Q_OPENAI_API_KEY", "")
 	p := NewProvider()
 	_, err := p.Prompt(context.Background(), "gpt-4", "hi")
 	if err == nil || !strings.Contains(err.Error(), "no API key set for openai") {
@@ -57,6 +64,8 @@ func TestPrompt_Success(t *testing.T) {
 func TestStream_NoAPIKey(t *testing.T) {
 	tmp := t.TempDir()
 	os.Setenv("XDG_CONFIG_HOME", tmp)
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("Q_OPENAI_API_KEY", "")
 	p := NewProvider()
 	_, err := p.Stream(context.Background(), "gpt-4", "hi")
 	if err == nil || !strings.Contains(err.Error(), "no API key set for openai") {
@@ -372,3 +381,425 @@ func TestResetChat(t *testing.T) {
 		t.Errorf("Expected 0 messages in history after reset, got %d", len(p.history))
 	}
 }
+
+// captureClient records the last request body and replies with resp.
+type captureClient struct {
+	url  string
+	body []byte
+	resp string
+}
+
+func (c *captureClient) Do(req *http.Request) (*http.Response, error) {
+	c.url = req.URL.String()
+	if req.Body != nil {
+		c.body, _ = io.ReadAll(req.Body)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(c.resp)),
+	}, nil
+}
+
+func TestComplete_SystemAndParams(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	if err := config.SetAPIKey("openai", "key"); err != nil {
+		t.Fatalf("SetAPIKey: %v", err)
+	}
+	c := &captureClient{resp: `{"choices":[{"message":{"content":"ok"}}]}`}
+	p := NewProvider(func(p *provider) { p.client = c })
+
+	temp := 0.3
+	got, err := p.Complete(context.Background(), providers.Request{
+		Model:    "gpt-4o",
+		System:   "be brief",
+		Messages: []providers.Message{{Role: "user", Content: "hi"}},
+		Params:   providers.Params{Temperature: &temp, MaxTokens: 10},
+	})
+	if err != nil {
+		t.Fatalf("Complete error: %v", err)
+	}
+	if got != "ok" {
+		t.Errorf("Complete = %q; want %q", got, "ok")
+	}
+
+	var sent chatReq
+	if err := json.Unmarshal(c.body, &sent); err != nil {
+		t.Fatalf("unmarshal request: %v", err)
+	}
+	if len(sent.Messages) != 2 || sent.Messages[0].Role != "system" || sent.Messages[0].Content.Text != "be brief" {
+		t.Errorf("messages = %+v; want system message first", sent.Messages)
+	}
+	if sent.Temperature == nil || *sent.Temperature != 0.3 || sent.MaxTokens != 10 {
+		t.Errorf("params = %v, %d; want 0.3, 10", sent.Temperature, sent.MaxTokens)
+	}
+	if sent.Stream {
+		t.Error("expected non-streaming request without OnDelta")
+	}
+	if len(p.history) != 0 {
+		t.Errorf("Complete modified chat history: %+v", p.history)
+	}
+}
+
+func TestPrompt_ErrorTaxonomy(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	if err := config.SetAPIKey("openai", "key"); err != nil {
+		t.Fatalf("SetAPIKey: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		status int
+		header http.Header
+		body   string
+		check  func(error) bool
+	}{
+		{"rate limited", http.StatusTooManyRequests, http.Header{"Retry-After": {"2"}},
+			`{"error":{"message":"slow down","code":"rate_limit_exceeded"}}`,
+			func(err error) bool {
+				var e *providers.RateLimitedError
+				return errors.As(err, &e) && e.RetryAfter == 2*time.Second
+			}},
+		{"quota", http.StatusTooManyRequests, nil,
+			`{"error":{"message":"out of credits","type":"insufficient_quota","code":"insufficient_quota"}}`,
+			func(err error) bool {
+				var e *providers.QuotaExceededError
+				return errors.As(err, &e)
+			}},
+		{"context length", http.StatusBadRequest, nil,
+			`{"error":{"message":"This model's maximum context length is 128000 tokens. However, your messages resulted in 130512 tokens.","code":"context_length_exceeded"}}`,
+			func(err error) bool {
+				var e *providers.ContextLengthExceededError
+				return errors.As(err, &e) && e.Limit == 128000 && e.Requested == 130512
+			}},
+		{"content filter", http.StatusBadRequest, nil,
+			`{"error":{"message":"blocked","code":"content_policy_violation"}}`,
+			func(err error) bool {
+				var e *providers.ContentFilteredError
+				return errors.As(err, &e)
+			}},
+		{"model not found", http.StatusNotFound, nil,
+			`{"error":{"message":"no such model","code":"model_not_found"}}`,
+			func(err error) bool {
+				var e *providers.ModelNotFoundError
+				return errors.As(err, &e) && e.Model == "gpt-4"
+			}},
+		{"server error", http.StatusServiceUnavailable, nil,
+			`{"error":{"message":"overloaded"}}`,
+			func(err error) bool {
+				var e *providers.ServerError
+				return errors.As(err, &e) && e.StatusCode == http.StatusServiceUnavailable
+			}},
+		{"other", http.StatusBadRequest, nil,
+			`{"error":{"message":"bad param"}}`,
+			func(err error) bool {
+				var e *providers.APIError
+				return errors.As(err, &e) && err.Error() == "API error: bad param"
+			}},
+	}
+	for _, c := range cases {
+		p := NewProvider(func(p *provider) {
+			p.client = &fakeClient{resp: &http.Response{
+				StatusCode: c.status,
+				Header:     c.header,
+				Body:       io.NopCloser(strings.NewReader(c.body)),
+			}}
+		})
+		_, err := p.Prompt(context.Background(), "gpt-4", "prompt")
+		if err == nil || !c.check(err) {
+			t.Errorf("%s: unexpected error %T: %v", c.name, err, err)
+		}
+	}
+}
+
+func TestStream_ContentFilterFinishReason(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	if err := config.SetAPIKey("openai", "key"); err != nil {
+		t.Fatalf("SetAPIKey: %v", err)
+	}
+	s := "data: {\"choices\":[{\"delta\":{\"content\":\"partial\"}}]}\n" +
+		"data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"content_filter\"}]}\n" +
+		"data: [DONE]\n"
+	p := NewProvider(func(p *provider) {
+		p.client = &fakeClient{resp: &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(s)),
+		}}
+	})
+	var out strings.Builder
+	_, err := p.Complete(context.Background(), providers.Request{
+		Model:    "gpt-4",
+		Messages: []providers.Message{{Role: "user", Content: "hi"}},
+		OnDelta:  func(s string) { out.WriteString(s) },
+	})
+	var e *providers.ContentFilteredError
+	if !errors.As(err, &e) {
+		t.Errorf("expected ContentFilteredError, got %v", err)
+	}
+	if out.String() != "partial" {
+		t.Errorf("streamed = %q; want %q", out.String(), "partial")
+	}
+}
+
+func TestSend_ProfileBaseURL(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	cfg := config.Config{
+		APIKeys:  map[string]string{"openai": "key"},
+		BaseURLs: map[string]string{"openai": "http://localhost:8080/v1/"},
+	}
+	if err := config.SaveConfig(cfg); err != nil {
+		t.Fatalf("SaveConfig: %v", err)
+	}
+	c := &captureClient{resp: `{"choices":[{"message":{"content":"ok"}}]}`}
+	p := NewProvider(func(p *provider) { p.client = c })
+	if _, err := p.Prompt(context.Background(), "gpt-4o", "hi"); err != nil {
+		t.Fatalf("Prompt error: %v", err)
+	}
+	if want := "http://localhost:8080/v1/chat/completions"; c.url != want {
+		t.Errorf("request URL = %q; want %q", c.url, want)
+	}
+}
+
+func TestListModels(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	cfg := config.Config{
+		APIKeys:  map[string]string{"openai": "key"},
+		BaseURLs: map[string]string{"openai": "http://localhost:8080/v1"},
+	}
+	if err := config.SaveConfig(cfg); err != nil {
+		t.Fatalf("SaveConfig: %v", err)
+	}
+	c := &captureClient{resp: `{"object":"list","data":[
+		{"id":"gpt-5"},{"id":"text-embedding-3-small"},{"id":"gpt-4o"},
+		{"id":"whisper-1"},{"id":"gpt-4o-realtime-preview"},{"id":"o3"},
+		{"id":"gpt-4o-search-preview"},{"id":"gpt-4o-audio-preview"},{"id":"omni-moderation-latest"},
+		{"id":"dall-e-3"},{"id":"gpt-image-1"},{"id":"gpt-4o-mini-transcribe"},{"id":"llava-image-chat"}]}`}
+	p := NewProvider(func(p *provider) { p.client = c })
+
+	got, err := p.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels error: %v", err)
+	}
+	want := []string{"gpt-4o", "gpt-4o-audio-preview", "gpt-4o-search-preview", "gpt-5", "llava-image-chat", "o3"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ListModels = %v; want %v", got, want)
+	}
+	if want := "http://localhost:8080/v1/models"; c.url != want {
+		t.Errorf("request URL = %q; want %q", c.url, want)
+	}
+}
+
+func TestListModels_DefaultURL(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	if err := config.SetAPIKey("openai", "key"); err != nil {
+		t.Fatalf("SetAPIKey: %v", err)
+	}
+	c := &captureClient{resp: `{"data":[]}`}
+	p := NewProvider(func(p *provider) { p.client = c })
+	if _, err := p.ListModels(context.Background()); err != nil {
+		t.Fatalf("ListModels error: %v", err)
+	}
+	if want := "https://api.openai.com/v1/models"; c.url != want {
+		t.Errorf("request URL = %q; want %q", c.url, want)
+	}
+}
+
+func TestModelInfo(t *testing.T) {
+	p := NewProvider()
+	for _, m := range supportedModels {
+		info, ok := p.ModelInfo(m)
+		if !ok || info.ContextWindow == 0 || info.Pricing == nil {
+			t.Errorf("ModelInfo(%s) = %+v, %v; want a complete catalog entry", m, info, ok)
+		}
+	}
+	if info, ok := p.ModelInfo("gpt-4o-2024-08-06"); !ok || info.ContextWindow != catalog["gpt-4o"].ContextWindow {
+		t.Errorf("dated snapshot did not inherit its model's entry: %+v, %v", info, ok)
+	}
+	if _, ok := p.ModelInfo("gpt-4o-audio-preview"); ok {
+		t.Error("ModelInfo matched a different model by prefix")
+	}
+}
+
+func TestComplete_Images(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	if err := config.SetAPIKey("openai", "key"); err != nil {
+		t.Fatalf("SetAPIKey: %v", err)
+	}
+	c := &captureClient{resp: `{"choices":[{"message":{"content":"a cat"}}]}`}
+	p := NewProvider(func(p *provider) { p.client = c })
+
+	_, err := p.Complete(context.Background(), providers.Request{
+		Model: "gpt-4o",
+		Messages: []providers.Message{{
+			Role:    "user",
+			Content: "What is this?",
+			Images:  []providers.Image{{URL: "data:image/png;base64,iVBORw0KGgo="}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Complete error: %v", err)
+	}
+
+	var sent struct {
+		Messages []struct {
+			Content []map[string]any `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(c.body, &sent); err != nil {
+		t.Fatalf("want content parts, got %s: %v", c.body, err)
+	}
+	parts := sent.Messages[0].Content
+	if len(parts) != 2 || parts[0]["type"] != "text" || parts[0]["text"] != "What is this?" || parts[1]["type"] != "image_url" {
+		t.Fatalf("parts = %v", parts)
+	}
+	if url := parts[1]["image_url"].(map[string]any)["url"]; url != "data:image/png;base64,iVBORw0KGgo=" {
+		t.Errorf("image url = %v", url)
+	}
+}
+
+func TestCompleteTools(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	if err := config.SetAPIKey("openai", "key"); err != nil {
+		t.Fatalf("SetAPIKey: %v", err)
+	}
+	c := &captureClient{resp: `{"choices":[{"message":{"content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"db__query","arguments":"{\"sql\":\"select 1\"}"}}]},"finish_reason":"tool_calls"}]}`}
+	p := NewProvider(func(p *provider) { p.client = c })
+
+	req := providers.Request{
+		Model: "gpt-4o",
+		Messages: []providers.Message{
+			{Role: "user", Content: "How many users?"},
+			{Role: "assistant", ToolCalls: []providers.ToolCall{{ID: "call_0", Name: "db__tables", Arguments: "{}"}}},
+			{Role: "tool", ToolCallID: "call_0", Content: "users"},
+		},
+		Tools: []providers.Tool{{Name: "db__query", Description: "Run SQL", Parameters: json.RawMessage(`{"type":"object"}`)}},
+	}
+	got, err := p.CompleteTools(context.Background(), req)
+	if err != nil {
+		t.Fatalf("CompleteTools error: %v", err)
+	}
+	want := []providers.ToolCall{{ID: "call_1", Name: "db__query", Arguments: `{"sql":"select 1"}`}}
+	if got.Role != "assistant" || got.Content != "" || !slices.Equal(got.ToolCalls, want) {
+		t.Errorf("CompleteTools = %+v; want %+v", got, want)
+	}
+
+	var sent chatReq
+	if err := json.Unmarshal(c.body, &sent); err != nil {
+		t.Fatalf("unmarshal request: %v", err)
+	}
+	if len(sent.Tools) != 1 || sent.Tools[0].Type != "function" || sent.Tools[0].Function.Name != "db__query" || string(sent.Tools[0].Function.Parameters) != `{"type":"object"}` {
+		t.Errorf("tools = %+v", sent.Tools)
+	}
+	call, result := sent.Messages[1], sent.Messages[2]
+	if len(call.ToolCalls) != 1 || call.ToolCalls[0].ID != "call_0" || call.ToolCalls[0].Function.Name != "db__tables" {
+		t.Errorf("assistant message = %+v", call)
+	}
+	if result.Role != "tool" || result.ToolCallID != "call_0" || result.Content.Text != "users" {
+		t.Errorf("tool message = %+v", result)
+	}
+
+	if _, err := p.CompleteTools(context.Background(), providers.Request{Model: "o3-pro", API: APIResponses}); err == nil {
+		t.Error("CompleteTools through the responses API succeeded")
+	}
+}
+
+func TestCompleteTools_Stream(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	if err := config.SetAPIKey("openai", "key"); err != nil {
+		t.Fatalf("SetAPIKey: %v", err)
+	}
+	events := []string{
+		`{"choices":[{"delta":{"content":"Let me check."}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"get_time","arguments":"{}"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Oslo\"}"}}]}}]}`,
+		`{"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+	}
+	var body strings.Builder
+	for _, e := range events {
+		fmt.Fprintf(&body, "data: %s\n\n", e)
+	}
+	body.WriteString("data: [DONE]\n\n")
+	p := NewProvider(func(p *provider) { p.client = &captureClient{resp: body.String()} })
+
+	var deltas []string
+	got, err := p.CompleteTools(context.Background(), providers.Request{
+		Model:    "gpt-4o",
+		Messages: []providers.Message{{Role: "user", Content: "Weather and time in Oslo?"}},
+		Tools:    []providers.Tool{{Name: "get_weather"}, {Name: "get_time"}},
+		OnDelta:  func(s string) { deltas = append(deltas, s) },
+	})
+	if err != nil {
+		t.Fatalf("CompleteTools error: %v", err)
+	}
+	want := []providers.ToolCall{
+		{ID: "call_a", Name: "get_weather", Arguments: `{"city":"Oslo"}`},
+		{ID: "call_b", Name: "get_time", Arguments: "{}"},
+	}
+	if got.Content != "Let me check." || !slices.Equal(got.ToolCalls, want) || !slices.Equal(deltas, []string{"Let me check."}) {
+		t.Errorf("CompleteTools = %+v, deltas %q; want calls %+v", got, deltas, want)
+	}
+}
+
+func TestCompleteTools_StreamIndex(t *testing.T) {
+	tmp := t.TempDir()
+	os.Setenv("XDG_CONFIG_HOME", tmp)
+	if err := config.SetAPIKey("openai", "key"); err != nil {
+		t.Fatalf("SetAPIKey: %v", err)
+	}
+	tests := []struct {
+		name    string
+		deltas  []string
+		want    []providers.ToolCall
+		wantErr string
+	}{
+		{
+			name: "no index",
+			deltas: []string{
+				`{"id":"call_a","function":{"name":"get_weather","arguments":"{\"city\":"}}`,
+				`{"function":{"arguments":"\"Oslo\"}"}}`,
+				`{"id":"call_b","function":{"name":"get_time","arguments":"{}"}}`,
+			},
+			want: []providers.ToolCall{
+				{ID: "call_a", Name: "get_weather", Arguments: `{"city":"Oslo"}`},
+				{ID: "call_b", Name: "get_time", Arguments: "{}"},
+			},
+		},
+		{name: "no index or ID first", deltas: []string{`{"function":{"arguments":"{}"}}`}, wantErr: "without an index or ID"},
+		{name: "negative index", deltas: []string{`{"index":-1,"id":"call_a"}`}, wantErr: "index -1"},
+		{name: "index past the end", deltas: []string{`{"index":0,"id":"call_a"}`, `{"index":1000000000}`}, wantErr: "index 1000000000 after 1 calls"},
+	}
+	for _, tc := range tests {
+		var body strings.Builder
+		for _, d := range tc.deltas {
+			fmt.Fprintf(&body, "data: {\"choices\":[{\"delta\":{\"tool_calls\":[%s]}}]}\n\n", d)
+		}
+		body.WriteString("data: [DONE]\n\n")
+		p := NewProvider(func(p *provider) { p.client = &captureClient{resp: body.String()} })
+		got, err := p.CompleteTools(context.Background(), providers.Request{
+			Model:    "gpt-4o",
+			Messages: []providers.Message{{Role: "user", Content: "hi"}},
+			Tools:    []providers.Tool{{Name: "get_weather"}, {Name: "get_time"}},
+			OnDelta:  func(string) {},
+		})
+		switch {
+		case tc.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%s: error = %v; want %q", tc.name, err, tc.wantErr)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case !slices.Equal(got.ToolCalls, tc.want):
+			t.Errorf("%s: calls = %+v; want %+v", tc.name, got.ToolCalls, tc.want)
+		}
+	}
+}
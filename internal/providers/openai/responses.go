@@ -0,0 +1,291 @@
+package openai
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"q/internal/providers"
+)
+
+// Transports selectable with providers.Request.API.
+const (
+	APIChat      = "chat"      // /chat/completions (default)
+	APIResponses = "responses" // /responses
+)
+
+const responsesPath = "/responses"
+
+// backgroundPoll is how often a background response is checked.
+var backgroundPoll = 2 * time.Second
+
+type responsesReq struct {
+	Model           string      `json:"model"`
+	Instructions    string      `json:"instructions,omitempty"`
+	Input           []inputItem `json:"input"`
+	Stream          bool        `json:"stream,omitempty"`
+	Background      bool        `json:"background,omitempty"`
+	Store           bool        `json:"store"`
+	Temperature     *float64    `json:"temperature,omitempty"`
+	TopP            *float64    `json:"top_p,omitempty"`
+	MaxOutputTokens int         `json:"max_output_tokens,omitempty"`
+	Reasoning       *reasoning  `json:"reasoning,omitempty"`
+}
+
+type reasoning struct {
+	Summary string `json:"summary"` // "auto", "concise" or "detailed"
+}
+
+// inputItem is a message in the input of a response. Content is a string,
+// or []inputPart when the message carries images.
+type inputItem struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type inputPart struct {
+	Type     string `json:"type"` // "input_text" or "input_image"
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+func inputContent(m providers.Message) any {
+	if len(m.Images) == 0 {
+		return m.Content
+	}
+	var parts []inputPart
+	if m.Content != "" {
+		parts = append(parts, inputPart{Type: "input_text", Text: m.Content})
+	}
+	for _, img := range m.Images {
+		parts = append(parts, inputPart{Type: "input_image", ImageURL: img.URL})
+	}
+	return parts
+}
+
+// response is a Responses API response object.
+type response struct {
+	ID     string `json:"id"`
+	Status string `json:"status"` // queued, in_progress, completed, incomplete, failed or cancelled
+	Output []struct {
+		Type    string `json:"type"` // "message", "reasoning", ...
+		Content []struct {
+			Type    string `json:"type"` // "output_text" or "refusal"
+			Text    string `json:"text"`
+			Refusal string `json:"refusal"`
+		} `json:"content"`
+		Summary []struct {
+			Text string `json:"text"`
+		} `json:"summary"`
+	} `json:"output"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	IncompleteDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details"`
+}
+
+// text returns the output text of r.
+func (r *response) text() string {
+	var b strings.Builder
+	for _, item := range r.Output {
+		if item.Type != "message" {
+			continue
+		}
+		for _, c := range item.Content {
+			b.WriteString(c.Text)
+			b.WriteString(c.Refusal)
+		}
+	}
+	return b.String()
+}
+
+// reasoningSummary returns the reasoning summaries in r, one per paragraph.
+func (r *response) reasoningSummary() string {
+	var parts []string
+	for _, item := range r.Output {
+		for _, s := range item.Summary {
+			parts = append(parts, s.Text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// err reports a response that did not complete.
+func (r *response) err(provider, model string) error {
+	switch r.Status {
+	case "failed":
+		if r.Error != nil {
+			return classifyError(provider, model, 0, nil, "", r.Error.Code, r.Error.Message)
+		}
+		return &providers.APIError{Provider: provider, Message: "response failed"}
+	case "cancelled":
+		return errors.New("openai: response cancelled")
+	case "incomplete":
+		if r.IncompleteDetails != nil && r.IncompleteDetails.Reason == "content_filter" {
+			return &providers.ContentFilteredError{Provider: provider}
+		}
+	}
+	return nil
+}
+
+// respond implements Complete with the Responses API. The conversation is
+// sent in full each time; nothing is stored unless r.Background needs it.
+func (p *provider) respond(ctx context.Context, r providers.Request) (string, error) {
+	req := responsesReq{
+		Model:           r.Model,
+		Instructions:    r.System,
+		Stream:          r.OnDelta != nil && !r.Background,
+		Background:      r.Background,
+		Store:           r.Background,
+		Temperature:     r.Params.Temperature,
+		TopP:            r.Params.TopP,
+		MaxOutputTokens: r.Params.MaxTokens,
+	}
+	if r.OnReasoning != nil {
+		req.Reasoning = &reasoning{Summary: "auto"}
+	}
+	for _, m := range r.Messages {
+		req.Input = append(req.Input, inputItem{Role: m.Role, Content: inputContent(m)})
+	}
+
+	body, _ := json.Marshal(req)
+	resp, err := p.do(ctx, http.MethodPost, responsesPath, body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(resp.Body)
+		return "", handleAPIError(p.Name(), r.Model, resp, responseBody)
+	}
+	if req.Stream {
+		return p.streamResponse(ctx, r, resp.Body)
+	}
+
+	var res response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	if r.Background {
+		if err := p.await(ctx, r.Model, &res); err != nil {
+			return "", err
+		}
+	}
+	if s := res.reasoningSummary(); s != "" && r.OnReasoning != nil {
+		r.OnReasoning(s)
+	}
+	out := res.text()
+	if err := res.err(p.Name(), r.Model); err != nil {
+		return out, err
+	}
+	if out == "" {
+		return "", errors.New("openai: empty response")
+	}
+	if r.OnDelta != nil {
+		r.OnDelta(out)
+	}
+	return out, nil
+}
+
+// await polls a background response until it finishes, cancelling it if ctx
+// is done first.
+func (p *provider) await(ctx context.Context, model string, res *response) error {
+	for res.Status == "queued" || res.Status == "in_progress" {
+		select {
+		case <-ctx.Done():
+			cctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if resp, err := p.do(cctx, http.MethodPost, responsesPath+"/"+res.ID+"/cancel", []byte("{}")); err == nil {
+				resp.Body.Close()
+			}
+			return ctx.Err()
+		case <-time.After(backgroundPoll):
+		}
+
+		resp, err := p.do(ctx, http.MethodGet, responsesPath+"/"+res.ID, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			responseBody, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return handleAPIError(p.Name(), model, resp, responseBody)
+		}
+		*res = response{}
+		err = json.NewDecoder(resp.Body).Decode(res)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamEvent is a server-sent event of a streamed response.
+type streamEvent struct {
+	Type     string    `json:"type"`
+	Delta    string    `json:"delta"`
+	Response *response `json:"response"`
+	Code     string    `json:"code"`
+	Message  string    `json:"message"`
+}
+
+// streamResponse reads the typed events of a streamed response, passing text
+// to r.OnDelta and reasoning summaries to r.OnReasoning.
+func (p *provider) streamResponse(ctx context.Context, r providers.Request, body io.Reader) (string, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20) // completed events repeat the whole output
+	var out strings.Builder
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			return out.String(), ctx.Err()
+		default:
+		}
+
+		line := scanner.Text()
+		if !strings.HasPrefix(line, ssePrefix) {
+			continue // event: lines repeat the type given in the data
+		}
+		var ev streamEvent
+		if json.Unmarshal([]byte(strings.TrimPrefix(line, ssePrefix)), &ev) != nil {
+			continue
+		}
+		switch ev.Type {
+		case "response.output_text.delta":
+			r.OnDelta(ev.Delta)
+			out.WriteString(ev.Delta)
+		case "response.refusal.delta":
+			r.OnDelta(ev.Delta)
+			out.WriteString(ev.Delta)
+		case "response.reasoning_summary_text.delta":
+			if r.OnReasoning != nil {
+				r.OnReasoning(ev.Delta)
+			}
+		case "response.reasoning_summary_part.done":
+			if r.OnReasoning != nil {
+				r.OnReasoning("\n\n")
+			}
+		case "response.completed", "response.incomplete", "response.failed":
+			if ev.Response != nil {
+				return out.String(), ev.Response.err(p.Name(), r.Model)
+			}
+			return out.String(), nil
+		case "error":
+			return out.String(), classifyError(p.Name(), r.Model, 0, nil, "", ev.Code, ev.Message)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return out.String(), err
+	}
+	return out.String(), fmt.Errorf("openai: response stream ended early")
+}
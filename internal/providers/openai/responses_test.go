@@ -0,0 +1,198 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"q/internal/config"
+	"q/internal/providers"
+)
+
+// scriptClient replies to each request with the next of replies, recording
+// what was asked.
+type scriptClient struct {
+	requests []string // "METHOD URL"
+	bodies   [][]byte
+	replies  []string
+}
+
+func (c *scriptClient) Do(req *http.Request) (*http.Response, error) {
+	c.requests = append(c.requests, req.Method+" "+req.URL.String())
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+	}
+	c.bodies = append(c.bodies, body)
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(reply))}, nil
+}
+
+func setupKey(t *testing.T) {
+	t.Helper()
+	os.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if err := config.SetAPIKey("openai", "key"); err != nil {
+		t.Fatalf("SetAPIKey: %v", err)
+	}
+}
+
+func TestRespond(t *testing.T) {
+	setupKey(t)
+	c := &scriptClient{replies: []string{`{"id":"resp_1","status":"completed","output":[
+		{"type":"reasoning","summary":[{"type":"summary_text","text":"Thinking about cats."}]},
+		{"type":"message","role":"assistant","content":[{"type":"output_text","text":"A cat."}]}]}`}}
+	p := NewProvider(func(p *provider) { p.client = c })
+
+	var summary string
+	got, err := p.Complete(context.Background(), providers.Request{
+		Model:  "o3",
+		API:    APIResponses,
+		System: "be brief",
+		Messages: []providers.Message{
+			{Role: "user", Content: "What is this?", Images: []providers.Image{{URL: "https://example.com/cat.png"}}},
+		},
+		Params:      providers.Params{MaxTokens: 100},
+		OnReasoning: func(s string) { summary += s },
+	})
+	if err != nil {
+		t.Fatalf("Complete error: %v", err)
+	}
+	if got != "A cat." || summary != "Thinking about cats." {
+		t.Errorf("Complete = %q, reasoning %q", got, summary)
+	}
+	if want := "POST https://api.openai.com/v1/responses"; c.requests[0] != want {
+		t.Errorf("request = %q; want %q", c.requests[0], want)
+	}
+
+	var sent map[string]any
+	if err := json.Unmarshal(c.bodies[0], &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent["instructions"] != "be brief" || sent["max_output_tokens"] != 100.0 || sent["store"] != false {
+		t.Errorf("request = %s", c.bodies[0])
+	}
+	if r, _ := sent["reasoning"].(map[string]any); r["summary"] != "auto" {
+		t.Errorf("reasoning = %v; want summaries requested", sent["reasoning"])
+	}
+	input := sent["input"].([]any)[0].(map[string]any)["content"].([]any)
+	if len(input) != 2 || input[1].(map[string]any)["type"] != "input_image" {
+		t.Errorf("input content = %v", input)
+	}
+}
+
+func TestRespond_Stream(t *testing.T) {
+	setupKey(t)
+	stream := strings.Join([]string{
+		"event: response.created",
+		`data: {"type":"response.created","response":{"id":"resp_1","status":"in_progress"}}`,
+		"",
+		`data: {"type":"response.reasoning_summary_text.delta","delta":"Hmm."}`,
+		`data: {"type":"response.output_text.delta","delta":"Hel"}`,
+		`data: {"type":"response.output_text.delta","delta":"lo"}`,
+		`data: {"type":"response.completed","response":{"id":"resp_1","status":"completed"}}`,
+		"",
+	}, "\n")
+	p := NewProvider(func(p *provider) { p.client = &scriptClient{replies: []string{stream}} })
+
+	var deltas []string
+	var reasoning string
+	got, err := p.Complete(context.Background(), providers.Request{
+		Model:       "o3",
+		API:         APIResponses,
+		Messages:    []providers.Message{{Role: "user", Content: "hi"}},
+		OnDelta:     func(s string) { deltas = append(deltas, s) },
+		OnReasoning: func(s string) { reasoning += s },
+	})
+	if err != nil {
+		t.Fatalf("Complete error: %v", err)
+	}
+	if got != "Hello" || strings.Join(deltas, "|") != "Hel|lo" || reasoning != "Hmm." {
+		t.Errorf("got %q, deltas %q, reasoning %q", got, deltas, reasoning)
+	}
+}
+
+func TestRespond_StreamErrors(t *testing.T) {
+	setupKey(t)
+	for stream, check := range map[string]func(error) bool{
+		`data: {"type":"response.failed","response":{"status":"failed","error":{"code":"server_error","message":"boom"}}}`: func(err error) bool {
+			var e *providers.ServerError
+			return errors.As(err, &e)
+		},
+		`data: {"type":"error","code":"rate_limit_exceeded","message":"slow down"}`: func(err error) bool {
+			var e *providers.RateLimitedError
+			return errors.As(err, &e)
+		},
+		`data: {"type":"response.incomplete","response":{"status":"incomplete","incomplete_details":{"reason":"content_filter"}}}`: func(err error) bool {
+			var e *providers.ContentFilteredError
+			return errors.As(err, &e)
+		},
+		`data: {"type":"response.output_text.delta","delta":"cut"}`: func(err error) bool {
+			return err != nil && strings.Contains(err.Error(), "ended early")
+		},
+	} {
+		p := NewProvider(func(p *provider) { p.client = &scriptClient{replies: []string{stream + "\n"}} })
+		_, err := p.Complete(context.Background(), providers.Request{
+			Model: "o3", API: APIResponses,
+			Messages: []providers.Message{{Role: "user", Content: "hi"}},
+			OnDelta:  func(string) {},
+		})
+		if !check(err) {
+			t.Errorf("stream %s: error %v", stream, err)
+		}
+	}
+}
+
+func TestRespond_Background(t *testing.T) {
+	setupKey(t)
+	backgroundPoll = 0
+	c := &scriptClient{replies: []string{
+		`{"id":"resp_9","status":"queued"}`,
+		`{"id":"resp_9","status":"in_progress"}`,
+		`{"id":"resp_9","status":"completed","output":[{"type":"message","content":[{"type":"output_text","text":"done"}]}]}`,
+	}}
+	p := NewProvider(func(p *provider) { p.client = c })
+
+	var streamed string
+	got, err := p.Complete(context.Background(), providers.Request{
+		Model:      "o3-pro",
+		Background: true,
+		Messages:   []providers.Message{{Role: "user", Content: "think hard"}},
+		OnDelta:    func(s string) { streamed += s },
+	})
+	if err != nil {
+		t.Fatalf("Complete error: %v", err)
+	}
+	if got != "done" || streamed != "done" {
+		t.Errorf("Complete = %q, streamed %q; want the output delivered once done", got, streamed)
+	}
+	want := []string{
+		"POST https://api.openai.com/v1/responses",
+		"GET https://api.openai.com/v1/responses/resp_9",
+		"GET https://api.openai.com/v1/responses/resp_9",
+	}
+	if strings.Join(c.requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests = %q; want %q", c.requests, want)
+	}
+	var sent map[string]any
+	if err := json.Unmarshal(c.bodies[0], &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent["background"] != true || sent["store"] != true || sent["stream"] != nil {
+		t.Errorf("request = %s; want a stored, non-streamed background request", c.bodies[0])
+	}
+}
+
+func TestComplete_UnknownAPI(t *testing.T) {
+	setupKey(t)
+	p := NewProvider()
+	_, err := p.Complete(context.Background(), providers.Request{Model: "gpt-4o", API: "assistants"})
+	if err == nil || !strings.Contains(err.Error(), "unknown API") {
+		t.Errorf("Complete error = %v; want unknown API", err)
+	}
+}
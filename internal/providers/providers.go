@@ -2,6 +2,7 @@ package providers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"slices"
 	"sync"
@@ -36,6 +37,158 @@ type Provider interface {
 	ResetChat()
 }
 
+// Message is a single turn in a conversation.
+type Message struct {
+	Role    string // "system", "user", "assistant" or "tool"
+	Content string
+	Images  []Image // sent after Content; user messages only
+
+	// ToolCalls are the calls an assistant message asks for.
+	ToolCalls []ToolCall `json:",omitempty"`
+	// ToolCallID names the call a tool message holds the result of.
+	ToolCallID string `json:",omitempty"`
+}
+
+// Tool is a function the model may ask to have called instead of, or
+// before, answering.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage // JSON Schema of the arguments object
+}
+
+// ToolCall is a model's request to call a Tool.
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string // JSON object
+}
+
+// Image is a picture attached to a message. URL is an http(s) URL or a
+// base64 data URL.
+type Image struct {
+	URL string
+}
+
+// Params holds optional sampling parameters. Zero values mean "use the
+// provider default".
+type Params struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+}
+
+// IsZero reports whether no parameter is set.
+func (p Params) IsZero() bool {
+	return p.Temperature == nil && p.TopP == nil && p.MaxTokens == 0
+}
+
+// WithDefaults returns p with every unset parameter taken from d.
+func (p Params) WithDefaults(d Params) Params {
+	if p.Temperature == nil {
+		p.Temperature = d.Temperature
+	}
+	if p.TopP == nil {
+		p.TopP = d.TopP
+	}
+	if p.MaxTokens == 0 {
+		p.MaxTokens = d.MaxTokens
+	}
+	return p
+}
+
+// Request is a full completion request, used by Completer.
+type Request struct {
+	Model    string
+	System   string
+	Messages []Message
+	Params   Params
+
+	// OnDelta, when set, turns on streaming and receives each token as it
+	// arrives. The provider does not print anything itself.
+	OnDelta func(string)
+
+	// OnReasoning, when set, asks for summaries of the model's reasoning
+	// and receives them as they arrive, if the provider offers them.
+	OnReasoning func(string)
+
+	// API selects a provider-specific transport, such as OpenAI's
+	// "responses"; empty means the provider's default.
+	API string
+
+	// Background runs the request as a job the provider completes
+	// asynchronously, for long-running models. Output arrives at the end.
+	Background bool
+
+	// Tools are offered to the model by ToolCompleter.
+	Tools []Tool
+}
+
+// Completer is implemented by providers that accept a full Request with a
+// system prompt, explicit message history and sampling parameters.
+type Completer interface {
+	Complete(ctx context.Context, req Request) (string, error)
+}
+
+// Complete sends req to p as is: through Complete where p is a Completer,
+// otherwise as a bare prompt, which cannot carry a system prompt,
+// parameters, images, message history or a choice of API.
+func Complete(ctx context.Context, p Provider, req Request) (string, error) {
+	if c, ok := p.(Completer); ok {
+		return c.Complete(ctx, req)
+	}
+	if req.System != "" || !req.Params.IsZero() || len(req.Messages) != 1 || len(req.Messages[0].Images) > 0 {
+		return "", fmt.Errorf("%s does not support system prompts, parameters, images or message history", p.Name())
+	}
+	if req.API != "" || req.Background {
+		return "", fmt.Errorf("%s does not support --api or --background", p.Name())
+	}
+	resp, err := p.Prompt(ctx, req.Model, req.Messages[0].Content)
+	if err == nil && req.OnDelta != nil {
+		req.OnDelta(resp)
+	}
+	return resp, err
+}
+
+// ToolCompleter is implemented by Completers that support function calling.
+// The reply to a Request with Tools is an assistant message that may ask for
+// tool calls; their results go back in "tool" messages, and the model is
+// asked again.
+type ToolCompleter interface {
+	CompleteTools(ctx context.Context, req Request) (Message, error)
+}
+
+// ModelLister is implemented by providers that can ask their API which models
+// are available, so models released after SupportedModels was written can be
+// used.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+// Embedder is implemented by providers that turn text into vectors for
+// semantic search and clustering.
+type Embedder interface {
+	// EmbeddingModels returns the embedding models offered, the default
+	// first.
+	EmbeddingModels() []string
+
+	// Embed returns one vector per input, in order. Large inputs are sent in
+	// as many requests as the provider's limits need.
+	Embed(ctx context.Context, model string, inputs []string) ([][]float32, error)
+}
+
+// KeyOptional is implemented by providers that can be used without an API
+// key, such as local or mock backends.
+type KeyOptional interface {
+	NeedsKey() bool
+}
+
+// NeedsKey reports whether p must be given an API key.
+func NeedsKey(p Provider) bool {
+	k, ok := p.(KeyOptional)
+	return !ok || k.NeedsKey()
+}
+
 // Registry stores and manages named providers.
 type Registry struct {
 	mu   sync.RWMutex
@@ -83,23 +236,3 @@ func (r *Registry) Names() []string {
 	slices.Sort(names)
 	return names
 }
-
-// InvalidAPIKeyError represents an invalid API key error that any provider can return
-type InvalidAPIKeyError struct {
-	Provider string
-}
-
-func (e *InvalidAPIKeyError) Error() string {
-	return fmt.Sprintf(
-		"Invalid API key for %s. Set your key with:\n  "+
-			"q keys set --provider %s --key YOUR_API_KEY",
-		e.Provider,
-		e.Provider,
-	)
-}
-
-// IsInvalidAPIKeyError checks if an error is an InvalidAPIKeyError
-func IsInvalidAPIKeyError(err error) bool {
-	_, ok := err.(*InvalidAPIKeyError)
-	return ok
-}
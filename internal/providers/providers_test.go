@@ -2,8 +2,13 @@ package providers_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"reflect"
 	"testing"
+	"time"
 
 	"q/internal/providers"
 )
@@ -89,3 +94,59 @@ func TestMultipleProvidersRegistration(t *testing.T) {
 		t.Errorf("reg.Lookup(\"provider2\") = %v, %v; want %v, true", got2, ok2, p2)
 	}
 }
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&providers.APIError{StatusCode: http.StatusTooManyRequests}, true},
+		{&providers.APIError{StatusCode: http.StatusServiceUnavailable}, true},
+		{fmt.Errorf("wrapped: %w", &providers.APIError{StatusCode: 529}), true},
+		{&providers.APIError{StatusCode: http.StatusBadRequest}, false},
+		{&providers.InvalidAPIKeyError{Provider: "openai"}, false},
+		{io.ErrUnexpectedEOF, true},
+		{context.Canceled, false},
+	}
+	for _, c := range cases {
+		if got := providers.IsTransient(c.err); got != c.want {
+			t.Errorf("IsTransient(%v) = %v; want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestAPIErrorMessage(t *testing.T) {
+	withMsg := &providers.APIError{StatusCode: 429, Message: "Rate limit exceeded"}
+	if got := withMsg.Error(); got != "API error: Rate limit exceeded" {
+		t.Errorf("Error() = %q", got)
+	}
+	withBody := &providers.APIError{StatusCode: 500, Body: "oops"}
+	if got := withBody.Error(); got != "API request failed with status 500: oops" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestErrorsAs(t *testing.T) {
+	var err error = fmt.Errorf("send: %w", &providers.RateLimitedError{Provider: "openai", RetryAfter: 1500 * time.Millisecond})
+
+	var rateErr *providers.RateLimitedError
+	if !errors.As(err, &rateErr) || rateErr.RetryAfter != 1500*time.Millisecond {
+		t.Fatalf("errors.As failed for RateLimitedError: %v", err)
+	}
+	if got := err.Error(); got != "send: rate limited by openai (retry after 1.5s)" {
+		t.Errorf("Error() = %q", got)
+	}
+
+	timeout := &providers.TimeoutError{Provider: "openai", Err: context.DeadlineExceeded}
+	if !errors.Is(timeout, context.DeadlineExceeded) {
+		t.Error("TimeoutError should unwrap to its cause")
+	}
+	if !providers.IsInvalidAPIKeyError(fmt.Errorf("wrapped: %w", &providers.InvalidAPIKeyError{Provider: "x"})) {
+		t.Error("IsInvalidAPIKeyError should see through wrapping")
+	}
+
+	ctxErr := &providers.ContextLengthExceededError{Limit: 100, Requested: 120}
+	if got := ctxErr.Error(); got != "context length exceeded: 120 tokens requested, limit is 100" {
+		t.Errorf("Error() = %q", got)
+	}
+}
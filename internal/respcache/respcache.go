@@ -0,0 +1,264 @@
+// Package respcache keeps model responses on disk, in
+// $XDG_CACHE_HOME/q/responses, so that identical requests from scripts and CI
+// are answered without calling the API again. Each response is a file named
+// after the request's hash; its modification time records when it was last
+// used, for least-recently-used eviction.
+package respcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"q/internal/config"
+	"q/internal/fsutil"
+	"q/internal/providers"
+)
+
+// Defaults for config.CacheConfig.
+const (
+	DefaultTTL     = 7 * 24 * time.Hour
+	DefaultMaxSize = 100 << 20 // bytes
+)
+
+const ext = ".json"
+
+// Entry is a cached response.
+type Entry struct {
+	Provider  string    `json:"provider"`
+	Model     string    `json:"model"`
+	Response  string    `json:"response"`
+	CreatedAt time.Time `json:"created_at"`
+	Hits      int       `json:"hits"`
+}
+
+// Cache is a directory of Entries.
+type Cache struct {
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+	now     func() time.Time
+}
+
+// New returns a cache stored in dir.
+func New(dir string, ttl time.Duration, maxSize int64) *Cache {
+	return &Cache{dir: dir, ttl: ttl, maxSize: maxSize, now: time.Now}
+}
+
+// Open returns the default cache in config.CacheDir, with the TTL and size
+// cap of cfg.
+func Open(cfg config.CacheConfig) (*Cache, error) {
+	dir, err := config.CacheDir()
+	if err != nil {
+		return nil, err
+	}
+	ttl := DefaultTTL
+	if cfg.TTL != "" {
+		if ttl, err = time.ParseDuration(cfg.TTL); err != nil {
+			return nil, err
+		}
+	}
+	maxSize := int64(DefaultMaxSize)
+	if cfg.MaxSize > 0 {
+		maxSize = int64(cfg.MaxSize) << 20
+	}
+	return New(filepath.Join(dir, "responses"), ttl, maxSize), nil
+}
+
+// Dir returns the directory holding the cache.
+func (c *Cache) Dir() string { return c.dir }
+
+// TTL returns how long responses are reused.
+func (c *Cache) TTL() time.Duration { return c.ttl }
+
+// MaxSize returns the size, in bytes, above which entries are evicted.
+func (c *Cache) MaxSize() int64 { return c.maxSize }
+
+// Key returns the cache key of req sent to provider, which should also name
+// the base URL when one is configured: a hash of everything that shapes the
+// response. Callbacks are left out.
+func Key(provider string, req providers.Request) string {
+	data, _ := json.Marshal(struct {
+		Provider   string
+		Model      string
+		System     string
+		Messages   []providers.Message
+		Params     providers.Params
+		API        string
+		Background bool
+		Tools      []providers.Tool `json:",omitempty"`
+	}{provider, req.Model, req.System, req.Messages, req.Params, req.API, req.Background, req.Tools})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) path(key string) string { return filepath.Join(c.dir, key+ext) }
+
+// Get returns the response stored under key, unless it is older than the
+// TTL, and counts the hit.
+func (c *Cache) Get(key string) (Entry, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return Entry{}, false
+	}
+	var e Entry
+	if json.Unmarshal(data, &e) != nil || c.now().Sub(e.CreatedAt) >= c.ttl {
+		os.Remove(c.path(key))
+		return Entry{}, false
+	}
+	e.Hits++
+	// Rewriting the entry also marks it as recently used.
+	_ = c.write(key, e)
+	return e, true
+}
+
+// Put stores a response under key, then evicts the least recently used
+// entries if the cache has outgrown its size cap.
+func (c *Cache) Put(key, provider, model, response string) error {
+	e := Entry{Provider: provider, Model: model, Response: response, CreatedAt: c.now().UTC()}
+	if err := c.write(key, e); err != nil {
+		return err
+	}
+	return c.evict()
+}
+
+func (c *Cache) write(key string, e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(c.path(key), data, 0o600); err != nil {
+		return err
+	}
+	now := c.now()
+	return os.Chtimes(c.path(key), now, now)
+}
+
+type file struct {
+	path string
+	size int64
+	used time.Time
+}
+
+func (c *Cache) files() ([]file, error) {
+	entries, err := os.ReadDir(c.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []file
+	for _, d := range entries {
+		if !strings.HasSuffix(d.Name(), ext) || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue // removed meanwhile
+		}
+		files = append(files, file{filepath.Join(c.dir, d.Name()), info.Size(), info.ModTime()})
+	}
+	return files, nil
+}
+
+// evict removes expired entries, then the least recently used ones until
+// the cache fits its size cap.
+func (c *Cache) evict() error {
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(files, func(a, b file) int { return a.used.Compare(b.used) })
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	for _, f := range files {
+		// An entry unused for longer than the TTL is certainly expired.
+		if total <= c.maxSize && c.now().Sub(f.used) < c.ttl {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		total -= f.size
+	}
+	return nil
+}
+
+// Stats summarizes the cache's contents.
+type Stats struct {
+	Entries int
+	Size    int64 // bytes
+	Hits    int   // times entries were reused
+	Oldest  time.Time
+	Newest  time.Time
+}
+
+// Stats returns the cache's current Stats.
+func (c *Cache) Stats() (Stats, error) {
+	files, err := c.files()
+	if err != nil {
+		return Stats{}, err
+	}
+	var s Stats
+	for _, f := range files {
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			continue
+		}
+		var e Entry
+		if json.Unmarshal(data, &e) != nil {
+			continue
+		}
+		s.Entries++
+		s.Size += f.size
+		s.Hits += e.Hits
+		if s.Oldest.IsZero() || e.CreatedAt.Before(s.Oldest) {
+			s.Oldest = e.CreatedAt
+		}
+		if e.CreatedAt.After(s.Newest) {
+			s.Newest = e.CreatedAt
+		}
+	}
+	return s, nil
+}
+
+// Clear removes every entry and returns how many there were.
+func (c *Cache) Clear() (int, error) {
+	files, err := c.files()
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range files {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, err
+		}
+	}
+	return len(files), nil
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying c, for requests made with it to use.
+func NewContext(ctx context.Context, c *Cache) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the cache carried by ctx, or nil.
+func FromContext(ctx context.Context) *Cache {
+	c, _ := ctx.Value(contextKey{}).(*Cache)
+	return c
+}
@@ -0,0 +1,128 @@
+package respcache
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"q/internal/providers"
+)
+
+func newTestCache(t *testing.T, ttl time.Duration, maxSize int64) (*Cache, *time.Time) {
+	t.Helper()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := New(t.TempDir(), ttl, maxSize)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestKey(t *testing.T) {
+	temp := 0.5
+	base := providers.Request{Model: "gpt-4o", Messages: []providers.Message{{Role: "user", Content: "hi"}}}
+	k := Key("openai", base)
+
+	same := base
+	same.OnDelta = func(string) {}
+	if Key("openai", same) != k {
+		t.Error("Key depends on OnDelta")
+	}
+
+	for name, req := range map[string]providers.Request{
+		"model":    {Model: "gpt-4.1", Messages: base.Messages},
+		"system":   {Model: "gpt-4o", System: "be brief", Messages: base.Messages},
+		"messages": {Model: "gpt-4o", Messages: []providers.Message{{Role: "user", Content: "hello"}}},
+		"params":   {Model: "gpt-4o", Messages: base.Messages, Params: providers.Params{Temperature: &temp}},
+		"images":   {Model: "gpt-4o", Messages: []providers.Message{{Role: "user", Content: "hi", Images: []providers.Image{{URL: "x"}}}}},
+		"tools":    {Model: "gpt-4o", Messages: base.Messages, Tools: []providers.Tool{{Name: "f"}}},
+	} {
+		if Key("openai", req) == k {
+			t.Errorf("Key ignores the %s", name)
+		}
+	}
+	if Key("other", base) == k {
+		t.Error("Key ignores the provider")
+	}
+}
+
+func TestGetPut(t *testing.T) {
+	c, now := newTestCache(t, time.Hour, DefaultMaxSize)
+	if _, ok := c.Get("k"); ok {
+		t.Fatal("Get on an empty cache hit")
+	}
+	if err := c.Put("k", "openai", "gpt-4o", "hello"); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+	e, ok := c.Get("k")
+	if !ok || e.Response != "hello" || e.Model != "gpt-4o" || e.Hits != 1 {
+		t.Errorf("Get = %+v, %v", e, ok)
+	}
+	c.Get("k")
+
+	s, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Entries != 1 || s.Hits != 2 || s.Size == 0 || !s.Oldest.Equal(*now) {
+		t.Errorf("Stats = %+v", s)
+	}
+
+	*now = now.Add(time.Hour)
+	if _, ok := c.Get("k"); ok {
+		t.Error("Get of an expired entry hit")
+	}
+	if s, _ := c.Stats(); s.Entries != 0 {
+		t.Errorf("expired entry kept: %+v", s)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	c, now := newTestCache(t, time.Hour, 1<<20)
+	put := func(key string) {
+		t.Helper()
+		*now = now.Add(time.Second)
+		if err := c.Put(key, "openai", "gpt-4o", strings.Repeat("x", 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("a")
+	put("b")
+	s, _ := c.Stats()
+	c.maxSize = s.Size * 5 / 4 // room for two entries, not three
+
+	*now = now.Add(time.Second)
+	c.Get("a") // a is now used more recently than b
+	put("c")
+
+	if _, err := os.Stat(c.path("b")); !os.IsNotExist(err) {
+		t.Error("least recently used entry b was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, err := os.Stat(c.path(key)); err != nil {
+			t.Errorf("entry %s evicted: %v", key, err)
+		}
+	}
+}
+
+func TestClear(t *testing.T) {
+	c, _ := newTestCache(t, time.Hour, DefaultMaxSize)
+	c.Put("a", "openai", "gpt-4o", "1")
+	c.Put("b", "openai", "gpt-4o", "2")
+	if n, err := c.Clear(); n != 2 || err != nil {
+		t.Errorf("Clear = %d, %v; want 2", n, err)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Error("Get after Clear hit")
+	}
+}
+
+func TestContext(t *testing.T) {
+	if FromContext(context.Background()) != nil {
+		t.Error("FromContext of a bare context is not nil")
+	}
+	c := New(t.TempDir(), time.Hour, DefaultMaxSize)
+	if FromContext(NewContext(context.Background(), c)) != c {
+		t.Error("FromContext does not return the cache")
+	}
+}
@@ -0,0 +1,122 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// ProviderPlaceholder is replaced with the provider name in command templates.
+const ProviderPlaceholder = "{provider}"
+
+// NotFoundStatus is the exit status with which a get or delete command reports
+// that it has no key for the provider. It is the one macOS security(1) uses.
+// Any other non-zero status is an error.
+const NotFoundStatus = 44
+
+// errNotFound is returned by runShell for a command exiting with
+// NotFoundStatus.
+var errNotFound = errors.New("no such secret")
+
+// CommandConfig configures the command backend. Each field is a shell command
+// in which {provider} is replaced with the provider name, for example
+// "pass show q/{provider}" or "op read op://Private/{provider}/credential".
+//
+// A get command that has no key for the provider prints nothing or exits
+// with NotFoundStatus, e.g. "pass show q/{provider} 2>/dev/null || exit 44".
+type CommandConfig struct {
+	Get    string // prints the key on its first line of output
+	Set    string // reads the key from stdin; optional
+	Delete string // optional
+}
+
+type commandBackend struct {
+	cfg CommandConfig
+
+	mu    sync.Mutex
+	cache map[string]string // keys fetched so far; commands can be slow
+}
+
+// NewCommand returns a backend that delegates to an external secret manager.
+// Keys are fetched at most once per process.
+func NewCommand(cfg CommandConfig) Backend {
+	return &commandBackend{cfg: cfg, cache: make(map[string]string)}
+}
+
+func (b *commandBackend) Name() string { return Command }
+
+func (b *commandBackend) Get(provider string) (string, error) {
+	if b.cfg.Get == "" {
+		return "", fmt.Errorf("command backend: no get command configured")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if key, ok := b.cache[provider]; ok {
+		return key, nil
+	}
+	out, err := runShell(b.cfg.Get, provider, "")
+	if errors.Is(err, errNotFound) {
+		out, err = "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(out, "\n")
+	b.cache[provider] = strings.TrimSpace(line)
+	return b.cache[provider], nil
+}
+
+func (b *commandBackend) Set(provider, key string) error {
+	if b.cfg.Set == "" {
+		return fmt.Errorf("command backend has no set command: %w", ErrUnsupported)
+	}
+	if _, err := runShell(b.cfg.Set, provider, key+"\n"); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.cache[provider] = key
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *commandBackend) Delete(provider string) error {
+	if b.cfg.Delete == "" {
+		return fmt.Errorf("command backend has no delete command: %w", ErrUnsupported)
+	}
+	if _, err := runShell(b.cfg.Delete, provider, ""); err != nil && !errors.Is(err, errNotFound) {
+		return err
+	}
+	b.mu.Lock()
+	delete(b.cache, provider)
+	b.mu.Unlock()
+	return nil
+}
+
+func runShell(tmpl, provider, stdin string) (string, error) {
+	line := strings.ReplaceAll(tmpl, ProviderPlaceholder, provider)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", line)
+	} else {
+		cmd = exec.Command("sh", "-c", line)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && exit.ExitCode() == NotFoundStatus {
+			return "", fmt.Errorf("secret command %q: %w", line, errNotFound)
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("secret command %q failed: %s", line, msg)
+	}
+	return stdout.String(), nil
+}
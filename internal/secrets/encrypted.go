@@ -0,0 +1,211 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"q/internal/fsutil"
+)
+
+const (
+	kdfName       = "pbkdf2-sha256"
+	kdfIterations = 600_000
+	saltSize      = 16
+	keySize       = 32 // AES-256
+)
+
+// ErrWrongPassphrase is returned when the encrypted file cannot be opened.
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted key file")
+
+// encryptedFile is the on-disk format of the encrypted backend. The plaintext
+// is a JSON object mapping provider names to keys.
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type encryptedBackend struct {
+	path       string
+	passphrase func() (string, error)
+	iterations int
+
+	mu     sync.Mutex
+	secret string // cached passphrase, asked for at most once
+
+	// The derived key is expensive, so the last one is kept and the salt is
+	// reused across rewrites of the same file.
+	salt []byte
+	aead cipher.AEAD
+}
+
+// NewEncrypted returns a backend that keeps keys in a file encrypted with
+// AES-256-GCM under a key derived from a passphrase with PBKDF2-SHA256.
+// passphrase is called lazily, once, when the file is first read or written.
+func NewEncrypted(path string, passphrase func() (string, error)) Backend {
+	return &encryptedBackend{path: path, passphrase: passphrase, iterations: kdfIterations}
+}
+
+func (b *encryptedBackend) Name() string { return Encrypted }
+
+func (b *encryptedBackend) Get(provider string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys, err := b.read()
+	if err != nil {
+		return "", err
+	}
+	return keys[provider], nil
+}
+
+func (b *encryptedBackend) Set(provider, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	unlock, err := fsutil.Lock(b.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	keys, err := b.read()
+	if err != nil {
+		return err
+	}
+	keys[provider] = key
+	return b.write(keys)
+}
+
+func (b *encryptedBackend) Delete(provider string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	unlock, err := fsutil.Lock(b.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	keys, err := b.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := keys[provider]; !ok {
+		return nil
+	}
+	delete(keys, provider)
+	return b.write(keys)
+}
+
+func (b *encryptedBackend) getPassphrase() (string, error) {
+	if b.secret != "" {
+		return b.secret, nil
+	}
+	s, err := b.passphrase()
+	if err != nil {
+		return "", err
+	}
+	if s == "" {
+		return "", errors.New("empty passphrase")
+	}
+	b.secret = s
+	return s, nil
+}
+
+func (b *encryptedBackend) gcm(salt []byte, iterations int) (cipher.AEAD, error) {
+	if b.aead != nil && bytes.Equal(salt, b.salt) && iterations == b.iterations {
+		return b.aead, nil
+	}
+	pass, err := b.getPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	key, err := pbkdf2.Key(sha256.New, pass, salt, iterations, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	b.salt, b.iterations, b.aead = salt, iterations, aead
+	return aead, nil
+}
+
+// read decrypts the key file. A missing file holds no keys and does not
+// prompt for the passphrase.
+func (b *encryptedBackend) read() (map[string]string, error) {
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]string), nil
+		}
+		return nil, err
+	}
+	var f encryptedFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.path, err)
+	}
+	if f.Version != 1 || f.KDF != kdfName {
+		return nil, fmt.Errorf("%s: unsupported key file version %d (%s)", b.path, f.Version, f.KDF)
+	}
+	aead, err := b.gcm(f.Salt, f.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, f.Nonce, f.Ciphertext, nil)
+	if err != nil {
+		b.secret, b.aead = "", nil // let the caller try another passphrase
+		return nil, ErrWrongPassphrase
+	}
+	keys := make(map[string]string)
+	if err := json.Unmarshal(plain, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (b *encryptedBackend) write(keys map[string]string) error {
+	plain, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	f := encryptedFile{Version: 1, KDF: kdfName, Iterations: b.iterations, Salt: b.salt}
+	if f.Salt == nil {
+		f.Salt = make([]byte, saltSize)
+		if _, err := rand.Read(f.Salt); err != nil {
+			return err
+		}
+	}
+	aead, err := b.gcm(f.Salt, f.Iterations)
+	if err != nil {
+		return err
+	}
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return err
+	}
+	f.Ciphertext = aead.Seal(nil, f.Nonce, plain, nil)
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0o700); err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(b.path, data, 0o600)
+}
@@ -0,0 +1,101 @@
+// Package secrets stores provider API keys behind pluggable backends.
+package secrets
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Backend names.
+const (
+	Plaintext = "plaintext"
+	Encrypted = "encrypted"
+	Command   = "command"
+)
+
+// Names lists every backend name, in display order.
+var Names = []string{Plaintext, Encrypted, Command}
+
+// ErrUnsupported is returned by backends that cannot perform an operation,
+// such as a read-only command backend asked to store a key.
+var ErrUnsupported = errors.New("operation not supported by secret backend")
+
+// Backend stores one API key per provider.
+type Backend interface {
+	// Name returns the backend name, one of Names.
+	Name() string
+
+	// Get returns the key for provider, or empty if none is stored.
+	Get(provider string) (string, error)
+
+	// Set stores key for provider, replacing any previous key.
+	Set(provider, key string) error
+
+	// Delete removes the key for provider. Deleting a missing key is not an
+	// error.
+	Delete(provider string) error
+}
+
+// mapBackend keeps keys in a map loaded from and saved to somewhere else,
+// typically the api_keys section of config.json.
+type mapBackend struct {
+	load   func() (map[string]string, error)
+	update func(func(map[string]string) bool) error
+}
+
+// NewPlaintext returns a backend that stores keys unencrypted. load returns
+// the whole key map. update must load the map, apply fn to it and save it if
+// fn reports a change, as one atomic step.
+func NewPlaintext(
+	load func() (map[string]string, error),
+	update func(fn func(keys map[string]string) (changed bool)) error,
+) Backend {
+	return &mapBackend{load: load, update: update}
+}
+
+func (b *mapBackend) Name() string { return Plaintext }
+
+func (b *mapBackend) Get(provider string) (string, error) {
+	keys, err := b.load()
+	if err != nil {
+		return "", err
+	}
+	return keys[provider], nil
+}
+
+func (b *mapBackend) Set(provider, key string) error {
+	return b.update(func(keys map[string]string) bool {
+		keys[provider] = key
+		return true
+	})
+}
+
+func (b *mapBackend) Delete(provider string) error {
+	return b.update(func(keys map[string]string) bool {
+		if _, ok := keys[provider]; !ok {
+			return false
+		}
+		delete(keys, provider)
+		return true
+	})
+}
+
+// Migrate copies the keys of the given providers from src to dst. It returns
+// the providers that had a key.
+func Migrate(src, dst Backend, providers []string) ([]string, error) {
+	var moved []string
+	for _, p := range providers {
+		key, err := src.Get(p)
+		if err != nil {
+			return moved, fmt.Errorf("read %s key from %s: %w", p, src.Name(), err)
+		}
+		if key == "" {
+			continue
+		}
+		if err := dst.Set(p, key); err != nil {
+			return moved, fmt.Errorf("write %s key to %s: %w", p, dst.Name(), err)
+		}
+		moved = append(moved, p)
+	}
+	return moved, nil
+}
@@ -0,0 +1,165 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestEncrypted(t *testing.T, path, pass string) *encryptedBackend {
+	t.Helper()
+	b := NewEncrypted(path, func() (string, error) { return pass, nil }).(*encryptedBackend)
+	b.iterations = 1000 // keep tests fast
+	return b
+}
+
+func TestPlaintext(t *testing.T) {
+	store := map[string]string{}
+	saves := 0
+	b := NewPlaintext(
+		func() (map[string]string, error) { return store, nil },
+		func(fn func(map[string]string) bool) error {
+			m := maps.Clone(store)
+			if fn(m) {
+				store = m
+				saves++
+			}
+			return nil
+		},
+	)
+	if err := b.Set("openai", "k1"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if got, _ := b.Get("openai"); got != "k1" {
+		t.Errorf("Get = %q; want k1", got)
+	}
+	if err := b.Delete("openai"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := b.Delete("openai"); err != nil || saves != 2 {
+		t.Errorf("second Delete = %v with %d saves; want nil and no extra save", err, saves)
+	}
+}
+
+func TestEncrypted_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.enc")
+	b := newTestEncrypted(t, path, "correct horse")
+
+	if got, err := b.Get("openai"); err != nil || got != "" {
+		t.Fatalf("Get on missing file = %q, %v; want empty", got, err)
+	}
+	if err := b.Set("openai", "sk-secret"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if strings.Contains(string(data), "sk-secret") {
+		t.Error("key file contains the plaintext key")
+	}
+
+	// A fresh backend must re-derive the key from the passphrase.
+	again := newTestEncrypted(t, path, "correct horse")
+	if got, err := again.Get("openai"); err != nil || got != "sk-secret" {
+		t.Errorf("Get = %q, %v; want sk-secret", got, err)
+	}
+
+	wrong := newTestEncrypted(t, path, "battery staple")
+	if _, err := wrong.Get("openai"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Get with wrong passphrase = %v; want ErrWrongPassphrase", err)
+	}
+}
+
+func TestEncrypted_ConcurrentSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.enc")
+	// Separate backends stand in for separate q processes.
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := range 8 {
+		b := newTestEncrypted(t, path, "pass")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- b.Set(fmt.Sprint("p", i), "k")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := newTestEncrypted(t, path, "pass")
+	for i := range 8 {
+		if got, err := b.Get(fmt.Sprint("p", i)); err != nil || got != "k" {
+			t.Errorf("Get(p%d) = %q, %v; want k (a concurrent Set was lost)", i, got, err)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+	dir := t.TempDir()
+	b := NewCommand(CommandConfig{
+		Get: "cat " + dir + "/{provider} 2>/dev/null || true",
+		Set: "cat > " + dir + "/{provider}",
+	})
+
+	if got, err := b.Get("openai"); err != nil || got != "" {
+		t.Fatalf("Get before Set = %q, %v; want empty", got, err)
+	}
+	if err := b.Set("openai", "sk-cmd"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	fresh := NewCommand(CommandConfig{Get: "cat " + dir + "/{provider}"})
+	if got, err := fresh.Get("openai"); err != nil || got != "sk-cmd" {
+		t.Errorf("Get = %q, %v; want sk-cmd", got, err)
+	}
+	if err := fresh.Set("openai", "x"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Set without set command = %v; want ErrUnsupported", err)
+	}
+
+	missing := NewCommand(CommandConfig{Get: "echo 'not in the store' >&2; exit 44", Delete: "exit 44"})
+	if got, err := missing.Get("openai"); err != nil || got != "" {
+		t.Errorf("Get exiting with NotFoundStatus = %q, %v; want no key", got, err)
+	}
+	if err := missing.Delete("openai"); err != nil {
+		t.Errorf("Delete exiting with NotFoundStatus = %v; want nil", err)
+	}
+
+	failing := NewCommand(CommandConfig{Get: "echo nope >&2; exit 1"})
+	if _, err := failing.Get("openai"); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("Get with failing command = %v; want error with stderr", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	store := map[string]string{"openai": "k1"}
+	src := NewPlaintext(
+		func() (map[string]string, error) { return store, nil },
+		func(fn func(map[string]string) bool) error { fn(store); return nil },
+	)
+	dst := newTestEncrypted(t, filepath.Join(t.TempDir(), "keys.enc"), "pass")
+
+	moved, err := Migrate(src, dst, []string{"anthropic", "openai"})
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if len(moved) != 1 || moved[0] != "openai" {
+		t.Errorf("moved = %v; want [openai]", moved)
+	}
+	if got, _ := dst.Get("openai"); got != "k1" {
+		t.Errorf("dst.Get = %q; want k1", got)
+	}
+}
@@ -0,0 +1,538 @@
+// Package server exposes the providers of a Registry over HTTP as an
+// OpenAI-compatible API, so editors and scripts written for OpenAI can reach
+// every provider through one local endpoint with the keys q manages.
+//
+// It serves POST /v1/chat/completions, streaming or not, GET /v1/models and
+// GET /v1/models/{provider}/{model}. Requests name their model as
+// provider/model.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"q/internal/providers"
+)
+
+// maxRequestSize bounds request bodies, which may carry base64 images.
+const maxRequestSize = 32 << 20
+
+// Server is an http.Handler for the OpenAI chat completions and models
+// endpoints, backed by a Registry.
+type Server struct {
+	Registry *providers.Registry
+
+	// Route turns a request's model into a provider and model name. It may
+	// also expand aliases and check that the provider has a key. The
+	// default splits provider/model.
+	Route func(model string) (provider, name string, err error)
+
+	// Models returns the models of a provider listed by /v1/models. The
+	// default is its SupportedModels.
+	Models func(providers.Provider) []string
+
+	// Complete answers a request routed to p. It may also apply configured
+	// parameters and limits, or a response cache. The default is
+	// providers.Complete.
+	Complete func(ctx context.Context, p providers.Provider, req providers.Request) (string, error)
+
+	// Tokens maps client names to the bearer tokens they must send. When
+	// empty, requests need no token.
+	Tokens map[string]string
+
+	// Log, if set, gets a line per request.
+	Log io.Writer
+
+	now func() time.Time
+	mux *http.ServeMux
+}
+
+// New returns a Server for r with the default Route, Models and Complete.
+func New(r *providers.Registry) *Server {
+	s := &Server{
+		Registry: r,
+		Route:    splitModel,
+		Models:   providers.Provider.SupportedModels,
+		Complete: providers.Complete,
+		now:      time.Now,
+	}
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("POST /v1/chat/completions", s.chatCompletions)
+	s.mux.HandleFunc("GET /v1/models", s.listModels)
+	s.mux.HandleFunc("GET /v1/models/{id...}", s.getModel)
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "not_found", fmt.Sprintf("no route for %s %s", r.Method, r.URL.Path))
+	})
+	return s
+}
+
+func splitModel(model string) (string, string, error) {
+	provider, name, ok := strings.Cut(model, "/")
+	if !ok || provider == "" || name == "" {
+		return "", "", fmt.Errorf("model %q: want provider/model, e.g. openai/gpt-4o", model)
+	}
+	return provider, name, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := s.now()
+	rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+	client, ok := s.authenticate(r)
+	switch {
+	case !ok:
+		writeError(rec, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "missing or unknown bearer token")
+	case len(s.Tokens) == 0 && !isLocalHost(r.Host):
+		writeError(rec, http.StatusForbidden, "invalid_request_error", "", fmt.Sprintf("host %q is not allowed without tokens; use localhost", r.Host))
+	case len(s.Tokens) == 0 && r.Method == http.MethodPost && !isJSON(r.Header.Get("Content-Type")):
+		writeError(rec, http.StatusUnsupportedMediaType, "invalid_request_error", "", "Content-Type must be application/json")
+	default:
+		r = r.WithContext(context.WithValue(r.Context(), logKey{}, &rec.model))
+		s.mux.ServeHTTP(rec, r)
+	}
+	if s.Log != nil {
+		fmt.Fprintf(s.Log, "%s %s %s %s %s %d %dms\n",
+			start.UTC().Format(time.RFC3339), client, r.Method, r.URL.Path, orDash(rec.model), rec.status, s.now().Sub(start).Milliseconds())
+	}
+}
+
+// authenticate returns the name of the client whose token r carries.
+func (s *Server) authenticate(r *http.Request) (string, bool) {
+	if len(s.Tokens) == 0 {
+		return "-", true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "-", false
+	}
+	for client, t := range s.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return client, true
+		}
+	}
+	return "-", false
+}
+
+// Without tokens, any web page the user visits could reach the server. The
+// Host check stops pages that rebind their own name to 127.0.0.1 from
+// reading answers, and requiring JSON forces a CORS preflight, which the
+// server never answers, on requests from other origins.
+
+// isLocalHost reports whether a Host header names this machine.
+func isLocalHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.Trim(hostport, "[]")
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func isJSON(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	return err == nil && t == "application/json"
+}
+
+// logKey carries a pointer to the model the request log names.
+type logKey struct{}
+
+func logModel(ctx context.Context, model string) {
+	if p, ok := ctx.Value(logKey{}).(*string); ok {
+		*p = model
+	}
+}
+
+// recorder notes the status of a response, for the log.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	model  string
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Wire types of the OpenAI chat completions API.
+type (
+	chatRequest struct {
+		Model               string        `json:"model"`
+		Messages            []chatMessage `json:"messages"`
+		Stream              bool          `json:"stream"`
+		Temperature         *float64      `json:"temperature"`
+		TopP                *float64      `json:"top_p"`
+		MaxTokens           int           `json:"max_tokens"`
+		MaxCompletionTokens int           `json:"max_completion_tokens"`
+
+		// Refused rather than ignored, so clients relying on them learn.
+		Tools          json.RawMessage `json:"tools"`
+		ToolChoice     json.RawMessage `json:"tool_choice"`
+		Functions      json.RawMessage `json:"functions"`
+		FunctionCall   json.RawMessage `json:"function_call"`
+		Stop           json.RawMessage `json:"stop"`
+		N              *int            `json:"n"`
+		ResponseFormat *struct {
+			Type string `json:"type"`
+		} `json:"response_format"`
+	}
+
+	chatMessage struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+
+	contentPart struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		ImageURL struct {
+			URL string `json:"url"`
+		} `json:"image_url"`
+	}
+
+	completion struct {
+		ID      string   `json:"id"`
+		Object  string   `json:"object"`
+		Created int64    `json:"created"`
+		Model   string   `json:"model"`
+		Choices []choice `json:"choices"`
+	}
+
+	choice struct {
+		Index        int      `json:"index"`
+		Message      *message `json:"message,omitempty"`
+		Delta        *message `json:"delta,omitempty"`
+		FinishReason *string  `json:"finish_reason"`
+	}
+
+	message struct {
+		Role    string `json:"role,omitempty"`
+		Content string `json:"content,omitempty"`
+	}
+
+	model struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		Created int64  `json:"created"`
+		OwnedBy string `json:"owned_by"`
+	}
+
+	apiError struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Code    string `json:"code,omitempty"`
+		} `json:"error"`
+	}
+)
+
+// unsupportedError is a request parameter the server cannot honour.
+type unsupportedError string
+
+func (e unsupportedError) Error() string { return string(e) + " is not supported by q serve" }
+
+// unsupported returns the first parameter of c that cannot be honoured.
+func (c chatRequest) unsupported() error {
+	for _, p := range []struct {
+		name  string
+		value json.RawMessage
+	}{
+		{"tools", c.Tools}, {"tool_choice", c.ToolChoice}, {"functions", c.Functions},
+		{"function_call", c.FunctionCall}, {"stop", c.Stop},
+	} {
+		if len(p.value) > 0 && string(p.value) != "null" {
+			return unsupportedError(p.name)
+		}
+	}
+	if c.N != nil && *c.N != 1 {
+		return unsupportedError("n")
+	}
+	if c.ResponseFormat != nil && c.ResponseFormat.Type != "text" {
+		return unsupportedError("response_format")
+	}
+	return nil
+}
+
+// request converts c into a providers.Request. System and developer
+// messages become the system prompt.
+func (c chatRequest) request() (providers.Request, error) {
+	var (
+		req    providers.Request
+		system []string
+	)
+	if err := c.unsupported(); err != nil {
+		return req, err
+	}
+	for i, m := range c.Messages {
+		text, images, err := parseContent(m.Content)
+		if err != nil {
+			return req, fmt.Errorf("messages[%d].content: %w", i, err)
+		}
+		switch m.Role {
+		case "system", "developer":
+			system = append(system, text)
+		case "user", "assistant":
+			if len(images) > 0 && m.Role != "user" {
+				return req, fmt.Errorf("messages[%d]: only user messages may hold images", i)
+			}
+			req.Messages = append(req.Messages, providers.Message{Role: m.Role, Content: text, Images: images})
+		default:
+			return req, fmt.Errorf("messages[%d]: role %q is not supported", i, m.Role)
+		}
+	}
+	if len(req.Messages) == 0 {
+		return req, errors.New("messages: want at least one user message")
+	}
+	req.System = strings.Join(system, "\n\n")
+	req.Params = providers.Params{Temperature: c.Temperature, TopP: c.TopP, MaxTokens: c.MaxTokens}
+	if c.MaxCompletionTokens > 0 {
+		req.Params.MaxTokens = c.MaxCompletionTokens
+	}
+	return req, nil
+}
+
+// parseContent reads message content: a string or a list of text and
+// image_url parts.
+func parseContent(raw json.RawMessage) (string, []providers.Image, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil, nil
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, nil, nil
+	}
+	var parts []contentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", nil, errors.New("want a string or a list of parts")
+	}
+	var (
+		text   []string
+		images []providers.Image
+	)
+	for _, p := range parts {
+		switch p.Type {
+		case "text":
+			text = append(text, p.Text)
+		case "image_url":
+			images = append(images, providers.Image{URL: p.ImageURL.URL})
+		default:
+			return "", nil, fmt.Errorf("part type %q is not supported", p.Type)
+		}
+	}
+	return strings.Join(text, "\n"), images, nil
+}
+
+func (s *Server) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	var c chatRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err := dec.Decode(&c); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "", "invalid JSON body: "+err.Error())
+		return
+	}
+	logModel(r.Context(), c.Model)
+	req, err := c.request()
+	if err != nil {
+		code := ""
+		if errors.As(err, new(unsupportedError)) {
+			code = "unsupported_parameter"
+		}
+		writeError(w, http.StatusBadRequest, "invalid_request_error", code, err.Error())
+		return
+	}
+	providerName, name, err := s.Route(c.Model)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
+	}
+	p, ok := s.Registry.Lookup(providerName)
+	if !ok {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "model_not_found", "unknown provider: "+providerName)
+		return
+	}
+	req.Model = name
+	id := newID()
+	out := completion{ID: id, Created: s.now().Unix(), Model: providerName + "/" + name}
+	logModel(r.Context(), out.Model)
+
+	if !c.Stream {
+		resp, err := s.Complete(r.Context(), p, req)
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		stop := "stop"
+		out.Object = "chat.completion"
+		out.Choices = []choice{{Message: &message{Role: "assistant", Content: resp}, FinishReason: &stop}}
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+
+	// Headers are sent with the first token, so errors before it still get
+	// a proper status.
+	out.Object = "chat.completion.chunk"
+	started := false
+	send := func(c choice) {
+		out.Choices = []choice{c}
+		data, _ := json.Marshal(out)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		w.(http.Flusher).Flush()
+	}
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		send(choice{Delta: &message{Role: "assistant"}})
+	}
+	req.OnDelta = func(s string) {
+		start()
+		send(choice{Delta: &message{Content: s}})
+	}
+	if _, err := s.Complete(r.Context(), p, req); err != nil {
+		if !started {
+			writeProviderError(w, err)
+			return
+		}
+		_, e := errorFor(err)
+		data, _ := json.Marshal(e)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		return
+	}
+	start()
+	stop := "stop"
+	send(choice{Delta: &message{}, FinishReason: &stop})
+	io.WriteString(w, "data: [DONE]\n\n")
+	w.(http.Flusher).Flush()
+}
+
+func (s *Server) listModels(w http.ResponseWriter, r *http.Request) {
+	list := struct {
+		Object string  `json:"object"`
+		Data   []model `json:"data"`
+	}{Object: "list", Data: []model{}}
+	for _, name := range s.Registry.Names() {
+		p, _ := s.Registry.Lookup(name)
+		for _, m := range s.Models(p) {
+			list.Data = append(list.Data, model{ID: name + "/" + m, Object: "model", OwnedBy: name})
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getModel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	logModel(r.Context(), id)
+	if providerName, name, ok := strings.Cut(id, "/"); ok {
+		if p, ok := s.Registry.Lookup(providerName); ok {
+			for _, m := range s.Models(p) {
+				if m == name {
+					writeJSON(w, http.StatusOK, model{ID: id, Object: "model", OwnedBy: providerName})
+					return
+				}
+			}
+		}
+	}
+	writeError(w, http.StatusNotFound, "invalid_request_error", "model_not_found", fmt.Sprintf("unknown model %q", id))
+}
+
+// errorFor returns the HTTP status and OpenAI error body reporting err.
+// Problems with q's own API keys are the gateway's, not the client's, so
+// they are reported as 502 rather than 401.
+func errorFor(err error) (int, apiError) {
+	var (
+		status    = http.StatusInternalServerError
+		typ, code = "api_error", ""
+
+		keyErr      *providers.InvalidAPIKeyError
+		rateErr     *providers.RateLimitedError
+		quotaErr    *providers.QuotaExceededError
+		contextErr  *providers.ContextLengthExceededError
+		filterErr   *providers.ContentFilteredError
+		notFoundErr *providers.ModelNotFoundError
+		serverErr   *providers.ServerError
+		timeoutErr  *providers.TimeoutError
+		apiErr      *providers.APIError
+	)
+	switch {
+	case errors.As(err, &keyErr):
+		status, code = http.StatusBadGateway, "invalid_upstream_api_key"
+	case errors.As(err, &rateErr):
+		status, typ, code = http.StatusTooManyRequests, "rate_limit_error", "rate_limit_exceeded"
+	case errors.As(err, &quotaErr):
+		status, typ, code = http.StatusTooManyRequests, "insufficient_quota", "insufficient_quota"
+	case errors.As(err, &contextErr):
+		status, typ, code = http.StatusBadRequest, "invalid_request_error", "context_length_exceeded"
+	case errors.As(err, &filterErr):
+		status, typ, code = http.StatusBadRequest, "invalid_request_error", "content_filter"
+	case errors.As(err, &notFoundErr):
+		status, typ, code = http.StatusNotFound, "invalid_request_error", "model_not_found"
+	case errors.As(err, &serverErr):
+		status, typ = http.StatusBadGateway, "server_error"
+	case errors.As(err, &timeoutErr), errors.Is(err, context.DeadlineExceeded):
+		status, typ = http.StatusGatewayTimeout, "server_error"
+	case errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500:
+		status, typ = apiErr.StatusCode, "invalid_request_error"
+	case errors.As(err, &apiErr):
+		status = http.StatusBadGateway
+	}
+	var e apiError
+	e.Error.Message, e.Error.Type, e.Error.Code = err.Error(), typ, code
+	return status, e
+}
+
+func writeProviderError(w http.ResponseWriter, err error) {
+	status, e := errorFor(err)
+	var rateErr *providers.RateLimitedError
+	if errors.As(err, &rateErr) && rateErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(rateErr.RetryAfter.Round(time.Second)/time.Second)))
+	}
+	writeJSON(w, status, e)
+}
+
+func writeError(w http.ResponseWriter, status int, typ, code, msg string) {
+	var e apiError
+	e.Error.Message, e.Error.Type, e.Error.Code = msg, typ, code
+	writeJSON(w, status, e)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,339 @@
+// Package templates stores reusable prompt templates.
+//
+// A template is a Go text/template file in $XDG_CONFIG_HOME/q/templates, or
+// in the templates directory of a project config, with an optional
+// front-matter block:
+//
+//	---
+//	description: Review a diff
+//	model: openai/gpt-4.1
+//	system: You are a meticulous code reviewer.
+//	temperature: 0.2
+//	required: diff, lang
+//	---
+//	Review this {{.lang}} change:
+//
+//	{{.diff}}
+package templates
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"q/internal/config"
+	"q/internal/providers"
+)
+
+const (
+	ext       = ".tmpl"
+	fenceLine = "---"
+)
+
+// Template is a parsed prompt template.
+type Template struct {
+	Name        string
+	Description string
+	Model       string // provider/model; empty means the default model
+	System      string
+	Params      providers.Params
+	Required    []string
+	Body        string
+}
+
+// Dir returns the directory templates are stored in.
+func Dir() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "templates"), nil
+}
+
+// Path returns the file path for the named template in Dir, where new
+// templates are created. The file need not exist.
+func Path(name string) (string, error) {
+	if err := validateName(name); err != nil {
+		return "", err
+	}
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+ext), nil
+}
+
+func validateName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid template name %q", name)
+	}
+	return nil
+}
+
+// Dirs returns the directories templates are loaded from, in search order:
+// the project config's templates directory, if any, then Dir.
+func Dirs() ([]string, error) {
+	r, err := config.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return r.TemplateDirs, nil
+}
+
+// Locate returns the file the named template is loaded from: the first match
+// in Dirs.
+func Locate(name string) (string, error) {
+	if err := validateName(name); err != nil {
+		return "", err
+	}
+	dirs, err := Dirs()
+	if err != nil {
+		return "", err
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("template %q not found\n\nSee available: q templates list", name)
+}
+
+// List returns all templates sorted by name. A project template hides a
+// user template of the same name. Missing directories are not an error.
+func List() ([]Template, error) {
+	dirs, err := Dirs()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var out []Template
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, e := range entries {
+			name := strings.TrimSuffix(e.Name(), ext)
+			if e.IsDir() || filepath.Ext(e.Name()) != ext || seen[name] {
+				continue
+			}
+			seen[name] = true
+			t, err := Load(name)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, t)
+		}
+	}
+	slices.SortFunc(out, func(a, b Template) int { return strings.Compare(a.Name, b.Name) })
+	return out, nil
+}
+
+// Load reads and parses the named template.
+func Load(name string) (Template, error) {
+	path, err := Locate(name)
+	if err != nil {
+		return Template{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Template{}, err
+	}
+	t, err := Parse(name, string(data))
+	if err != nil {
+		return Template{}, fmt.Errorf("template %s: %w", name, err)
+	}
+	return t, nil
+}
+
+// Create writes a new template file with the given contents. It fails if the
+// template already exists.
+func Create(name, contents string) (string, error) {
+	path, err := Path(name)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		if os.IsExist(err) {
+			return "", fmt.Errorf("template %q already exists", name)
+		}
+		return "", err
+	}
+	if _, err := f.WriteString(contents); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// Skeleton is the starting point for `q templates new`.
+const Skeleton = `---
+description:
+model:
+system:
+required: input
+---
+{{.input}}
+`
+
+// Parse parses template source with optional front-matter.
+func Parse(name, src string) (Template, error) {
+	t := Template{Name: name, Body: src}
+
+	rest, ok := strings.CutPrefix(src, fenceLine+"\n")
+	if !ok {
+		rest, ok = strings.CutPrefix(src, fenceLine+"\r\n")
+	}
+	if ok {
+		front, body, found := cutFence(rest)
+		if !found {
+			return Template{}, errors.New("unterminated front-matter")
+		}
+		if err := t.parseFrontMatter(front); err != nil {
+			return Template{}, err
+		}
+		t.Body = body
+	}
+
+	if _, err := t.compile(); err != nil {
+		return Template{}, err
+	}
+	return t, nil
+}
+
+// cutFence splits s at the first line consisting solely of the fence.
+func cutFence(s string) (front, body string, ok bool) {
+	for off := 0; off < len(s); {
+		end := strings.IndexByte(s[off:], '\n')
+		line := s[off:]
+		if end >= 0 {
+			line = s[off : off+end]
+		}
+		if strings.TrimRight(line, "\r") == fenceLine {
+			if end < 0 {
+				return s[:off], "", true
+			}
+			return s[:off], s[off+end+1:], true
+		}
+		if end < 0 {
+			break
+		}
+		off += end + 1
+	}
+	return "", "", false
+}
+
+func (t *Template) parseFrontMatter(front string) error {
+	sc := bufio.NewScanner(strings.NewReader(front))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("front-matter line %d: expected key: value", n)
+		}
+		key, val = strings.TrimSpace(key), unquote(strings.TrimSpace(val))
+		if val == "" {
+			continue
+		}
+		var err error
+		switch key {
+		case "description":
+			t.Description = val
+		case "model":
+			t.Model = val
+		case "system":
+			t.System = val
+		case "required":
+			t.Required = splitList(val)
+		case "temperature":
+			t.Params.Temperature, err = parseFloat(val)
+		case "top_p":
+			t.Params.TopP, err = parseFloat(val)
+		case "max_tokens":
+			t.Params.MaxTokens, err = strconv.Atoi(val)
+		default:
+			return fmt.Errorf("front-matter line %d: unknown key %q", n, key)
+		}
+		if err != nil {
+			return fmt.Errorf("front-matter line %d: invalid %s: %w", n, key, err)
+		}
+	}
+	return sc.Err()
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		if s[0] == '"' {
+			if u, err := strconv.Unquote(s); err == nil {
+				return u
+			}
+		}
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func splitList(s string) []string {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func parseFloat(s string) (*float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (t Template) compile() (*template.Template, error) {
+	return template.New(t.Name).Option("missingkey=error").Parse(t.Body)
+}
+
+// Render executes the template with vars. All required variables must be
+// present.
+func (t Template) Render(vars map[string]string) (string, error) {
+	var missing []string
+	for _, name := range t.Required {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing required variables for %s: %s\n\nSet with: --var NAME=VALUE",
+			t.Name, strings.Join(missing, ", "))
+	}
+
+	tmpl, err := t.compile()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
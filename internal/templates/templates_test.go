@@ -0,0 +1,121 @@
+package templates
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse_FrontMatter(t *testing.T) {
+	src := "---\n" +
+		"description: Review a diff\n" +
+		"model: openai/gpt-4.1\n" +
+		"system: \"You are a reviewer.\"\n" +
+		"temperature: 0.2\n" +
+		"max_tokens: 500\n" +
+		"required: [diff, lang]\n" +
+		"---\n" +
+		"Review this {{.lang}} change:\n{{.diff}}\n"
+	tmpl, err := Parse("review", src)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if tmpl.Description != "Review a diff" {
+		t.Errorf("Description = %q", tmpl.Description)
+	}
+	if tmpl.Model != "openai/gpt-4.1" {
+		t.Errorf("Model = %q", tmpl.Model)
+	}
+	if tmpl.System != "You are a reviewer." {
+		t.Errorf("System = %q", tmpl.System)
+	}
+	if tmpl.Params.Temperature == nil || *tmpl.Params.Temperature != 0.2 {
+		t.Errorf("Temperature = %v; want 0.2", tmpl.Params.Temperature)
+	}
+	if tmpl.Params.MaxTokens != 500 {
+		t.Errorf("MaxTokens = %d; want 500", tmpl.Params.MaxTokens)
+	}
+	if want := []string{"diff", "lang"}; !reflect.DeepEqual(tmpl.Required, want) {
+		t.Errorf("Required = %v; want %v", tmpl.Required, want)
+	}
+	if !strings.HasPrefix(tmpl.Body, "Review this") {
+		t.Errorf("Body = %q", tmpl.Body)
+	}
+}
+
+func TestParse_NoFrontMatter(t *testing.T) {
+	tmpl, err := Parse("plain", "Summarize: {{.input}}")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if tmpl.Body != "Summarize: {{.input}}" || tmpl.Model != "" {
+		t.Errorf("unexpected template: %+v", tmpl)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	cases := map[string]string{
+		"unterminated": "---\nmodel: a/b\n",
+		"unknown key":  "---\ncolor: red\n---\nbody",
+		"bad float":    "---\ntemperature: hot\n---\nbody",
+		"bad template": "{{.x",
+	}
+	for name, src := range cases {
+		if _, err := Parse(name, src); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestRender_RequiredVars(t *testing.T) {
+	tmpl, err := Parse("t", "---\nrequired: a, b\n---\n{{.a}}-{{.b}}")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if _, err := tmpl.Render(map[string]string{"a": "1"}); err == nil || !strings.Contains(err.Error(), "b") {
+		t.Errorf("expected missing variable error mentioning b, got %v", err)
+	}
+	got, err := tmpl.Render(map[string]string{"a": "1", "b": "2"})
+	if err != nil {
+		t.Fatalf("Render error: %v", err)
+	}
+	if got != "1-2" {
+		t.Errorf("Render = %q; want %q", got, "1-2")
+	}
+}
+
+func TestCreateLoadList(t *testing.T) {
+	os.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	if ts, err := List(); err != nil || len(ts) != 0 {
+		t.Fatalf("List on empty dir = %v, %v; want none", ts, err)
+	}
+	if _, err := Create("b", "---\ndescription: second\n---\nB"); err != nil {
+		t.Fatalf("Create b: %v", err)
+	}
+	if _, err := Create("a", Skeleton); err != nil {
+		t.Fatalf("Create a: %v", err)
+	}
+	if _, err := Create("a", Skeleton); err == nil {
+		t.Error("expected error creating duplicate template")
+	}
+
+	ts, err := List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(ts) != 2 || ts[0].Name != "a" || ts[1].Name != "b" {
+		t.Fatalf("List = %+v; want a, b", ts)
+	}
+	if ts[1].Description != "second" {
+		t.Errorf("Description = %q; want %q", ts[1].Description, "second")
+	}
+
+	if _, err := Load("missing"); err == nil {
+		t.Error("expected error loading missing template")
+	}
+	if _, err := Path("../escape"); err == nil {
+		t.Error("expected error for path traversal name")
+	}
+}
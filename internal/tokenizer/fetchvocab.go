@@ -0,0 +1,59 @@
+//go:build ignore
+
+// fetchvocab downloads the published vocabularies into the vocab directory,
+// checking each against its pinned SHA-256 sum, to embed them in the binary.
+// Run it with go generate.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"q/internal/tokenizer"
+)
+
+func main() {
+	log.SetFlags(0)
+	for _, name := range slices.Sorted(maps.Keys(tokenizer.Checksums)) {
+		if err := fetch(name, tokenizer.Checksums[name]); err != nil {
+			log.Fatalf("fetchvocab: %s: %v", name, err)
+		}
+	}
+}
+
+func fetch(name, want string) error {
+	path := filepath.Join("vocab", name+".tiktoken")
+	if data, err := os.ReadFile(path); err == nil && sum(data) == want {
+		return nil
+	}
+	resp, err := http.Get(tokenizer.VocabURL + name + ".tiktoken")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET: %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if got := sum(data); got != want {
+		return fmt.Errorf("SHA-256 %s; want %s", got, want)
+	}
+	fmt.Printf("fetched %s\n", path)
+	return os.WriteFile(path, data, 0o644)
+}
+
+func sum(data []byte) string {
+	s := sha256.Sum256(data)
+	return hex.EncodeToString(s[:])
+}
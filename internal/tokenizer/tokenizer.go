@@ -0,0 +1,354 @@
+// Package tokenizer counts tokens with byte-pair encodings compatible with
+// OpenAI's cl100k_base and o200k_base.
+//
+// A vocabulary is read from the vocab directory, embedded at build time after
+// go generate fetched it, or from $XDG_CACHE_HOME/q/tokenizer, where Fetch
+// downloads it; see vocab/README.md. Either way it must match its published
+// SHA-256 sum. Get never downloads, so counting a prompt cannot stall on the
+// network.
+package tokenizer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"q/internal/config"
+	"q/internal/fsutil"
+)
+
+// Encoding names.
+const (
+	CL100K = "cl100k_base"
+	O200K  = "o200k_base"
+)
+
+// ws is the \s of the published patterns, which, unlike Go's, is Unicode
+// aware.
+const ws = `\t\n\v\f\r\p{Z}\x{85}`
+
+// patterns split text into pieces before byte-pair merging. They are the
+// published ones with the \s+(?!\S) alternative, which Go cannot express,
+// left to split.
+var patterns = map[string]string{
+	CL100K: `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^` + ws + `\p{L}\p{N}]+[\r\n]*|[` + ws + `]*[\r\n]+|[` + ws + `]+`,
+	O200K: `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|\p{N}{1,3}| ?[^` + ws + `\p{L}\p{N}]+[\r\n/]*|[` + ws + `]*[\r\n]+|[` + ws + `]+`,
+}
+
+var isSpace = regexp.MustCompile(`^[` + ws + `]+$`)
+
+// Checksums are the SHA-256 sums of the published vocabularies, as pinned
+// by tiktoken.
+var Checksums = map[string]string{
+	CL100K: "223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7",
+	O200K:  "446a9538cb6c348e3516120d7c08b09f57c36495e2acfffe59a5bf8b0cfb1a2d",
+}
+
+// VocabURL is where the published vocabularies are downloaded from.
+var VocabURL = "https://openaipublic.blob.core.windows.net/encodings/"
+
+// fetchClient downloads vocabularies; they are a few megabytes each.
+var fetchClient = &http.Client{Timeout: time.Minute}
+
+//go:generate go run fetchvocab.go
+
+//go:embed vocab
+var embedded embed.FS
+
+// ErrNoVocab means an encoding's vocabulary is neither embedded nor in the
+// cache directory. Fetch downloads it.
+var ErrNoVocab = errors.New("vocabulary not installed")
+
+// Encoding is a loaded byte-pair encoding.
+type Encoding struct {
+	name    string
+	ranks   map[string]int
+	decoder map[int]string
+	pat     *regexp.Regexp
+}
+
+var (
+	mu     sync.Mutex
+	loaded = make(map[string]*Encoding)
+	failed = make(map[string]error)
+)
+
+// Get returns the named encoding, loading its vocabulary on first use. A
+// vocabulary that cannot be loaded is not tried again by this process.
+func Get(name string) (*Encoding, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if e, ok := loaded[name]; ok {
+		return e, nil
+	}
+	if err, ok := failed[name]; ok {
+		return nil, err
+	}
+	e, err := load(name)
+	if err != nil {
+		failed[name] = err
+		return nil, err
+	}
+	loaded[name] = e
+	return e, nil
+}
+
+func load(name string) (*Encoding, error) {
+	pattern, ok := patterns[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+	data, err := readVocab(name)
+	if err != nil {
+		return nil, err
+	}
+	ranks, err := parseVocab(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return newEncoding(name, ranks, pattern), nil
+}
+
+func readVocab(name string) ([]byte, error) {
+	file := name + ".tiktoken"
+	data, err := embedded.ReadFile("vocab/" + file)
+	if err == nil {
+		return data, verify(name, data)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	path, err := cachePath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err = os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w (no %s)", name, ErrNoVocab, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(name, data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return data, nil
+}
+
+// cachePath returns where Fetch stores the named vocabulary.
+func cachePath(name string) (string, error) {
+	dir, err := config.CacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tokenizer", name+".tiktoken"), nil
+}
+
+// Fetch downloads the named vocabulary from VocabURL into the cache
+// directory, unless it is embedded or already there, and lets Get load it.
+func Fetch(name string) error {
+	if _, ok := patterns[name]; !ok {
+		return fmt.Errorf("unknown encoding %q", name)
+	}
+	if _, err := readVocab(name); !errors.Is(err, ErrNoVocab) {
+		return err
+	}
+	path, err := cachePath(name)
+	if err != nil {
+		return err
+	}
+	data, err := download(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(path, data, 0o600); err != nil {
+		return err
+	}
+	mu.Lock()
+	delete(failed, name)
+	mu.Unlock()
+	return nil
+}
+
+// download fetches the named vocabulary from VocabURL and verifies it.
+func download(name string) ([]byte, error) {
+	resp, err := fetchClient.Get(VocabURL + name + ".tiktoken")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download: %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return data, verify(name, data)
+}
+
+// verify checks data against the published sum of the named vocabulary.
+func verify(name string, data []byte) error {
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != Checksums[name] {
+		return fmt.Errorf("%s: SHA-256 %s does not match the published %s", name, got, Checksums[name])
+	}
+	return nil
+}
+
+// parseVocab reads the tiktoken format: base64 token, space, rank.
+func parseVocab(data []byte) (map[string]int, error) {
+	ranks := make(map[string]int, 200_000)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		tok, rank, ok := bytes.Cut(line, []byte(" "))
+		if !ok {
+			return nil, fmt.Errorf("line %d: want TOKEN RANK", n)
+		}
+		b, err := base64.StdEncoding.DecodeString(string(tok))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		r, err := strconv.Atoi(string(rank))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		ranks[string(b)] = r
+	}
+	return ranks, sc.Err()
+}
+
+func newEncoding(name string, ranks map[string]int, pattern string) *Encoding {
+	decoder := make(map[int]string, len(ranks))
+	for tok, r := range ranks {
+		decoder[r] = tok
+	}
+	return &Encoding{name: name, ranks: ranks, decoder: decoder, pat: regexp.MustCompile(pattern)}
+}
+
+// Name returns the encoding's name, e.g. "o200k_base".
+func (e *Encoding) Name() string { return e.name }
+
+// Encode returns the tokens of text. Special tokens such as <|endoftext|>
+// are encoded as ordinary text.
+func (e *Encoding) Encode(text string) []int {
+	var out []int
+	for _, piece := range e.split(text) {
+		if r, ok := e.ranks[piece]; ok {
+			out = append(out, r)
+			continue
+		}
+		out = e.bytePairEncode(piece, out)
+	}
+	return out
+}
+
+// Count returns the number of tokens in text.
+func (e *Encoding) Count(text string) int { return len(e.Encode(text)) }
+
+// Decode returns the text of tokens. Unknown tokens are skipped.
+func (e *Encoding) Decode(tokens []int) string {
+	var b []byte
+	for _, t := range tokens {
+		b = append(b, e.decoder[t]...)
+	}
+	return string(b)
+}
+
+// split cuts text into the pieces merged independently. A run of spaces
+// followed by a word gives up its last space to the word, as \s+(?!\S)
+// does in the published patterns.
+func (e *Encoding) split(text string) []string {
+	var pieces []string
+	for len(text) > 0 {
+		loc := e.pat.FindStringIndex(text)
+		if loc == nil {
+			pieces = append(pieces, text)
+			break
+		}
+		end := loc[1]
+		if m := text[loc[0]:end]; end < len(text) && isSpace.MatchString(m) && !strings.ContainsAny(m, "\r\n") {
+			if next, _ := utf8.DecodeRuneInString(text[end:]); !isSpace.MatchString(string(next)) {
+				if _, size := utf8.DecodeLastRuneInString(m); size < len(m) {
+					end -= size
+				}
+			}
+		}
+		if loc[0] > 0 {
+			pieces = append(pieces, text[:loc[0]])
+		}
+		pieces = append(pieces, text[loc[0]:end])
+		text = text[end:]
+	}
+	return pieces
+}
+
+// bytePairEncode appends the tokens of piece, repeatedly merging the
+// adjacent pair with the lowest rank. As in tiktoken, the rank of each pair
+// is kept alongside its start, so a merge only looks up the two pairs it
+// changes.
+func (e *Encoding) bytePairEncode(piece string, out []int) []int {
+	const none = int(^uint(0) >> 1)
+	type part struct{ start, rank int } // rank of the pair starting here
+	parts := make([]part, len(piece)+1)
+	rank := func(i int) int {
+		if i+2 >= len(parts) {
+			return none
+		}
+		if r, ok := e.ranks[piece[parts[i].start:parts[i+2].start]]; ok {
+			return r
+		}
+		return none
+	}
+	for i := range parts {
+		parts[i].start = i
+	}
+	for i := range parts {
+		parts[i].rank = rank(i)
+	}
+	for len(parts) > 2 {
+		at := 0
+		for i, p := range parts[:len(parts)-2] {
+			if p.rank < parts[at].rank {
+				at = i
+			}
+		}
+		if parts[at].rank == none {
+			break
+		}
+		parts = append(parts[:at+1], parts[at+2:]...)
+		parts[at].rank = rank(at)
+		if at > 0 {
+			parts[at-1].rank = rank(at - 1)
+		}
+	}
+	for i := 0; i+1 < len(parts); i++ {
+		out = append(out, e.ranks[piece[parts[i].start:parts[i+1].start]])
+	}
+	return out
+}
@@ -0,0 +1,184 @@
+package tokenizer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		encoding, text string
+		want           []string
+	}{
+		{CL100K, "Hello world", []string{"Hello", " world"}},
+		{CL100K, "a   b", []string{"a", "  ", " b"}},
+		{CL100K, "x\n\ny", []string{"x", "\n\n", "y"}},
+		{CL100K, "it's 1234!", []string{"it", "'s", " ", "123", "4", "!"}},
+		{CL100K, "trailing  ", []string{"trailing", "  "}},
+		{CL100K, "naïve café", []string{"naïve", " café"}},
+		{O200K, "HelloWorld", []string{"Hello", "World"}},
+		{O200K, "don't stop", []string{"don't", " stop"}},
+		{O200K, "a/b\n", []string{"a", "/b", "\n"}},
+	}
+	for _, tc := range tests {
+		e := newEncoding(tc.encoding, nil, patterns[tc.encoding])
+		if got := e.split(tc.text); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s split(%q) = %q; want %q", tc.encoding, tc.text, got, tc.want)
+		}
+	}
+}
+
+// testVocab is every single byte plus a few merges, ranked in merge order.
+func testVocab() map[string]int {
+	ranks := make(map[string]int)
+	for b := range 256 {
+		ranks[string([]byte{byte(b)})] = b
+	}
+	for i, tok := range []string{"ll", "he", "hell", "hello", " w", "or", " wor"} {
+		ranks[tok] = 256 + i
+	}
+	return ranks
+}
+
+func TestEncode(t *testing.T) {
+	e := newEncoding(CL100K, testVocab(), patterns[CL100K])
+	got := e.Encode("hello world")
+	want := []int{259, 262, 'l', 'd'} // "hello", " wor", "l", "d"
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode = %v; want %v", got, want)
+	}
+	if s := e.Decode(got); s != "hello world" {
+		t.Errorf("Decode(Encode(x)) = %q", s)
+	}
+	if n := e.Count("héllo"); n != 5 { // h, the two bytes of é, ll, o
+		t.Errorf("Count(héllo) = %d; want 5", n)
+	}
+}
+
+func TestParseVocab(t *testing.T) {
+	ranks, err := parseVocab([]byte("aGVsbG8= 0\nIHdvcmxk 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"hello": 0, " world": 1}; !reflect.DeepEqual(ranks, want) {
+		t.Errorf("parseVocab = %v; want %v", ranks, want)
+	}
+	if _, err := parseVocab([]byte("aGVsbG8=\n")); err == nil {
+		t.Error("expected error for a line without a rank")
+	}
+}
+
+func TestGet(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	if _, err := Get("p50k_base"); err == nil {
+		t.Error("expected error for an unknown encoding")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	if err := verify(CL100K, []byte("aGVsbG8= 0\n")); err == nil || !strings.Contains(err.Error(), "SHA-256") {
+		t.Errorf("verify of a tampered vocabulary: %v", err)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	body := "aGVsbG8= 0\n"
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path != "/"+CL100K+".tiktoken" {
+			t.Errorf("GET %s", r.URL.Path)
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	oldURL, oldSum := VocabURL, Checksums[CL100K]
+	VocabURL = srv.URL + "/"
+	t.Cleanup(func() {
+		VocabURL, Checksums[CL100K] = oldURL, oldSum
+		mu.Lock()
+		delete(failed, CL100K)
+		delete(loaded, CL100K)
+		mu.Unlock()
+	})
+
+	if _, err := Get(CL100K); !errors.Is(err, ErrNoVocab) || hits != 0 {
+		t.Errorf("Get without a vocabulary: %v after %d downloads; want ErrNoVocab and none", err, hits)
+	}
+	path := filepath.Join(cache, "q", "tokenizer", CL100K+".tiktoken")
+	if err := Fetch(CL100K); err == nil || !strings.Contains(err.Error(), "SHA-256") {
+		t.Errorf("Fetch of a tampered download: %v; want the SHA-256 mismatch", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("tampered download cached: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte(body))
+	Checksums[CL100K] = hex.EncodeToString(sum[:])
+	if err := Fetch(CL100K); err != nil {
+		t.Fatal(err)
+	}
+	e, err := Get(CL100K)
+	if err != nil {
+		t.Fatalf("Get after Fetch: %v", err)
+	}
+	if got := e.Count("hello"); got != 1 {
+		t.Errorf("Count(hello) = %d; want 1", got)
+	}
+	if err := Fetch(CL100K); err != nil || hits != 2 {
+		t.Errorf("Fetch of a cached vocabulary: %v after %d downloads; want no new download", err, hits)
+	}
+}
+
+// published returns an encoding, downloading its vocabulary if need be. CI
+// has network access, so there a missing vocabulary fails the test.
+func published(t *testing.T, name string) *Encoding {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	if err := Fetch(name); err != nil && os.Getenv("CI") == "" {
+		t.Skipf("%v; offline?", err)
+	}
+	e, err := Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// TestEncode_Published checks tokens against tiktoken's own.
+func TestEncode_Published(t *testing.T) {
+	tests := []struct {
+		encoding, text string
+		want           []int
+	}{
+		{CL100K, "hello world", []int{15339, 1917}},
+		{CL100K, "rer", []int{38149}},
+		{CL100K, "'rer", []int{2351, 81}},
+		{CL100K, "today\n ", []int{31213, 198, 220}},
+		{CL100K, "today\n \n", []int{31213, 27907}},
+		{CL100K, "today\n  \n", []int{31213, 14211}},
+		{CL100K, "👍", []int{9468, 239, 235}},
+		{CL100K, "tiktoken is great!", []int{83, 1609, 5963, 374, 2294, 0}},
+		{O200K, "hello world", []int{24912, 2375}},
+	}
+	for _, tc := range tests {
+		e := published(t, tc.encoding)
+		got := e.Encode(tc.text)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s Encode(%q) = %v; want %v", tc.encoding, tc.text, got, tc.want)
+		}
+		if s := e.Decode(got); s != tc.text {
+			t.Errorf("%s Decode(Encode(%q)) = %q", tc.encoding, tc.text, s)
+		}
+	}
+}